@@ -3,25 +3,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"url-shortener/internal/clicks"
 	"url-shortener/internal/config"
-	"url-shortener/internal/http-server/handlers/url/delete"
 	"url-shortener/internal/http-server/handlers/url/redirect"
-	"url-shortener/internal/http-server/handlers/url/save"
+	"url-shortener/internal/http-server/middleware/ratelimit"
+	"url-shortener/internal/http-server/router"
+	"url-shortener/internal/lib/alias"
 	"url-shortener/internal/lib/logger/handlers/slogpretty"
-
-	//"url-shortener/internal/storage/sqlite"
-	mwLogger "url-shortener/internal/http-server/middleware/logger"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/lib/urlpolicy"
+	"url-shortener/internal/metrics"
+	"url-shortener/internal/reaper"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/storage/memory"
 	"url-shortener/internal/storage/postgres"
+	"url-shortener/internal/storage/sqlite"
+	"url-shortener/internal/tracing"
 
-	//"url-shortener/internal/lib/logger/sl"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
@@ -31,6 +43,21 @@ const (
 	envProd  = "prod"
 )
 
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+	driverMemory   = "memory"
+)
+
+// appStorage is what the handlers and the background jobs need from the storage
+type appStorage interface {
+	router.Storage
+	reaper.ExpiredURLRemover
+	clicks.ClickSaver
+	alias.Sequence
+	Close() error
+}
+
 func main() {
 	configuration := config.MustLoad()
 
@@ -39,71 +66,157 @@ func main() {
 	log.Info("starting url-shortener", slog.String("env", configuration.Env))
 	log.Debug("debug messages are enabled")
 
-	// build connection string for railway
-	var connString string
-	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
-		connString = dbURL
-		log.Info("using database connection string from environment variable DATABASE_URL")
-	} else { // just build off local in case there is none
-		connString = fmt.Sprintf(
-			"postgres://%s:%s@%s:%d/%s?sslmode=%s",
-			configuration.Database.User,
-			configuration.Database.Password,
-			configuration.Database.Host,
-			configuration.Database.Port,
-			configuration.Database.DBName,
-			configuration.Database.SSLMode,
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    configuration.Tracing.Exporter,
+		Path:        configuration.Tracing.Path,
+		Endpoint:    configuration.Tracing.Endpoint,
+		Insecure:    configuration.Tracing.Insecure,
+		SampleRatio: configuration.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Error("failed to init tracing", sl.Err(err))
+		os.Exit(1)
+	}
+
+	// now create storage - runs the migrations of the chosen driver first
+	storage, schemaVersion, err := setupStorage(configuration, log)
+	if err != nil {
+		log.Error("failed to init storage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	appMetrics := metrics.New()
+	// the pool belongs to the backend itself, the instrumented wrapper doesn't have it
+	if db, ok := storage.(metrics.DBStatsGetter); ok {
+		appMetrics.RegisterDBStats(db)
+	}
+	storage = tracing.WrapStorage(appMetrics.InstrumentStorage(storage))
+
+	// stopped only after the server is drained, so clicks of the last redirects still get written
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+
+	// time.NewTicker panics on anything else
+	if configuration.Expiration.ReapInterval <= 0 {
+		log.Error("invalid expiration reap interval, must be positive", slog.Duration("interval", configuration.Expiration.ReapInterval))
+		os.Exit(1)
+	}
+
+	// clean up expired links in the background
+	expiredReaper := reaper.New(
+		log,
+		storage,
+		configuration.Expiration.ReapInterval,
+		configuration.Expiration.Archive,
+	)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		expiredReaper.Run(jobsCtx)
+	}()
+
+	// an unbuffered queue drops clicks whenever the recorder is busy, a batch of 0 writes every click on its own
+	if configuration.Clicks.BufferSize < 1 || configuration.Clicks.BatchSize < 1 {
+		log.Error("invalid clicks buffer or batch size, must be at least 1",
+			slog.Int("buffer_size", configuration.Clicks.BufferSize),
+			slog.Int("batch_size", configuration.Clicks.BatchSize),
 		)
+		os.Exit(1)
+	}
+	if configuration.Clicks.FlushInterval <= 0 {
+		log.Error("invalid clicks flush interval, must be positive", slog.Duration("interval", configuration.Clicks.FlushInterval))
+		os.Exit(1)
 	}
 
-	// ngl I couldn't figure out all the drivers shit with sqlite so I went with postgres
-	// idk I use sqlite at work and I am so fed up with it so I'm biased as well
+	// redirects only queue clicks, this writes them to the storage in batches
+	clickRecorder := clicks.New(
+		log,
+		storage,
+		configuration.Clicks.BufferSize,
+		configuration.Clicks.BatchSize,
+		configuration.Clicks.FlushInterval,
+	)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		clickRecorder.Run(jobsCtx)
+	}()
+
+	if configuration.HTTPServer.User == "" {
+		// fine once keys exist, but on a fresh install nobody can mint the first one
+		log.Warn("bootstrap BasicAuth user is not set, only api keys can authenticate")
+	}
 
-	// run the migrations
-	log.Info("running database migrations")
-	if err := runMigrations(connString); err != nil {
-		log.Error("failed to run migrations", slog.String("error", err.Error()))
+	if !redirect.ValidCode(configuration.Redirect.Code) {
+		log.Error("invalid redirect code, use 301, 302, 307 or 308", slog.Int("code", configuration.Redirect.Code))
 		os.Exit(1)
 	}
-	log.Info("migrations completed successfully")
+	if inactive := configuration.Redirect.InactiveURL; inactive != "" && !absoluteHTTPURL(inactive) {
+		log.Error("invalid redirect inactive url, use an absolute http(s) url", slog.String("url", configuration.Redirect.InactiveURL))
+		os.Exit(1)
+	}
+	if configuration.Redirect.UnlockSecret == "" {
+		log.Warn("redirect unlock secret is not set, visitors of protected links are asked again after a restart")
+	}
 
-	// now create storage
-	storage, err := postgres.New(connString)
+	aliasGen, err := alias.New(alias.Options{
+		Strategy: configuration.Alias.Strategy,
+		Length:   configuration.Alias.Length,
+		Words:    configuration.Alias.Words,
+		Salt:     configuration.Alias.Salt,
+		Alphabet: configuration.Alias.Alphabet,
+	}, storage)
 	if err != nil {
-		log.Error("failed to init storage", slog.String("error", err.Error()))
+		log.Error("failed to init alias generator", sl.Err(err))
 		os.Exit(1)
 	}
+	if configuration.Alias.Strategy == alias.StrategyObfuscated && configuration.Alias.Salt == "" {
+		log.Warn("alias salt is not set, obfuscated aliases can be decoded by anyone who reads the code")
+	}
 
-	//id, err := storage.SaveURL("https://google.com", "google")
-	//if err != nil {
-	//	log.Error("failed to save url", slog.String("error", err.Error()))
-	//	os.Exit(1)
-	//}
-	//
-	//log.Info("saved url", slog.Int64("id", id))
-
-	router := chi.NewRouter()
-	// middleware - other handlers for like auth
-	// this one adds request id to every request
-	router.Use(middleware.RequestID)
-	// why would you need user's IP but alright brodie
-	router.Use(middleware.RealIP)
-	// logging
-	router.Use(middleware.Logger)
-	router.Use(mwLogger.New(log))
-	// in case of panics
-	router.Use(middleware.Recoverer)
-	// /address/{id}
-	router.Use(middleware.URLFormat)
-
-	router.Route("/url", func(r chi.Router) {
-		r.Use(middleware.BasicAuth("url-shortener", map[string]string{
-			configuration.HTTPServer.User: configuration.HTTPServer.Password,
-		}))
-		r.Post("/", save.New(log, storage))
-		r.Delete("/{alias}", delete.New(log, storage))
+	profanity := append(append([]string(nil), alias.DefaultProfanity...), configuration.Alias.Profanity...)
+	if configuration.Alias.ProfanityFile != "" {
+		words, err := alias.LoadWords(configuration.Alias.ProfanityFile)
+		if err != nil {
+			log.Error("failed to load profanity list", sl.Err(err))
+			os.Exit(1)
+		}
+		profanity = append(profanity, words...)
+	}
+	reservedAliases := alias.NewReserved(configuration.Alias.Reserved, profanity)
+
+	urlPolicy, err := urlpolicy.New(urlpolicy.Options{
+		Schemes:      configuration.URLPolicy.Schemes,
+		AllowHosts:   configuration.URLPolicy.AllowHosts,
+		DenyHosts:    configuration.URLPolicy.DenyHosts,
+		AllowFile:    configuration.URLPolicy.AllowFile,
+		DenyFile:     configuration.URLPolicy.DenyFile,
+		AllowPrivate: configuration.URLPolicy.AllowPrivate,
+		SelfHosts:    configuration.URLPolicy.SelfHosts,
 	})
-	router.Get("/{alias}", redirect.New(log, storage))
+	if err != nil {
+		log.Error("failed to init url policy", sl.Err(err))
+		os.Exit(1)
+	}
+
+	var urlCache *cache.Cache
+	if configuration.Cache.Size > 0 {
+		urlCache, err = cache.New(storage, cache.Options{
+			Size:        configuration.Cache.Size,
+			TTL:         configuration.Cache.TTL,
+			NegativeTTL: configuration.Cache.NegativeTTL,
+		})
+		if err != nil {
+			log.Error("failed to init url cache", sl.Err(err))
+			os.Exit(1)
+		}
+		appMetrics.RegisterCache(urlCache)
+	}
+
+	// per instance, replicas sharing their limits would plug a common store in here
+	limitStore := ratelimit.NewMemory()
+
+	handler := router.New(log, configuration, storage, urlCache, clickRecorder, aliasGen, urlPolicy, reservedAliases, limitStore, appMetrics, schemaVersion)
 
 	log.Info("starting server", slog.String("address", configuration.Address))
 
@@ -116,43 +229,158 @@ func main() {
 
 	server := &http.Server{
 		Addr:         address,
-		Handler:      router,
+		Handler:      handler,
 		ReadTimeout:  configuration.HTTPServer.Timeout,
 		WriteTimeout: configuration.HTTPServer.Timeout,
 		IdleTimeout:  configuration.HTTPServer.IdleTimeout,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Error("failed to start server")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		// ErrServerClosed is what Shutdown makes it return, not a failure
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down", slog.String("drain_timeout", configuration.HTTPServer.ShutdownTimeout.String()))
+	case err := <-serverErr:
+		log.Error("failed to start server", sl.Err(err))
+		exitCode = 1
+	}
+
+	// stops accepting connections and waits for the in-flight requests
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), configuration.HTTPServer.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to drain connections", sl.Err(err))
+		exitCode = 1
+	}
+
+	// nothing can queue clicks anymore, flush the rest and stop the reaper
+	stopJobs()
+	jobs.Wait()
+
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+		exitCode = 1
+	}
+
+	// the spans of the last requests are still buffered
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("failed to flush traces", sl.Err(err))
+		exitCode = 1
+	}
+
+	log.Info("server stopped")
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// setupStorage also returns the migration the database ended up at, 0 for memory storage
+func setupStorage(configuration *config.Config, log *slog.Logger) (appStorage, uint, error) {
+	const op = "main.setupStorage"
+
+	timeouts := storage.Timeouts{
+		Read:  configuration.Database.ReadTimeout,
+		Write: configuration.Database.WriteTimeout,
+		Batch: configuration.Database.BatchTimeout,
 	}
 
-	log.Error("server stopped")
+	switch configuration.Storage.Driver {
+	case driverPostgres:
+		// build connection string for railway
+		var connString string
+		if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+			connString = dbURL
+			log.Info("using database connection string from environment variable DATABASE_URL")
+		} else { // just build off local in case there is none
+			connString = fmt.Sprintf(
+				"postgres://%s:%s@%s:%d/%s?sslmode=%s",
+				configuration.Database.User,
+				configuration.Database.Password,
+				configuration.Database.Host,
+				configuration.Database.Port,
+				configuration.Database.DBName,
+				configuration.Database.SSLMode,
+			)
+		}
+
+		log.Info("running database migrations", slog.String("driver", driverPostgres))
+		version, err := runMigrations("file://migrations/postgres", connString)
+		if err != nil {
+			return nil, 0, err
+		}
+		log.Info("migrations completed successfully", slog.Uint64("version", uint64(version)))
+
+		s, err := postgres.New(connString, timeouts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return s, version, nil
+	case driverSQLite:
+		path := configuration.Storage.Path
+
+		log.Info("running database migrations", slog.String("driver", driverSQLite))
+		version, err := runMigrations("file://migrations/sqlite", "sqlite3://"+path)
+		if err != nil {
+			return nil, 0, err
+		}
+		log.Info("migrations completed successfully", slog.Uint64("version", uint64(version)))
+
+		s, err := sqlite.New(path, timeouts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return s, version, nil
+	case driverMemory:
+		// no migrations, no files - everything is gone after restart
+		log.Warn("using in-memory storage, links will not survive a restart")
+
+		return memory.New(), 0, nil
+	default:
+		return nil, 0, fmt.Errorf("%s: unknown storage driver %q", op, configuration.Storage.Driver)
+	}
 }
 
-func runMigrations(connString string) error {
+// runMigrations applies everything up to the newest migration and returns its version
+func runMigrations(sourceURL string, databaseURL string) (uint, error) {
 	const op = "main.runMigrations"
 
 	// create a migration instance
 	m, err := migrate.New(
-		"file://migrations",
-		connString,
+		sourceURL,
+		databaseURL,
 	)
 
 	if err != nil {
-		return fmt.Errorf("%s: failed to create migration instance: %w", op, err)
+		return 0, fmt.Errorf("%s: failed to create migration instance: %w", op, err)
 	}
 
 	defer m.Close()
 
 	if err := m.Up(); err != nil {
 		// migrate.ErrNoChange means all migrations are already applied
-		if err == migrate.ErrNoChange {
-			return nil
+		if err != migrate.ErrNoChange {
+			return 0, fmt.Errorf("%s: failed to run migrations: %w", op, err)
 		}
-		return fmt.Errorf("%s: failed to run migrations: %w", op, err)
 	}
 
-	return nil
+	version, _, err := m.Version()
+	if err != nil {
+		return 0, fmt.Errorf("%s: failed to get schema version: %w", op, err)
+	}
+
+	return version, nil
 }
 
 func setupLogger(env string) *slog.Logger {
@@ -183,3 +411,9 @@ func setupPrettySlog() *slog.Logger {
 
 	return slog.New(handler)
 }
+
+// absoluteHTTPURL - Location of a redirect, a relative one would be resolved against the short link
+func absoluteHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,97 @@
+package clicks
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+)
+
+// ClickSaver writes a batch of clicks, implemented by every storage backend
+type ClickSaver interface {
+	SaveClicks(ctx context.Context, clicks []storage.Click) error
+}
+
+// Recorder takes clicks from the redirect handler and writes them in batches
+// in the background, so a redirect never waits for the database
+type Recorder struct {
+	log           *slog.Logger
+	saver         ClickSaver
+	queue         chan storage.Click
+	batchSize     int
+	flushInterval time.Duration
+}
+
+// New - bufferSize is how many clicks can wait in memory before new ones get dropped
+func New(log *slog.Logger, saver ClickSaver, bufferSize int, batchSize int, flushInterval time.Duration) *Recorder {
+	return &Recorder{
+		log:           log.With(slog.String("component", "clicks/recorder")),
+		saver:         saver,
+		queue:         make(chan storage.Click, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+// Record queues a click and returns right away.
+// if the queue is full the click is dropped - losing a click is better than a slow redirect
+func (r *Recorder) Record(click storage.Click) {
+	select {
+	case r.queue <- click:
+	default:
+		r.log.Warn("click queue is full, dropping click", slog.Int64("url_id", click.URLID))
+	}
+}
+
+// Run blocks and writes batches until ctx is done, then flushes whatever is still queued
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, r.batchSize)
+
+	for {
+		select {
+		case click := <-r.queue:
+			batch = append(batch, click)
+			if len(batch) >= r.batchSize {
+				batch = r.flush(ctx, batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(ctx, batch)
+		case <-ctx.Done():
+			// drain what is already queued, Record may still be called but that's lost anyway
+			for {
+				select {
+				case click := <-r.queue:
+					batch = append(batch, click)
+				default:
+					// ctx is already cancelled, the last batch still gets the storage's own timeout
+					r.flush(context.WithoutCancel(ctx), batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied for reuse
+func (r *Recorder) flush(ctx context.Context, batch []storage.Click) []storage.Click {
+	const op = "clicks.Recorder.flush"
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := r.saver.SaveClicks(ctx, batch); err != nil {
+		// no retries, stats are allowed to be slightly off
+		r.log.Error("failed to save clicks",
+			slog.String("op", op),
+			slog.Int("count", len(batch)),
+			sl.Err(err),
+		)
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,111 @@
+package clicks_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/clicks"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/storage"
+)
+
+// fakeSaver remembers every batch it got, like a real storage it gives up on a cancelled ctx
+type fakeSaver struct {
+	mu      sync.Mutex
+	batches [][]storage.Click
+}
+
+func (f *fakeSaver) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// the recorder reuses its slice, so copy
+	f.batches = append(f.batches, append([]storage.Click(nil), clicks...))
+	return nil
+}
+
+func (f *fakeSaver) total() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestRecorder_FlushesBatches(t *testing.T) {
+	saver := &fakeSaver{}
+	// long interval, so only the batch size triggers a flush
+	r := clicks.New(slogdiscard.NewDiscardLogger(), saver, 100, 5, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	for i := 0; i < 10; i++ {
+		r.Record(storage.Click{URLID: 1})
+	}
+
+	require.Eventually(t, func() bool { return saver.total() == 10 }, time.Second, 5*time.Millisecond)
+
+	saver.mu.Lock()
+	defer saver.mu.Unlock()
+	for _, b := range saver.batches {
+		require.LessOrEqual(t, len(b), 5)
+	}
+}
+
+func TestRecorder_FlushesOnInterval(t *testing.T) {
+	saver := &fakeSaver{}
+	r := clicks.New(slogdiscard.NewDiscardLogger(), saver, 100, 100, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.Record(storage.Click{URLID: 1})
+
+	require.Eventually(t, func() bool { return saver.total() == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestRecorder_DrainsOnStop(t *testing.T) {
+	saver := &fakeSaver{}
+	r := clicks.New(slogdiscard.NewDiscardLogger(), saver, 100, 100, time.Hour)
+
+	// queued before Run even starts, must still end up in storage even though ctx is already done
+	for i := 0; i < 3; i++ {
+		r.Record(storage.Click{URLID: 1})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+
+	require.Equal(t, 3, saver.total())
+}
+
+func TestRecorder_DropsWhenFull(t *testing.T) {
+	saver := &fakeSaver{}
+	r := clicks.New(slogdiscard.NewDiscardLogger(), saver, 2, 100, time.Hour)
+
+	// nobody is consuming, Record must not block
+	for i := 0; i < 5; i++ {
+		r.Record(storage.Click{URLID: 1})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+
+	require.Equal(t, 2, saver.total())
+}
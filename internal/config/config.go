@@ -10,8 +10,25 @@ import (
 
 type Config struct {
 	Env        string   `yaml:"env" env:"ENV" env-default:"local"`
+	Storage    Storage  `yaml:"storage"`
 	Database   Database `yaml:"database"`
 	HTTPServer `yaml:"http_server"`
+	Expiration Expiration `yaml:"expiration"`
+	Clicks     Clicks     `yaml:"clicks"`
+	Alias      Alias      `yaml:"alias"`
+	Redirect   Redirect   `yaml:"redirect"`
+	Cache      Cache      `yaml:"cache"`
+	Tracing    Tracing    `yaml:"tracing"`
+	URLPolicy  URLPolicy  `yaml:"url_policy"`
+	RateLimit  RateLimit  `yaml:"rate_limit"`
+	UTM        UTM        `yaml:"utm"`
+}
+
+// Storage picks the backend - postgres (default), sqlite or memory
+type Storage struct {
+	Driver string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"postgres"`
+	// only used by sqlite
+	Path string `yaml:"path" env:"STORAGE_PATH" env-default:"./storage/storage.db"`
 }
 
 type Database struct {
@@ -22,14 +39,144 @@ type Database struct {
 	Password string `yaml:"password" env:"DB_PASSWORD"`
 	DBName   string `yaml:"dbname" env:"DB_NAME" env-default:"urlshortener"`
 	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE" env-default:"disable"`
+	// how long a single query may take, also used by sqlite. 0 leaves it to the request
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"DB_READ_TIMEOUT" env-default:"2s"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"DB_WRITE_TIMEOUT" env-default:"3s"`
+	// batches, the reaper and the click recorder
+	BatchTimeout time.Duration `yaml:"batch_timeout" env:"DB_BATCH_TIMEOUT" env-default:"10s"`
 }
 
 type HTTPServer struct {
 	Address     string        `yaml:"address" env:"HTTP_ADDRESS" env-default:"localhost:8082"`
 	Timeout     time.Duration `yaml:"timeout" env:"HTTP_TIMEOUT" env-default:"4s"`
 	IdleTimeout time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" env-default:"60s"`
-	User        string        `yaml:"user" env:"HTTP_USER" env-required:"true"`
-	Password    string        `yaml:"password" env:"HTTP_PASSWORD" env-required:"true"`
+	// how long in-flight requests get to finish on SIGINT/SIGTERM before they are cut off
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" env-default:"10s"`
+	// BasicAuth admin for bootstrapping - mint the first api key with it, leave empty to disable
+	User     string `yaml:"user" env:"HTTP_USER"`
+	Password string `yaml:"password" env:"HTTP_PASSWORD"`
+}
+
+// Expiration controls the background reaper that cleans up expired links
+type Expiration struct {
+	ReapInterval time.Duration `yaml:"reap_interval" env:"EXPIRATION_REAP_INTERVAL" env-default:"1m"`
+	// move expired links to url_archive instead of deleting them
+	Archive bool `yaml:"archive" env:"EXPIRATION_ARCHIVE" env-default:"false"`
+}
+
+// Clicks controls how redirects are written to the clicks table in the background
+type Clicks struct {
+	// clicks waiting in memory, when it's full new clicks are dropped
+	BufferSize    int           `yaml:"buffer_size" env:"CLICKS_BUFFER_SIZE" env-default:"1024"`
+	BatchSize     int           `yaml:"batch_size" env:"CLICKS_BATCH_SIZE" env-default:"100"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"CLICKS_FLUSH_INTERVAL" env-default:"1s"`
+}
+
+// Alias controls how aliases are made for links saved without one
+type Alias struct {
+	// random, sequence, obfuscated or words
+	Strategy string `yaml:"strategy" env:"ALIAS_STRATEGY" env-default:"random"`
+	// exact length for random, minimum length for sequence and obfuscated
+	Length int `yaml:"length" env:"ALIAS_LENGTH" env-default:"6"`
+	// number of words for words
+	Words int `yaml:"words" env:"ALIAS_WORDS" env-default:"2"`
+	// scrambles obfuscated aliases, keep it secret and don't change it
+	Salt string `yaml:"salt" env:"ALIAS_SALT"`
+	// characters for random, sequence and obfuscated aliases, letters and digits when empty
+	Alphabet string `yaml:"alphabet" env:"ALIAS_ALPHABET"`
+	// how many generated aliases a link gets before giving up when they are all taken
+	Attempts int `yaml:"attempts" env:"ALIAS_ATTEMPTS" env-default:"5"`
+	// aliases nobody can pick, on top of the routes of the service which are always reserved
+	Reserved []string `yaml:"reserved" env:"ALIAS_RESERVED" env-default:"api,app,docs,help,login,logout,signup,static,assets,status"`
+	// words generated aliases never contain, added to the built-in list. client picked aliases aren't checked
+	Profanity     []string `yaml:"profanity" env:"ALIAS_PROFANITY"`
+	ProfanityFile string   `yaml:"profanity_file" env:"ALIAS_PROFANITY_FILE"`
+}
+
+// Redirect controls how short links answer
+type Redirect struct {
+	// status for links created without redirect_code - 301, 302, 307 or 308
+	Code int `yaml:"code" env:"REDIRECT_CODE" env-default:"302"`
+	// signs the cookies of visitors who typed in the password of a link, share it between replicas.
+	// a random one when empty, visitors are asked again after a restart
+	UnlockSecret string `yaml:"unlock_secret" env:"REDIRECT_UNLOCK_SECRET"`
+	// how long a typed in password is remembered
+	UnlockTTL time.Duration `yaml:"unlock_ttl" env:"REDIRECT_UNLOCK_TTL" env-default:"1h"`
+	// where links before their not_before go, empty answers 404 as if they didn't exist
+	InactiveURL string `yaml:"inactive_url" env:"REDIRECT_INACTIVE_URL"`
+}
+
+// Cache keeps recently redirected links in memory, every instance has its own
+type Cache struct {
+	// how many links are kept, 0 turns the cache off
+	Size int `yaml:"size" env:"CACHE_SIZE" env-default:"10000"`
+	// the longest an update or delete on another instance takes to show up here
+	TTL time.Duration `yaml:"ttl" env:"CACHE_TTL" env-default:"1m"`
+	// how long missing aliases are remembered, 0 doesn't remember them
+	NegativeTTL time.Duration `yaml:"negative_ttl" env:"CACHE_NEGATIVE_TTL" env-default:"5s"`
+}
+
+// Tracing picks where OpenTelemetry spans go
+type Tracing struct {
+	// none, stdout, file or otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	// file for the file exporter
+	Path string `yaml:"path" env:"TRACING_PATH" env-default:"./traces.jsonl"`
+	// host:port of the OTLP/HTTP collector, the OTEL_EXPORTER_OTLP_* variables work too
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	// plain http to the collector
+	Insecure bool `yaml:"insecure" env:"TRACING_INSECURE" env-default:"false"`
+	// share of new traces that are recorded, 1 records all of them
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" env-default:"1"`
+}
+
+// URLPolicy decides which destinations can be shortened, checked on save and update.
+// host patterns are "example.com" for just that host and "*.example.com" for its subdomains
+type URLPolicy struct {
+	Schemes []string `yaml:"schemes" env:"URL_SCHEMES" env-default:"http,https"`
+	// only these hosts, anything when empty
+	AllowHosts []string `yaml:"allow_hosts" env:"URL_ALLOW_HOSTS"`
+	DenyHosts  []string `yaml:"deny_hosts" env:"URL_DENY_HOSTS"`
+	// one pattern per line, added to the lists above
+	AllowFile string `yaml:"allow_file" env:"URL_ALLOW_FILE"`
+	DenyFile  string `yaml:"deny_file" env:"URL_DENY_FILE"`
+	// localhost, 10.0.0.0/8 and so on, only for a service that lives inside a private network
+	AllowPrivate bool `yaml:"allow_private" env:"URL_ALLOW_PRIVATE" env-default:"false"`
+	// the public hosts of the short links, links to them would loop. the Host of the request is always checked too
+	SelfHosts []string `yaml:"self_hosts" env:"URL_SELF_HOSTS"`
+}
+
+// RateLimit is a token bucket per client - Rate requests a second on average, bursts of up to Burst.
+// a Rate of 0 turns the limit off
+type RateLimit struct {
+	// the management api, per api key
+	APIRate  float64 `yaml:"api_rate" env:"RATE_LIMIT_API_RATE" env-default:"10"`
+	APIBurst int     `yaml:"api_burst" env:"RATE_LIMIT_API_BURST" env-default:"20"`
+	// redirects, per client ip
+	RedirectRate  float64 `yaml:"redirect_rate" env:"RATE_LIMIT_REDIRECT_RATE" env-default:"50"`
+	RedirectBurst int     `yaml:"redirect_burst" env:"RATE_LIMIT_REDIRECT_BURST" env-default:"100"`
+	// failed logins on the api, per client ip - only 401s count
+	AuthFailureRate  float64 `yaml:"auth_failure_rate" env:"RATE_LIMIT_AUTH_FAILURE_RATE" env-default:"0.1"`
+	AuthFailureBurst int     `yaml:"auth_failure_burst" env:"RATE_LIMIT_AUTH_FAILURE_BURST" env-default:"10"`
+}
+
+// UTM are the defaults for links saved with a utm object, the fields of the request win
+type UTM struct {
+	Source   string `yaml:"source" env:"UTM_SOURCE"`
+	Medium   string `yaml:"medium" env:"UTM_MEDIUM"`
+	Campaign string `yaml:"campaign" env:"UTM_CAMPAIGN"`
+	Term     string `yaml:"term" env:"UTM_TERM"`
+	Content  string `yaml:"content" env:"UTM_CONTENT"`
+	// per owner id, on top of the ones above. only in the config file
+	Owners map[string]UTMTemplate `yaml:"owners"`
+}
+
+type UTMTemplate struct {
+	Source   string `yaml:"source"`
+	Medium   string `yaml:"medium"`
+	Campaign string `yaml:"campaign"`
+	Term     string `yaml:"term"`
+	Content  string `yaml:"content"`
 }
 
 // MustLoad reads config from YAML file if CONFIG_PATH is set,
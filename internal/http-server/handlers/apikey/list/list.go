@@ -0,0 +1,82 @@
+package list
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// Key is an api key without its hash
+type Key struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	OwnerID    string     `json:"owner_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Keys []Key `json:"keys"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=APIKeyLister
+type APIKeyLister interface {
+	ListAPIKeys(ctx context.Context) ([]storage.APIKey, error)
+}
+
+// New - GET /admin/keys, revoked keys included
+func New(log *slog.Logger, keyLister APIKeyLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.apikey.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		keys, err := keyLister.ListAPIKeys(r.Context())
+		if err != nil {
+			log.Error("failed to list api keys", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to list api keys"))
+
+			return
+		}
+
+		response := Response{
+			Response: resp.OK(),
+			Keys:     make([]Key, 0, len(keys)),
+		}
+		for _, k := range keys {
+			scopes := k.Scopes
+			if scopes == nil {
+				scopes = []string{}
+			}
+			response.Keys = append(response.Keys, Key{
+				ID:         k.ID,
+				Name:       k.Name,
+				Scopes:     scopes,
+				OwnerID:    k.OwnerID,
+				CreatedAt:  k.CreatedAt,
+				LastUsedAt: k.LastUsedAt,
+				RevokedAt:  k.RevokedAt,
+			})
+		}
+
+		log.Info("api keys listed", slog.Int("count", len(response.Keys)))
+
+		render.JSON(w, r, response)
+	}
+}
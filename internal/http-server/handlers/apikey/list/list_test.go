@@ -0,0 +1,85 @@
+package list_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/apikey/list"
+	"url-shortener/internal/http-server/handlers/apikey/list/mocks"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestListHandler(t *testing.T) {
+	revokedAt := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name           string
+		keys           []storage.APIKey
+		respError      string
+		mockError      error
+		expectedStatus int
+	}{
+		{
+			name: "Success",
+			keys: []storage.APIKey{
+				{ID: 1, Name: "ci", Hash: "secret-hash", Scopes: []string{"read"}, OwnerID: "marketing"},
+				{ID: 2, Name: "old", Hash: "other-hash", RevokedAt: &revokedAt},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No keys",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ListAPIKeys error",
+			respError:      "failed to list api keys",
+			mockError:      errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keyListerMock := mocks.NewAPIKeyLister(t)
+			keyListerMock.On("ListAPIKeys", mock.Anything).Return(tc.keys, tc.mockError).Once()
+
+			handler := list.New(slogdiscard.NewDiscardLogger(), keyListerMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/admin/keys", nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			// hashes never leave the server
+			require.NotContains(t, rr.Body.String(), "hash")
+
+			var resp list.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+
+			if tc.mockError == nil {
+				require.Len(t, resp.Keys, len(tc.keys))
+				for i, k := range tc.keys {
+					require.Equal(t, k.ID, resp.Keys[i].ID)
+					require.Equal(t, k.OwnerID, resp.Keys[i].OwnerID)
+					require.Equal(t, k.RevokedAt != nil, resp.Keys[i].RevokedAt != nil)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package mint
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"url-shortener/internal/http-server/middleware/auth"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/apikey"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Request struct {
+	// who the key is for, e.g. "marketing-import"
+	Name string `json:"name" validate:"required,max=100"`
+	// read and write when empty
+	Scopes []string `json:"scopes,omitempty" validate:"omitempty,dive,oneof=read write admin"`
+	// whose links the key works with, the name when empty.
+	// give several keys the same owner to let them share links
+	Owner string `json:"owner,omitempty" validate:"omitempty,max=100"`
+}
+
+type Response struct {
+	resp.Response
+	ID     int64    `json:"id,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	// the only time the key is ever shown
+	Key string `json:"key,omitempty"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=APIKeySaver
+type APIKeySaver interface {
+	SaveAPIKey(ctx context.Context, k storage.APIKey) (int64, error)
+}
+
+// New - POST /admin/keys
+func New(log *slog.Logger, keySaver APIKeySaver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.apikey.mint.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		var req Request
+
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			var validateErr validator.ValidationErrors
+			errors.As(err, &validateErr)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		scopes := req.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{auth.ScopeRead, auth.ScopeWrite}
+		}
+
+		owner := req.Owner
+		if owner == "" {
+			owner = req.Name
+		}
+
+		key, err := apikey.Generate()
+		if err != nil {
+			log.Error("failed to generate api key", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to mint api key"))
+
+			return
+		}
+
+		id, err := keySaver.SaveAPIKey(r.Context(), storage.APIKey{
+			Name:    req.Name,
+			Hash:    apikey.Hash(key),
+			Scopes:  scopes,
+			OwnerID: owner,
+		})
+		if err != nil {
+			log.Error("failed to save api key", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to mint api key"))
+
+			return
+		}
+
+		// never log the key itself
+		log.Info("api key minted", slog.Int64("id", id), slog.String("name", req.Name))
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, Response{
+			Response: resp.Created(),
+			ID:       id,
+			Name:     req.Name,
+			Scopes:   scopes,
+			Owner:    owner,
+			Key:      key,
+		})
+	}
+}
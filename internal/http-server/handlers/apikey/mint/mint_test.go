@@ -0,0 +1,117 @@
+package mint_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/apikey/mint"
+	"url-shortener/internal/http-server/handlers/apikey/mint/mocks"
+	"url-shortener/internal/lib/apikey"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestMintHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		body           string
+		scopes         []string // what gets stored
+		owner          string
+		respError      string
+		mockError      error
+		expectedStatus int
+	}{
+		{
+			name:           "Success",
+			body:           `{"name": "ci", "scopes": ["read"]}`,
+			scopes:         []string{"read"},
+			owner:          "ci",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Shared owner",
+			body:           `{"name": "ci", "owner": "marketing"}`,
+			scopes:         []string{"read", "write"},
+			owner:          "marketing",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Default scopes",
+			body:           `{"name": "ci"}`,
+			scopes:         []string{"read", "write"},
+			owner:          "ci",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Empty name",
+			body:           `{"scopes": ["read"]}`,
+			respError:      "field Name is a required field",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown scope",
+			body:           `{"name": "ci", "scopes": ["root"]}`,
+			respError:      "field Scopes[0] is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "SaveAPIKey error",
+			body:           `{"name": "ci"}`,
+			scopes:         []string{"read", "write"},
+			respError:      "failed to mint api key",
+			mockError:      errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keySaverMock := mocks.NewAPIKeySaver(t)
+
+			var saved storage.APIKey
+			if tc.scopes != nil {
+				keySaverMock.On("SaveAPIKey", mock.Anything, mock.AnythingOfType("storage.APIKey")).
+					Run(func(args mock.Arguments) { saved = args.Get(1).(storage.APIKey) }).
+					Return(int64(1), tc.mockError).Once()
+			}
+
+			handler := mint.New(slogdiscard.NewDiscardLogger(), keySaverMock)
+
+			req, err := http.NewRequest(http.MethodPost, "/admin/keys", bytes.NewReader([]byte(tc.body)))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp mint.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+
+			if tc.scopes != nil {
+				require.Equal(t, tc.scopes, saved.Scopes)
+			}
+			if tc.expectedStatus == http.StatusCreated {
+				require.True(t, strings.HasPrefix(resp.Key, "us_"))
+				// only the hash is stored
+				require.Equal(t, apikey.Hash(resp.Key), saved.Hash)
+				require.NotContains(t, saved.Hash, resp.Key)
+				require.Equal(t, tc.owner, saved.OwnerID)
+				require.Equal(t, tc.owner, resp.Owner)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package revoke_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/apikey/revoke"
+	"url-shortener/internal/http-server/handlers/apikey/revoke/mocks"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestRevokeHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		id             string
+		respError      string
+		mockError      error
+		expectedStatus int
+	}{
+		{
+			name:           "Success",
+			id:             "3",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Not found",
+			id:             "4",
+			respError:      "api key not found",
+			mockError:      storage.ErrAPIKeyNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Not a number",
+			id:             "abc",
+			respError:      "invalid id",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero",
+			id:             "0",
+			respError:      "invalid id",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "RevokeAPIKey error",
+			id:             "5",
+			respError:      "failed to revoke api key",
+			mockError:      errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keyRevokerMock := mocks.NewAPIKeyRevoker(t)
+			if tc.mockError != nil || tc.expectedStatus == http.StatusOK {
+				keyRevokerMock.On("RevokeAPIKey", mock.Anything, mock.AnythingOfType("int64"), mock.AnythingOfType("time.Time")).
+					Return(tc.mockError).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tc.id)
+
+			req, err := http.NewRequest(http.MethodDelete, "/admin/keys/"+tc.id, nil)
+			require.NoError(t, err)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			handler := revoke.New(slogdiscard.NewDiscardLogger(), keyRevokerMock)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp revoke.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+		})
+	}
+}
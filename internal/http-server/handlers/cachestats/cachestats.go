@@ -0,0 +1,44 @@
+package cachestats
+
+import (
+	"net/http"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/storage/cache"
+
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	resp.Response
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+	// hits / (hits + misses), 0 before the first redirect
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=StatsGetter
+type StatsGetter interface {
+	Stats() cache.Stats
+}
+
+// New - GET /admin/cache, counters of the redirect cache of this instance
+func New(statsGetter StatsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := statsGetter.Stats()
+
+		response := Response{
+			Response:  resp.OK(),
+			Hits:      stats.Hits,
+			Misses:    stats.Misses,
+			Evictions: stats.Evictions,
+			Size:      stats.Size,
+		}
+		if total := stats.Hits + stats.Misses; total > 0 {
+			response.HitRatio = float64(stats.Hits) / float64(total)
+		}
+
+		render.JSON(w, r, response)
+	}
+}
@@ -0,0 +1,57 @@
+package cachestats_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/cachestats"
+	"url-shortener/internal/http-server/handlers/cachestats/mocks"
+	"url-shortener/internal/storage/cache"
+)
+
+func TestCacheStatsHandler(t *testing.T) {
+	cases := []struct {
+		name          string
+		stats         cache.Stats
+		expectedRatio float64
+	}{
+		{
+			name:          "Empty",
+			expectedRatio: 0,
+		},
+		{
+			name:          "Hits and misses",
+			stats:         cache.Stats{Hits: 3, Misses: 1, Evictions: 2, Size: 10},
+			expectedRatio: 0.75,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			statsGetterMock := mocks.NewStatsGetter(t)
+			statsGetterMock.On("Stats").Return(tc.stats).Once()
+
+			rr := httptest.NewRecorder()
+			cachestats.New(statsGetterMock).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/cache", nil))
+
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var resp cachestats.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, tc.stats.Hits, resp.Hits)
+			require.Equal(t, tc.stats.Misses, resp.Misses)
+			require.Equal(t, tc.stats.Evictions, resp.Evictions)
+			require.Equal(t, tc.stats.Size, resp.Size)
+			require.InDelta(t, tc.expectedRatio, resp.HitRatio, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	cache "url-shortener/internal/storage/cache"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// StatsGetter is an autogenerated mock type for the StatsGetter type
+type StatsGetter struct {
+	mock.Mock
+}
+
+// Stats provides a mock function with no fields
+func (_m *StatsGetter) Stats() cache.Stats {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Stats")
+	}
+
+	var r0 cache.Stats
+	if rf, ok := ret.Get(0).(func() cache.Stats); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(cache.Stats)
+	}
+
+	return r0
+}
+
+// NewStatsGetter creates a new instance of StatsGetter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewStatsGetter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *StatsGetter {
+	mock := &StatsGetter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,92 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	resp.Response
+	SchemaVersion uint `json:"schema_version,omitempty"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=ReadinessChecker
+type ReadinessChecker interface {
+	Ping(ctx context.Context) error
+	// version of the last applied migration, dirty if it failed halfway
+	SchemaVersion(ctx context.Context) (uint, bool, error)
+}
+
+// NewLive - GET /healthz, answers as long as the process serves http, nothing else is checked
+// so a slow database never gets the instance restarted
+func NewLive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// NewReady - GET /readyz, the storage answers and has every migration this build ships with.
+// schemaVersion is what main migrated to on startup, 0 skips the check (memory storage)
+func NewReady(log *slog.Logger, checker ReadinessChecker, schemaVersion uint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.health.NewReady"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		if err := checker.Ping(r.Context()); err != nil {
+			log.Error("storage is unavailable", sl.Err(err))
+
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, resp.Error("storage is unavailable"))
+
+			return
+		}
+
+		if schemaVersion == 0 {
+			render.JSON(w, r, Response{Response: resp.OK()})
+			return
+		}
+
+		version, dirty, err := checker.SchemaVersion(r.Context())
+		if err != nil {
+			log.Error("failed to get schema version", sl.Err(err))
+
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, resp.Error("storage is unavailable"))
+
+			return
+		}
+
+		// someone rolled the schema back, or a migration broke halfway
+		if dirty || version < schemaVersion {
+			log.Error("migrations are not applied",
+				slog.Uint64("version", uint64(version)),
+				slog.Uint64("want", uint64(schemaVersion)),
+				slog.Bool("dirty", dirty),
+			)
+
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, Response{
+				Response:      resp.Error("migrations are not applied"),
+				SchemaVersion: version,
+			})
+
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response:      resp.OK(),
+			SchemaVersion: version,
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/health"
+	"url-shortener/internal/http-server/handlers/health/mocks"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestLiveHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	health.NewLive().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		want           uint // schema version main migrated to
+		pingError      error
+		version        uint
+		dirty          bool
+		versionError   error
+		respError      string
+		expectedStatus int
+	}{
+		{
+			name:           "Ready",
+			want:           7,
+			version:        7,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Newer schema",
+			want:           7,
+			version:        8,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No migrations",
+			want:           0,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Ping error",
+			want:           7,
+			pingError:      errors.New("connection refused"),
+			respError:      "storage is unavailable",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Old schema",
+			want:           7,
+			version:        6,
+			respError:      "migrations are not applied",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Dirty schema",
+			want:           7,
+			version:        7,
+			dirty:          true,
+			respError:      "migrations are not applied",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "SchemaVersion error",
+			want:           7,
+			versionError:   errors.New("no such table: schema_migrations"),
+			respError:      "storage is unavailable",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			checkerMock := mocks.NewReadinessChecker(t)
+			checkerMock.On("Ping", mock.Anything).Return(tc.pingError).Once()
+			if tc.pingError == nil && tc.want != 0 {
+				checkerMock.On("SchemaVersion", mock.Anything).Return(tc.version, tc.dirty, tc.versionError).Once()
+			}
+
+			handler := health.NewReady(slogdiscard.NewDiscardLogger(), checkerMock, tc.want)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp health.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"url-shortener/internal/http-server/handlers/url/delete"
+	"url-shortener/internal/http-server/handlers/url/save"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/alias"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/lib/utm"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// Result is the outcome of a single item, results are in the same order as the request
+type Result struct {
+	resp.Response
+	Alias string `json:"alias,omitempty"`
+	// the destination as stored, see save.Response
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Protected bool       `json:"protected,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Results []Result `json:"results,omitempty"`
+}
+
+// big enough for marketing imports, small enough to fit in one transaction
+const maxItems = 1000
+
+// every password is a bcrypt hash of 50-80ms, one after another, so a batch of protected links
+// has to fit in HTTP_TIMEOUT with room left for the transaction
+const maxProtected = 20
+
+const (
+	// ModeAtomic - everything is saved or deleted in one transaction, one bad item fails the batch
+	ModeAtomic = "atomic"
+	// ModePartial - every item on its own, good items go through even if others fail
+	ModePartial = "partial"
+)
+
+// items that were fine but didn't go through because of another item
+const errAborted = "batch aborted"
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=URLBatchSaver
+type URLBatchSaver interface {
+	save.URLSaver
+	SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error)
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=URLBatchDeleter
+type URLBatchDeleter interface {
+	delete.URLDeleter
+	DeleteURLs(ctx context.Context, aliases []string, owner string) error
+}
+
+// NewSave - POST /url/batch, body is an array of save.Request, ?mode=partial to not fail on the first bad item.
+// aliasGen, attempts, checker, reserved and templates work the same as in save.New
+func NewSave(log *slog.Logger, urlSaver URLBatchSaver, aliasGen alias.Generator, attempts int, checker save.URLChecker, reserved save.AliasChecker, templates utm.Templates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.batch.NewSave"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		mode, err := parseMode(r)
+		if err != nil {
+			log.Info("invalid mode", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		var reqs []save.Request
+
+		if err := render.DecodeJSON(r.Body, &reqs); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		if err := checkSize(len(reqs)); err != nil {
+			log.Info("invalid batch size", slog.Int("items", len(reqs)))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		if err := checkProtected(reqs); err != nil {
+			log.Info("too many protected links in batch")
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Int("items", len(reqs)), slog.String("mode", mode))
+
+		now := time.Now()
+		template := templates.For(principal.OwnerID)
+		results := make([]Result, len(reqs))
+		urls := make([]storage.URL, len(reqs))
+		// what the client asked for, generated aliases of rolled back items mean nothing
+		requested := make([]string, len(reqs))
+		invalid := 0
+		for i, req := range reqs {
+			requested[i] = req.Alias
+
+			u, errResp, err := save.Prepare(req, now, checker, reserved, template, r.Host)
+			if err != nil {
+				// the server can't take the batch, whatever the mode
+				log.Error("failed to prepare url", slog.Int("item", i), sl.Err(err))
+
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("failed to hash password"))
+
+				return
+			}
+			if errResp != nil {
+				results[i] = Result{Response: *errResp, Alias: req.Alias}
+				invalid++
+				continue
+			}
+			u.OwnerID = principal.OwnerID
+			urls[i] = u
+		}
+
+		if mode == ModePartial {
+			failed := invalid
+			for i, u := range urls {
+				if results[i].Status == resp.StatusError {
+					continue
+				}
+
+				_, u, err := save.Save(r.Context(), urlSaver, aliasGen, attempts, u)
+				switch {
+				case errors.Is(err, storage.ErrUrlExists):
+					results[i] = Result{Response: resp.Error("url already exists"), Alias: u.Alias}
+					failed++
+				case errors.Is(err, alias.ErrNoFreeAlias):
+					log.Error("no free alias", slog.Int("attempts", attempts))
+
+					results[i] = Result{Response: resp.Error("failed to generate alias")}
+					failed++
+				case err != nil:
+					log.Error("failed to add url", slog.String("alias", u.Alias), sl.Err(err))
+
+					results[i] = Result{Response: resp.Error("failed to add url"), Alias: u.Alias}
+					failed++
+				default:
+					results[i] = Result{Response: resp.Created(), Alias: u.Alias, URL: u.URL, ExpiresAt: u.ExpiresAt, NotBefore: u.NotBefore, Protected: u.Protected()}
+				}
+			}
+
+			log.Info("batch saved", slog.Int("items", len(reqs)), slog.Int("failed", failed))
+
+			renderPartial(w, r, results, failed, http.StatusCreated, resp.Created())
+
+			return
+		}
+
+		if invalid > 0 {
+			log.Info("invalid items in batch", slog.Int("invalid", invalid))
+
+			abort(results, requested)
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Response{
+				Response: resp.Error(fmt.Sprintf("%d of %d items are invalid", invalid, len(reqs))),
+				Results:  results,
+			})
+
+			return
+		}
+
+		// aliases given to every link so far, same limit per link as in save.Save
+		tries := make([]int, len(urls))
+		for i := range urls {
+			if requested[i] != "" {
+				continue
+			}
+			if urls[i].Alias, err = aliasGen.Generate(r.Context()); err != nil {
+				break
+			}
+			tries[i]++
+		}
+
+		// a taken generated alias only costs another try of the transaction
+		var batchErr *storage.BatchError
+		for err == nil {
+			_, err = urlSaver.SaveURLs(r.Context(), urls)
+			if !errors.Is(err, storage.ErrUrlExists) || !errors.As(err, &batchErr) || requested[batchErr.Index] != "" {
+				break
+			}
+			if tries[batchErr.Index] == attempts {
+				err = fmt.Errorf("%s: %w", op, alias.ErrNoFreeAlias)
+				break
+			}
+			if urls[batchErr.Index].Alias, err = aliasGen.Generate(r.Context()); err != nil {
+				break
+			}
+			tries[batchErr.Index]++
+		}
+
+		if errors.Is(err, alias.ErrNoFreeAlias) {
+			log.Error("no free alias", slog.Int("attempts", attempts))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to generate alias"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrUrlExists) && errors.As(err, &batchErr) {
+			log.Info("url already exists", slog.String("alias", urls[batchErr.Index].Alias))
+
+			results[batchErr.Index] = Result{Response: resp.Error("url already exists"), Alias: urls[batchErr.Index].Alias}
+			abort(results, requested)
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, Response{
+				Response: resp.Error("url already exists"),
+				Results:  results,
+			})
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to add urls", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to add urls"))
+
+			return
+		}
+
+		for i, u := range urls {
+			results[i] = Result{Response: resp.Created(), Alias: u.Alias, URL: u.URL, ExpiresAt: u.ExpiresAt, NotBefore: u.NotBefore, Protected: u.Protected()}
+		}
+
+		log.Info("batch saved", slog.Int("items", len(urls)))
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, Response{
+			Response: resp.Created(),
+			Results:  results,
+		})
+	}
+}
+
+// NewDelete - DELETE /url/batch, body is an array of aliases, ?mode=partial works the same as for saving
+func NewDelete(log *slog.Logger, urlDeleter URLBatchDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.batch.NewDelete"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		mode, err := parseMode(r)
+		if err != nil {
+			log.Info("invalid mode", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		var aliases []string
+
+		if err := render.DecodeJSON(r.Body, &aliases); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		if err := checkSize(len(aliases)); err != nil {
+			log.Info("invalid batch size", slog.Int("items", len(aliases)))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Int("items", len(aliases)), slog.String("mode", mode))
+
+		results := make([]Result, len(aliases))
+		invalid := 0
+		for i, alias := range aliases {
+			// same check as delete.New
+			if len(alias) < 3 || len(alias) > 15 {
+				results[i] = Result{Response: resp.Error("invalid alias"), Alias: alias}
+				invalid++
+			}
+		}
+
+		if mode == ModePartial {
+			failed := invalid
+			for i, alias := range aliases {
+				if results[i].Status == resp.StatusError {
+					continue
+				}
+
+				err := urlDeleter.DeleteURL(r.Context(), alias, principal.OwnerScope())
+				switch {
+				case errors.Is(err, storage.ErrNoURLDeleted):
+					results[i] = Result{Response: resp.Error("url not found"), Alias: alias}
+					failed++
+				case err != nil:
+					log.Error("failed to delete url", slog.String("alias", alias), sl.Err(err))
+
+					results[i] = Result{Response: resp.Error("failed to delete url"), Alias: alias}
+					failed++
+				default:
+					results[i] = Result{Response: resp.OK(), Alias: alias}
+				}
+			}
+
+			log.Info("batch deleted", slog.Int("items", len(aliases)), slog.Int("failed", failed))
+
+			renderPartial(w, r, results, failed, http.StatusOK, resp.OK())
+
+			return
+		}
+
+		if invalid > 0 {
+			log.Info("invalid items in batch", slog.Int("invalid", invalid))
+
+			abort(results, aliases)
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Response{
+				Response: resp.Error(fmt.Sprintf("%d of %d items are invalid", invalid, len(aliases))),
+				Results:  results,
+			})
+
+			return
+		}
+
+		err = urlDeleter.DeleteURLs(r.Context(), aliases, principal.OwnerScope())
+		var batchErr *storage.BatchError
+		if errors.Is(err, storage.ErrNoURLDeleted) && errors.As(err, &batchErr) {
+			log.Info("url not found", slog.String("alias", aliases[batchErr.Index]))
+
+			results[batchErr.Index] = Result{Response: resp.Error("url not found"), Alias: aliases[batchErr.Index]}
+			abort(results, aliases)
+
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, Response{
+				Response: resp.Error("url not found"),
+				Results:  results,
+			})
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to delete urls", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to delete urls"))
+
+			return
+		}
+
+		for i, alias := range aliases {
+			results[i] = Result{Response: resp.OK(), Alias: alias}
+		}
+
+		log.Info("batch deleted", slog.Int("items", len(aliases)))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Results:  results,
+		})
+	}
+}
+
+func parseMode(r *http.Request) (string, error) {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", ModeAtomic:
+		return ModeAtomic, nil
+	case ModePartial:
+		return ModePartial, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q, use %s or %s", mode, ModeAtomic, ModePartial)
+	}
+}
+
+func checkSize(n int) error {
+	if n == 0 {
+		return errors.New("batch is empty")
+	}
+	if n > maxItems {
+		return fmt.Errorf("batch is too big, at most %d items", maxItems)
+	}
+	return nil
+}
+
+// checkProtected refuses batches with more links behind a password than can be hashed in time
+func checkProtected(reqs []save.Request) error {
+	n := 0
+	for _, req := range reqs {
+		if req.Password != "" {
+			n++
+		}
+	}
+	if n > maxProtected {
+		return fmt.Errorf("too many password protected links, at most %d per batch", maxProtected)
+	}
+	return nil
+}
+
+// abort marks every item without an error of its own as rolled back
+func abort(results []Result, aliases []string) {
+	for i := range results {
+		if results[i].Status == "" {
+			results[i] = Result{Response: resp.Error(errAborted), Alias: aliases[i]}
+		}
+	}
+}
+
+// renderPartial answers with ok (201 or 200) when everything went through
+// and with 207 Multi-Status when some items failed
+func renderPartial(w http.ResponseWriter, r *http.Request, results []Result, failed int, okStatus int, ok resp.Response) {
+	if failed == 0 {
+		render.Status(r, okStatus)
+		render.JSON(w, r, Response{Response: ok, Results: results})
+		return
+	}
+
+	render.Status(r, http.StatusMultiStatus)
+	render.JSON(w, r, Response{
+		Response: resp.Error(fmt.Sprintf("%d of %d items failed", failed, len(results))),
+		Results:  results,
+	})
+}
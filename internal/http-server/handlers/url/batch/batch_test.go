@@ -0,0 +1,436 @@
+package batch_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/url/batch"
+	"url-shortener/internal/http-server/handlers/url/batch/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/alias"
+	aliasMocks "url-shortener/internal/lib/alias/mocks"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/lib/urlpolicy"
+	"url-shortener/internal/lib/utm"
+)
+
+var user = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeWrite}, OwnerID: "alice"}
+
+func newPolicy(t *testing.T) *urlpolicy.Policy {
+	t.Helper()
+
+	p, err := urlpolicy.New(urlpolicy.Options{DenyHosts: []string{"evil.com"}})
+	require.NoError(t, err)
+	return p
+}
+
+func newReserved() *alias.Reserved {
+	return alias.NewReserved([]string{"metrics"}, nil)
+}
+
+func TestSaveHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		mode           string
+		body           string
+		setup          func(m *mocks.URLBatchSaver)
+		respError      string
+		itemErrors     []string
+		expectedStatus int
+	}{
+		{
+			name: "Success",
+			body: `[{"url": "https://google.com", "alias": "google"}, {"url": "https://yahoo.com"}]`,
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, mock.MatchedBy(func(urls []storage.URL) bool {
+					return len(urls) == 2 && urls[0].Alias == "google" && urls[1].Alias != "" &&
+						urls[0].OwnerID == "alice" && urls[1].OwnerID == "alice"
+				})).Return([]int64{1, 2}, nil).Once()
+			},
+			itemErrors:     []string{"", ""},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Invalid item fails the batch",
+			body:           `[{"url": "https://google.com", "alias": "google"}, {"url": "not a url"}]`,
+			respError:      "1 of 2 items are invalid",
+			itemErrors:     []string{"batch aborted", "field URL is not a valid URL"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Blocked item fails the batch",
+			body:           `[{"url": "javascript:alert(1)"}, {"url": "https://evil.com"}, {"url": "https://google.com"}]`,
+			respError:      "2 of 3 items are invalid",
+			itemErrors:     []string{`field URL is not allowed: scheme is not allowed: "javascript"`, "field URL is not allowed: host is blocked: evil.com", "batch aborted"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Reserved alias fails the batch",
+			body:           `[{"url": "https://google.com", "alias": "metrics"}, {"url": "https://yahoo.com"}]`,
+			respError:      "1 of 2 items are invalid",
+			itemErrors:     []string{"field Alias is not allowed: alias is reserved", "batch aborted"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Duplicate alias rolls back",
+			body: `[{"url": "https://google.com", "alias": "google"}, {"url": "https://yahoo.com", "alias": "yahoo"}]`,
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, mock.Anything).
+					Return(nil, fmt.Errorf("storage: %w", &storage.BatchError{Index: 1, Err: storage.ErrUrlExists})).Once()
+			},
+			respError:      "url already exists",
+			itemErrors:     []string{"batch aborted", "url already exists"},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Storage error",
+			body: `[{"url": "https://google.com", "alias": "google"}]`,
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, mock.Anything).Return(nil, errors.New("unexpected error")).Once()
+			},
+			respError:      "failed to add urls",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "Partial",
+			mode: "partial",
+			body: `[{"url": "https://google.com", "alias": "google"}, {"url": "not a url"}, {"url": "https://yahoo.com", "alias": "yahoo"}]`,
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == "google" })).
+					Return(int64(1), nil).Once()
+				m.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == "yahoo" })).
+					Return(int64(0), storage.ErrUrlExists).Once()
+			},
+			respError:      "2 of 3 items failed",
+			itemErrors:     []string{"", "field URL is not a valid URL", "url already exists"},
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name: "Partial all good",
+			mode: "partial",
+			body: `[{"url": "https://google.com", "alias": "google"}]`,
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURL", mock.Anything, mock.Anything).Return(int64(1), nil).Once()
+			},
+			itemErrors:     []string{""},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Empty batch",
+			body:           `[]`,
+			respError:      "batch is empty",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not an array",
+			body:           `{"url": "https://google.com"}`,
+			respError:      "failed to decode request",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown mode",
+			mode:           "yolo",
+			body:           `[{"url": "https://google.com"}]`,
+			respError:      `invalid mode "yolo", use atomic or partial`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			urlSaverMock := mocks.NewURLBatchSaver(t)
+			if tc.setup != nil {
+				tc.setup(urlSaverMock)
+			}
+
+			handler := batch.NewSave(slogdiscard.NewDiscardLogger(), urlSaverMock, alias.Random{Length: 6}, 3, newPolicy(t), newReserved(), utm.Templates{})
+
+			req, err := http.NewRequest(http.MethodPost, "/url/batch?mode="+tc.mode, bytes.NewReader([]byte(tc.body)))
+			require.NoError(t, err)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp batch.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			requireItemErrors(t, tc.itemErrors, resp.Results)
+		})
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		mode           string
+		body           string
+		setup          func(m *mocks.URLBatchDeleter)
+		respError      string
+		itemErrors     []string
+		expectedStatus int
+	}{
+		{
+			name: "Success",
+			body: `["google", "yahoo"]`,
+			setup: func(m *mocks.URLBatchDeleter) {
+				m.On("DeleteURLs", mock.Anything, []string{"google", "yahoo"}, "alice").Return(nil).Once()
+			},
+			itemErrors:     []string{"", ""},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid alias fails the batch",
+			body:           `["google", "ab"]`,
+			respError:      "1 of 2 items are invalid",
+			itemErrors:     []string{"batch aborted", "invalid alias"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Missing alias rolls back",
+			body: `["google", "yahoo"]`,
+			setup: func(m *mocks.URLBatchDeleter) {
+				m.On("DeleteURLs", mock.Anything, []string{"google", "yahoo"}, "alice").
+					Return(fmt.Errorf("storage: %w", &storage.BatchError{Index: 0, Err: storage.ErrNoURLDeleted})).Once()
+			},
+			respError:      "url not found",
+			itemErrors:     []string{"url not found", "batch aborted"},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Storage error",
+			body: `["google"]`,
+			setup: func(m *mocks.URLBatchDeleter) {
+				m.On("DeleteURLs", mock.Anything, []string{"google"}, "alice").Return(errors.New("unexpected error")).Once()
+			},
+			respError:      "failed to delete urls",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "Partial",
+			mode: "partial",
+			body: `["google", "yahoo", "ab"]`,
+			setup: func(m *mocks.URLBatchDeleter) {
+				m.On("DeleteURL", mock.Anything, "google", "alice").Return(nil).Once()
+				m.On("DeleteURL", mock.Anything, "yahoo", "alice").Return(storage.ErrNoURLDeleted).Once()
+			},
+			respError:      "2 of 3 items failed",
+			itemErrors:     []string{"", "url not found", "invalid alias"},
+			expectedStatus: http.StatusMultiStatus,
+		},
+		{
+			name:           "Empty batch",
+			body:           `[]`,
+			respError:      "batch is empty",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			urlDeleterMock := mocks.NewURLBatchDeleter(t)
+			if tc.setup != nil {
+				tc.setup(urlDeleterMock)
+			}
+
+			handler := batch.NewDelete(slogdiscard.NewDiscardLogger(), urlDeleterMock)
+
+			req, err := http.NewRequest(http.MethodDelete, "/url/batch?mode="+tc.mode, bytes.NewReader([]byte(tc.body)))
+			require.NoError(t, err)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp batch.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			requireItemErrors(t, tc.itemErrors, resp.Results)
+		})
+	}
+}
+
+func TestSaveHandler_TooBig(t *testing.T) {
+	items := make([]map[string]string, 1001)
+	for i := range items {
+		items[i] = map[string]string{"url": "https://google.com"}
+	}
+	body, err := json.Marshal(items)
+	require.NoError(t, err)
+
+	handler := batch.NewSave(slogdiscard.NewDiscardLogger(), mocks.NewURLBatchSaver(t), alias.Random{Length: 6}, 3, newPolicy(t), newReserved(), utm.Templates{})
+
+	req, err := http.NewRequest(http.MethodPost, "/url/batch", bytes.NewReader(body))
+	require.NoError(t, err)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "batch is too big")
+}
+
+func TestSaveHandler_TooManyProtected(t *testing.T) {
+	items := make([]map[string]string, 21)
+	for i := range items {
+		items[i] = map[string]string{"url": "https://google.com", "password": "letmein"}
+	}
+	body, err := json.Marshal(items)
+	require.NoError(t, err)
+
+	handler := batch.NewSave(slogdiscard.NewDiscardLogger(), mocks.NewURLBatchSaver(t), alias.Random{Length: 6}, 3, newPolicy(t), newReserved(), utm.Templates{})
+
+	req, err := http.NewRequest(http.MethodPost, "/url/batch", bytes.NewReader(body))
+	require.NoError(t, err)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "at most 20 per batch")
+}
+
+func TestSaveHandler_Retry(t *testing.T) {
+	aliases := func(urls []storage.URL) []string {
+		out := make([]string, len(urls))
+		for i, u := range urls {
+			out[i] = u.Alias
+		}
+		return out
+	}
+	withAliases := func(expected ...string) any {
+		return mock.MatchedBy(func(urls []storage.URL) bool {
+			return fmt.Sprint(aliases(urls)) == fmt.Sprint(expected)
+		})
+	}
+
+	cases := []struct {
+		name           string
+		mode           string
+		generated      []string
+		setup          func(m *mocks.URLBatchSaver)
+		respError      string
+		expectedAlias  []string
+		expectedStatus int
+	}{
+		{
+			name:      "Atomic",
+			generated: []string{"gen1", "gen2", "gen3"},
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, withAliases("mine", "gen1", "gen2")).
+					Return(nil, &storage.BatchError{Index: 2, Err: storage.ErrUrlExists}).Once()
+				m.On("SaveURLs", mock.Anything, withAliases("mine", "gen1", "gen3")).
+					Return([]int64{1, 2, 3}, nil).Once()
+			},
+			expectedAlias:  []string{"mine", "gen1", "gen3"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:      "Atomic out of attempts",
+			generated: []string{"gen1", "gen2", "gen3", "gen4"},
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, mock.Anything).
+					Return(nil, &storage.BatchError{Index: 1, Err: storage.ErrUrlExists}).Times(3)
+			},
+			respError:      "failed to generate alias",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:      "Atomic requested alias taken",
+			generated: []string{"gen1", "gen2"},
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURLs", mock.Anything, withAliases("mine", "gen1", "gen2")).
+					Return(nil, &storage.BatchError{Index: 0, Err: storage.ErrUrlExists}).Once()
+			},
+			respError:      "url already exists",
+			expectedAlias:  []string{"mine", "", ""},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:      "Partial",
+			mode:      "partial",
+			generated: []string{"gen1", "gen2", "gen3"},
+			setup: func(m *mocks.URLBatchSaver) {
+				m.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == "mine" })).
+					Return(int64(1), nil).Once()
+				m.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == "gen1" })).
+					Return(int64(0), storage.ErrUrlExists).Once()
+				m.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == "gen2" || u.Alias == "gen3" })).
+					Return(int64(2), nil).Twice()
+			},
+			expectedAlias:  []string{"mine", "gen2", "gen3"},
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			genMock := aliasMocks.NewGenerator(t)
+			for _, a := range tc.generated {
+				genMock.On("Generate", mock.Anything).Return(a, nil).Once()
+			}
+
+			urlSaverMock := mocks.NewURLBatchSaver(t)
+			tc.setup(urlSaverMock)
+
+			handler := batch.NewSave(slogdiscard.NewDiscardLogger(), urlSaverMock, genMock, 3, newPolicy(t), newReserved(), utm.Templates{})
+
+			body := `[{"url": "https://google.com", "alias": "mine"}, {"url": "https://yahoo.com"}, {"url": "https://bing.com"}]`
+			req, err := http.NewRequest(http.MethodPost, "/url/batch?mode="+tc.mode, bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp batch.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			if tc.expectedAlias != nil {
+				require.Len(t, resp.Results, len(tc.expectedAlias))
+				for i, a := range tc.expectedAlias {
+					require.Equal(t, a, resp.Results[i].Alias)
+				}
+			}
+		})
+	}
+}
+
+func requireItemErrors(t *testing.T, expected []string, results []batch.Result) {
+	t.Helper()
+
+	require.Len(t, results, len(expected))
+	for i, r := range results {
+		require.Equal(t, expected[i], r.Error, "item %d", i)
+		require.NotEmpty(t, r.Status, "item %d", i)
+	}
+}
@@ -0,0 +1,64 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// URLBatchDeleter is an autogenerated mock type for the URLBatchDeleter type
+type URLBatchDeleter struct {
+	mock.Mock
+}
+
+// DeleteURL provides a mock function with given fields: ctx, alias, owner
+func (_m *URLBatchDeleter) DeleteURL(ctx context.Context, alias string, owner string) error {
+	ret := _m.Called(ctx, alias, owner)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteURL")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, alias, owner)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteURLs provides a mock function with given fields: ctx, aliases, owner
+func (_m *URLBatchDeleter) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	ret := _m.Called(ctx, aliases, owner)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteURLs")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, string) error); ok {
+		r0 = rf(ctx, aliases, owner)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NewURLBatchDeleter creates a new instance of URLBatchDeleter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewURLBatchDeleter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *URLBatchDeleter {
+	mock := &URLBatchDeleter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,87 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	storage "url-shortener/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// URLBatchSaver is an autogenerated mock type for the URLBatchSaver type
+type URLBatchSaver struct {
+	mock.Mock
+}
+
+// SaveURL provides a mock function with given fields: ctx, u
+func (_m *URLBatchSaver) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
+	ret := _m.Called(ctx, u)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveURL")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.URL) (int64, error)); ok {
+		return rf(ctx, u)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, storage.URL) int64); ok {
+		r0 = rf(ctx, u)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, storage.URL) error); ok {
+		r1 = rf(ctx, u)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveURLs provides a mock function with given fields: ctx, urls
+func (_m *URLBatchSaver) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	ret := _m.Called(ctx, urls)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveURLs")
+	}
+
+	var r0 []int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []storage.URL) ([]int64, error)); ok {
+		return rf(ctx, urls)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []storage.URL) []int64); ok {
+		r0 = rf(ctx, urls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int64)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []storage.URL) error); ok {
+		r1 = rf(ctx, urls)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewURLBatchSaver creates a new instance of URLBatchSaver. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewURLBatchSaver(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *URLBatchSaver {
+	mock := &URLBatchSaver{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
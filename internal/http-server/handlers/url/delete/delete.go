@@ -1,16 +1,20 @@
 package delete
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/http"
+	"url-shortener/internal/http-server/middleware/auth"
 	resp "url-shortener/internal/lib/api/response"
 	"url-shortener/internal/lib/logger/sl"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Response struct {
@@ -19,19 +23,37 @@ type Response struct {
 }
 
 type URLDeleter interface {
-	DeleteURL(alias string) error
+	// owner limits the delete to links of that owner, "" for any
+	DeleteURL(ctx context.Context, alias string, owner string) error
 }
 
 func New(log *slog.Logger, urlDeleter URLDeleter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.delete.New"
 
-		log = log.With(
+		ctx, span := tracing.Start(r.Context(), op)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
 		)
 
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
 		alias := chi.URLParam(r, "alias")
+		span.SetAttributes(attribute.String("alias", alias))
 
 		if alias == "" {
 			log.Info("alias is empty")
@@ -50,7 +72,7 @@ func New(log *slog.Logger, urlDeleter URLDeleter) http.HandlerFunc {
 			return
 		}
 
-		err := urlDeleter.DeleteURL(alias)
+		err := urlDeleter.DeleteURL(r.Context(), alias, principal.OwnerScope())
 		if errors.Is(err, storage.ErrNoURLDeleted) {
 			log.Info("url not found", slog.String("alias", alias))
 
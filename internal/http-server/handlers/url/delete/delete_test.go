@@ -12,13 +12,20 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"url-shortener/internal/http-server/handlers/url/delete"
 	"url-shortener/internal/http-server/handlers/url/delete/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
 	"url-shortener/internal/lib/logger/handlers/slogdiscard"
 )
 
+var (
+	user  = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeRead, auth.ScopeWrite}, OwnerID: "alice"}
+	admin = auth.Principal{Name: "root", Scopes: []string{auth.ScopeAdmin}, OwnerID: "root"}
+)
+
 //go:generate go run github.com/vektra/mockery/v2@latest --name=URLDeleter
 func TestDeleteHandler(t *testing.T) {
 	cases := []struct {
@@ -27,6 +34,8 @@ func TestDeleteHandler(t *testing.T) {
 		respError      string
 		mockError      error
 		expectedStatus int
+		admin          bool // deletes anyone's link
+		anonymous      bool // no principal at all
 	}{
 		{
 			name:           "Success",
@@ -35,6 +44,19 @@ func TestDeleteHandler(t *testing.T) {
 			mockError:      nil,
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Admin",
+			alias:          "test_alias",
+			admin:          true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No principal",
+			alias:          "test_alias",
+			respError:      "unauthorized",
+			anonymous:      true,
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:           "Empty alias",
 			alias:          "",
@@ -78,8 +100,12 @@ func TestDeleteHandler(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			urlDeleterMock := mocks.NewURLDeleter(t)
-			if tc.alias != "" && len(tc.alias) >= 3 && len(tc.alias) <= 15 { // empty alias case does not call DeleteURL
-				urlDeleterMock.On("DeleteURL", tc.alias).Return(tc.mockError).Once()
+			principal := user
+			if tc.admin {
+				principal = admin
+			}
+			if !tc.anonymous && tc.alias != "" && len(tc.alias) >= 3 && len(tc.alias) <= 15 { // empty alias case does not call DeleteURL
+				urlDeleterMock.On("DeleteURL", mock.Anything, tc.alias, principal.OwnerScope()).Return(tc.mockError).Once()
 			}
 			// create chi's route context that hold the url params
 			rctx := chi.NewRouteContext()
@@ -89,6 +115,10 @@ func TestDeleteHandler(t *testing.T) {
 			require.NoError(t, err)
 			// attach the chi context to the request
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			// auth.New puts the principal there in the real router
+			if !tc.anonymous {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+			}
 			// create delete handler
 			handler := delete.New(slogdiscard.NewDiscardLogger(), urlDeleterMock)
 			// run the handler
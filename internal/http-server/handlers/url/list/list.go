@@ -0,0 +1,286 @@
+package list
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"url-shortener/internal/http-server/middleware/auth"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/lib/utm"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type Response struct {
+	resp.Response
+	URLs []URL `json:"urls"`
+	// pass it back as ?cursor= to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type URL struct {
+	ID        int64      `json:"id"`
+	Alias     string     `json:"alias"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// send it back as If-Match when updating
+	Version int64  `json:"version"`
+	OwnerID string `json:"owner_id,omitempty"`
+	// empty when the link follows the server default
+	RedirectCode int `json:"redirect_code,omitempty"`
+	// asks for a password before redirecting
+	Protected bool `json:"protected,omitempty"`
+	// only for links with a click limit, clicks_left is 0 once they are used up
+	MaxClicks  int64  `json:"max_clicks,omitempty"`
+	ClicksLeft *int64 `json:"clicks_left,omitempty"`
+	// the redirect passes the rest of the path and the query on
+	ForwardPath  bool `json:"forward_path,omitempty"`
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// only for links tagged on redirect, the others have the params in url
+	UTM *utm.Params `json:"utm,omitempty"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=URLLister
+type URLLister interface {
+	ListURLs(ctx context.Context, filter storage.ListFilter) ([]storage.URL, error)
+}
+
+// cursor is the last row of a page plus the order it was read in,
+// so a cursor from one sort can't be used with another
+type cursor struct {
+	ID        int64            `json:"id"`
+	Alias     string           `json:"alias"`
+	CreatedAt time.Time        `json:"created_at"`
+	Sort      storage.ListSort `json:"sort"`
+	Desc      bool             `json:"desc"`
+}
+
+// New - GET /url?limit=&cursor=&alias_prefix=&host=&created_from=&created_to=&state=&sort=&order=
+func New(log *slog.Logger, urlLister URLLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		filter, err := parseFilter(r)
+		if err != nil {
+			log.Info("invalid query", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		// everyone sees their own links, admins see all of them and can pick an owner
+		filter.Owner = principal.OwnerScope()
+		if filter.Owner == "" {
+			filter.Owner = r.URL.Query().Get("owner")
+		}
+
+		// one extra row tells us if there is a next page
+		limit := filter.Limit
+		filter.Limit++
+
+		urls, err := urlLister.ListURLs(r.Context(), filter)
+		if err != nil {
+			log.Error("failed to list urls", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to list urls"))
+
+			return
+		}
+
+		response := Response{
+			Response: resp.OK(),
+			URLs:     make([]URL, 0, limit),
+		}
+
+		if len(urls) > limit {
+			urls = urls[:limit]
+
+			last := urls[len(urls)-1]
+			response.NextCursor = encodeCursor(cursor{
+				ID:        last.ID,
+				Alias:     last.Alias,
+				CreatedAt: last.CreatedAt,
+				Sort:      filter.Sort,
+				Desc:      filter.Desc,
+			})
+		}
+
+		for _, u := range urls {
+			response.URLs = append(response.URLs, URL{
+				ID:           u.ID,
+				Alias:        u.Alias,
+				URL:          u.URL,
+				CreatedAt:    u.CreatedAt,
+				ExpiresAt:    u.ExpiresAt,
+				NotBefore:    u.NotBefore,
+				Version:      u.Version,
+				OwnerID:      u.OwnerID,
+				RedirectCode: u.RedirectCode,
+				Protected:    u.Protected(),
+				MaxClicks:    u.MaxClicks,
+				ClicksLeft:   clicksLeft(u),
+				ForwardPath:  u.ForwardPath,
+				ForwardQuery: u.ForwardQuery,
+				UTM:          utmParams(u),
+			})
+		}
+
+		log.Info("urls listed", slog.Int("count", len(response.URLs)))
+
+		render.JSON(w, r, response)
+	}
+}
+
+// parseFilter turns the query into a storage filter, errors are safe to show to the client
+func parseFilter(r *http.Request) (storage.ListFilter, error) {
+	q := r.URL.Query()
+
+	filter := storage.ListFilter{
+		AliasPrefix: q.Get("alias_prefix"),
+		Host:        q.Get("host"),
+		Sort:        storage.SortByID,
+		// newest first unless asked otherwise
+		Desc:  true,
+		Limit: defaultLimit,
+	}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return storage.ListFilter{}, errInvalid("limit")
+		}
+		filter.Limit = limit
+	}
+
+	switch sort := storage.ListSort(q.Get("sort")); sort {
+	case "":
+	case storage.SortByID, storage.SortByCreatedAt, storage.SortByAlias:
+		filter.Sort = sort
+	default:
+		return storage.ListFilter{}, errInvalid("sort")
+	}
+
+	// scheduled, active or expired as of now
+	switch state := storage.ListState(q.Get("state")); state {
+	case "":
+	case storage.StateScheduled, storage.StateActive, storage.StateExpired:
+		filter.State = state
+		filter.Now = time.Now()
+	default:
+		return storage.ListFilter{}, errInvalid("state")
+	}
+
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Desc = false
+	default:
+		return storage.ListFilter{}, errInvalid("order")
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+	} {
+		raw := q.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return storage.ListFilter{}, errInvalid(p.name)
+		}
+		*p.dst = &t
+	}
+
+	if raw := q.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil || c.Sort != filter.Sort || c.Desc != filter.Desc {
+			return storage.ListFilter{}, errInvalid("cursor")
+		}
+		filter.After = &storage.URL{ID: c.ID, Alias: c.Alias, CreatedAt: c.CreatedAt}
+	}
+
+	return filter, nil
+}
+
+// cursors are opaque for clients, base64 just keeps them url safe
+func encodeCursor(c cursor) string {
+	// marshalling a struct of plain fields can't fail
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return cursor{}, err
+	}
+	return c, nil
+}
+
+func errInvalid(param string) error {
+	return fmt.Errorf("invalid %s", param)
+}
+
+func clicksLeft(u storage.URL) *int64 {
+	if u.MaxClicks == 0 {
+		return nil
+	}
+	return &u.ClicksLeft
+}
+
+func utmParams(u storage.URL) *utm.Params {
+	if u.UTM == "" {
+		return nil
+	}
+	// the storage only ever gets what utm.Params.Encode wrote
+	p, err := utm.Decode(u.UTM)
+	if err != nil {
+		return nil
+	}
+	return &p
+}
@@ -0,0 +1,261 @@
+package list_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/url/list"
+	"url-shortener/internal/http-server/handlers/url/list/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/storage"
+)
+
+var (
+	user  = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeRead}, OwnerID: "alice"}
+	admin = auth.Principal{Name: "root", Scopes: []string{auth.ScopeAdmin}, OwnerID: "root"}
+)
+
+func TestListHandler(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// n links with ids n..1, the default order is newest first
+	urls := func(n int) []storage.URL {
+		var out []storage.URL
+		for i := n; i > 0; i-- {
+			out = append(out, storage.URL{ID: int64(i), Alias: "alias", URL: "https://google.com", CreatedAt: created})
+		}
+		return out
+	}
+
+	cases := []struct {
+		name           string
+		query          string
+		expectFilter   func(f storage.ListFilter) bool // nil means the storage is not called
+		mockURLs       []storage.URL
+		mockError      error
+		respError      string
+		expectedStatus int
+		expectedCount  int
+		expectCursor   bool
+		admin          bool
+	}{
+		{
+			name:  "Defaults",
+			query: "",
+			expectFilter: func(f storage.ListFilter) bool {
+				// one more than the page size, to know if there is a next page
+				return f.Limit == 21 && f.Sort == storage.SortByID && f.Desc && f.After == nil && f.Owner == "alice"
+			},
+			mockURLs:       urls(3),
+			expectedStatus: http.StatusOK,
+			expectedCount:  3,
+		},
+		{
+			name:  "Owner is ignored for users",
+			query: "?owner=bob",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.Owner == "alice"
+			},
+			mockURLs:       urls(1),
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:  "Admin sees everyone",
+			query: "",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.Owner == ""
+			},
+			mockURLs:       urls(2),
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			admin:          true,
+		},
+		{
+			name:  "Admin picks an owner",
+			query: "?owner=bob",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.Owner == "bob"
+			},
+			mockURLs:       urls(1),
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			admin:          true,
+		},
+		{
+			name:  "Has next page",
+			query: "?limit=2",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.Limit == 3
+			},
+			mockURLs:       urls(3),
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectCursor:   true,
+		},
+		{
+			name:  "Filters",
+			query: "?alias_prefix=go&host=example.com&created_from=2025-01-01T00:00:00Z&created_to=2025-02-01T00:00:00Z&sort=alias&order=asc",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.AliasPrefix == "go" &&
+					f.Host == "example.com" &&
+					f.CreatedFrom != nil && f.CreatedFrom.Equal(created) &&
+					f.CreatedTo != nil && f.CreatedTo.Equal(created.AddDate(0, 1, 0)) &&
+					f.Sort == storage.SortByAlias &&
+					!f.Desc
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Scheduled",
+			query: "?state=scheduled",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.State == storage.StateScheduled && !f.Now.IsZero()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "No state means every link",
+			query: "",
+			expectFilter: func(f storage.ListFilter) bool {
+				return f.State == ""
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid state",
+			query:          "?state=paused",
+			respError:      "invalid state",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=1000",
+			respError:      "invalid limit",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid sort",
+			query:          "?sort=url",
+			respError:      "invalid sort",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid order",
+			query:          "?order=up",
+			respError:      "invalid order",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid date",
+			query:          "?created_from=yesterday",
+			respError:      "invalid created_from",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid cursor",
+			query:          "?cursor=not-a-cursor!",
+			respError:      "invalid cursor",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "ListURLs error",
+			query:          "",
+			expectFilter:   func(f storage.ListFilter) bool { return true },
+			mockError:      errors.New("unexpected error"),
+			respError:      "failed to list urls",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			urlListerMock := mocks.NewURLLister(t)
+			if tc.expectFilter != nil {
+				urlListerMock.On("ListURLs", mock.Anything, mock.MatchedBy(tc.expectFilter)).
+					Return(tc.mockURLs, tc.mockError).
+					Once()
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "/url"+tc.query, nil)
+			require.NoError(t, err)
+
+			principal := user
+			if tc.admin {
+				principal = admin
+			}
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+
+			rr := httptest.NewRecorder()
+			list.New(slogdiscard.NewDiscardLogger(), urlListerMock).ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp list.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			require.Len(t, resp.URLs, tc.expectedCount)
+			require.Equal(t, tc.expectCursor, resp.NextCursor != "")
+		})
+	}
+}
+
+// the cursor of one page has to bring back the last row of it as the keyset
+func TestListHandler_Cursor(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	urlListerMock := mocks.NewURLLister(t)
+	urlListerMock.On("ListURLs", mock.Anything, mock.MatchedBy(func(f storage.ListFilter) bool { return f.After == nil })).
+		Return([]storage.URL{
+			{ID: 1, Alias: "aaa", CreatedAt: created},
+			{ID: 2, Alias: "bbb", CreatedAt: created},
+		}, nil).Once()
+	urlListerMock.On("ListURLs", mock.Anything, mock.MatchedBy(func(f storage.ListFilter) bool {
+		return f.After != nil && f.After.ID == 1 && f.After.Alias == "aaa" && f.After.CreatedAt.Equal(created)
+	})).Return([]storage.URL{{ID: 2, Alias: "bbb", CreatedAt: created}}, nil).Once()
+
+	handler := list.New(slogdiscard.NewDiscardLogger(), urlListerMock)
+	// what auth.New does in the router
+	get := func(target string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		return req.WithContext(auth.WithPrincipal(req.Context(), user))
+	}
+
+	req := get("/url?limit=1&sort=alias&order=asc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var first list.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &first))
+	require.NotEmpty(t, first.NextCursor)
+
+	// same cursor with a different order is rejected
+	req = get("/url?limit=1&sort=alias&cursor=" + first.NextCursor)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req = get("/url?limit=1&sort=alias&order=asc&cursor=" + first.NextCursor)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var second list.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &second))
+	require.Len(t, second.URLs, 1)
+	require.Equal(t, "bbb", second.URLs[0].Alias)
+	require.Empty(t, second.NextCursor)
+}
@@ -0,0 +1,118 @@
+package redirect
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"url-shortener/internal/storage"
+)
+
+// errDotSegment - browsers resolve "..", a visitor could walk out of the path the link points to
+var errDotSegment = errors.New("dot segment in path")
+
+// rest is what comes after /{alias} in the request, still escaped as the client sent it.
+// empty for a plain /{alias}, and for /{alias}.json - middleware.URLFormat routes that as /{alias}.
+// the raw path and not chi's wildcard, URLFormat would cut the .pdf off /{alias}/report.pdf too
+func rest(r *http.Request, alias string) string {
+	extra := strings.TrimPrefix(r.URL.EscapedPath(), "/"+alias)
+	if !strings.HasPrefix(extra, "/") {
+		return ""
+	}
+	return extra
+}
+
+// destination is where the visitor goes - the link itself, its utm params for links tagged on redirect,
+// plus the rest of the request path and the request query for links that forward them.
+//
+// the path is appended as is, %2F stays %2F. query keys the destination already has win,
+// then the utm params of the link, so a visitor can't override utm or affiliate params.
+// the other keys are added in the order the request has them, repeated ones included
+func destination(link storage.URL, r *http.Request, alias string) (string, error) {
+	extra := rest(r, alias)
+	forwardPath := link.ForwardPath && extra != ""
+	forwardQuery := link.ForwardQuery && r.URL.RawQuery != ""
+	if !forwardPath && !forwardQuery && link.UTM == "" {
+		return link.URL, nil
+	}
+
+	dst, err := url.Parse(link.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if forwardPath {
+		if err := joinPath(dst, extra); err != nil {
+			return "", err
+		}
+	}
+	if link.UTM != "" {
+		mergeQuery(dst, link.UTM)
+	}
+	if forwardQuery {
+		mergeQuery(dst, r.URL.RawQuery)
+	}
+
+	return dst.String(), nil
+}
+
+// joinPath appends extra, which starts with a slash, to the path of dst without doubling the slash
+func joinPath(dst *url.URL, extra string) error {
+	for _, segment := range strings.Split(extra, "/") {
+		// %2e%2e is just as much a way up
+		if s, err := url.PathUnescape(segment); err != nil || s == "." || s == ".." {
+			return errDotSegment
+		}
+	}
+
+	escaped := strings.TrimSuffix(dst.EscapedPath(), "/") + extra
+
+	p, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	// RawPath keeps the escaping of the client, String falls back to Path when it isn't valid
+	dst.Path, dst.RawPath = p, escaped
+	return nil
+}
+
+// mergeQuery adds the pairs of raw whose keys dst doesn't have, pairs that don't decode are dropped
+func mergeQuery(dst *url.URL, raw string) {
+	// a destination with a broken query still has keys, ParseQuery returns what it could read
+	own, _ := url.ParseQuery(dst.RawQuery)
+
+	var added []string
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+
+		k, v, hasValue := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			continue
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+		if _, ok := own[key]; ok {
+			continue
+		}
+
+		// encoded again, so nothing the client sent can end the query early
+		if hasValue {
+			added = append(added, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		} else {
+			added = append(added, url.QueryEscape(key))
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+	if dst.RawQuery != "" {
+		added = append([]string{dst.RawQuery}, added...)
+	}
+	dst.RawQuery = strings.Join(added, "&")
+}
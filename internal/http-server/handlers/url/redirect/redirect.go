@@ -1,32 +1,85 @@
 package redirect
 
 import (
+	"context"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"time"
 	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/lib/password"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// URLGetter is an interface to get real url by alias
+// URLGetter is an interface to get real url by alias,
+// TakeClick is only asked for links with a click limit, right before they redirect
 type URLGetter interface {
-	GetURL(alias string) (string, error)
+	GetURL(ctx context.Context, alias string) (storage.URL, error)
+	TakeClick(ctx context.Context, alias string) (storage.URL, error)
 }
 
-func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
+// ClickRecorder collects clicks for the stats, Record must not block the redirect
+type ClickRecorder interface {
+	Record(click storage.Click)
+}
+
+// Codes are the statuses a link can redirect with - 301 and 308 are cached by browsers and search engines,
+// 302 and 307 are asked again every time. 307 and 308 keep the method and body of the request
+var Codes = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// ValidCode reports whether code is one of Codes
+func ValidCode(code int) bool {
+	return slices.Contains(Codes, code)
+}
+
+// Options - DefaultCode is used for links created without a redirect code,
+// Unlocker remembers visitors who typed in the password of a protected link, nil gets one with a random secret.
+// InactiveURL is where links that aren't live yet send visitors, empty answers them with a 404 as if there was no link
+type Options struct {
+	DefaultCode int
+	Unlocker    *Unlocker
+	InactiveURL string
+}
+
+// a password and nothing else
+const maxFormBytes = 4 << 10
+
+// New - GET /{alias}, and POST /{alias} with the password for protected links.
+// mounted on /{alias}/* too, for links that forward the rest of the path
+func New(log *slog.Logger, urlGetter URLGetter, clickRecorder ClickRecorder, opts Options) http.HandlerFunc {
+	if opts.Unlocker == nil {
+		opts.Unlocker = NewUnlocker("", defaultUnlockTTL)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.redirect.New"
 
-		log = log.With(
+		ctx, span := tracing.Start(r.Context(), op)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
 		)
 		// get it from GET /{alias}
 		alias := chi.URLParam(r, "alias")
+		span.SetAttributes(attribute.String("alias", alias))
 		if alias == "" {
 			log.Info("alias is empty")
 
@@ -36,7 +89,7 @@ func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
 			return
 		}
 
-		resURL, err := urlGetter.GetURL(alias)
+		resURL, err := urlGetter.GetURL(r.Context(), alias)
 		if errors.Is(err, storage.ErrURLNotFound) {
 			log.Info("url not found", "alias", alias)
 
@@ -46,9 +99,154 @@ func New(log *slog.Logger, urlGetter URLGetter) http.HandlerFunc {
 			return
 		}
 
-		log.Info("got url", slog.String("url", resURL))
+		if err != nil {
+			log.Error("failed to get url", sl.Err(err))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("internal error"))
+
+			return
+		}
+
+		// the reaper deletes expired links only every now and then, so check here too
+		if resURL.Expired(time.Now()) {
+			log.Info("url expired", slog.String("alias", alias))
+
+			w.WriteHeader(http.StatusGone)
+			render.JSON(w, r, resp.Error("url expired"))
+
+			return
+		}
+
+		if resURL.Scheduled(time.Now()) {
+			log.Info("url not active yet", slog.String("alias", alias))
+
+			if opts.InactiveURL == "" {
+				w.WriteHeader(http.StatusNotFound)
+				render.JSON(w, r, resp.Error("not found"))
+
+				return
+			}
+
+			// always temporary, the link itself goes live later
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, opts.InactiveURL, http.StatusFound)
+
+			return
+		}
+
+		// only links that forward the path have anything below /{alias}
+		if rest(r, alias) != "" && !resURL.ForwardPath {
+			log.Info("url doesn't forward the path", slog.String("alias", alias))
+
+			w.WriteHeader(http.StatusNotFound)
+			render.JSON(w, r, resp.Error("not found"))
+
+			return
+		}
+
+		// worked out before anything is spent on the visit, a click or a password attempt
+		target, err := destination(resURL, r, alias)
+		if err != nil {
+			log.Info("invalid path", slog.String("alias", alias), sl.Err(err))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid path"))
+
+			return
+		}
+
+		code := resURL.RedirectCode
+		if code == 0 {
+			code = opts.DefaultCode
+		}
+
+		if r.Method == http.MethodPost && !resURL.Protected() {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			render.JSON(w, r, resp.Error("method not allowed"))
+
+			return
+		}
+
+		if resURL.Protected() {
+			// the page, and the redirect behind it, must not be served from a cache to the next visitor
+			w.Header().Set("Cache-Control", "no-store")
+
+			if !opts.Unlocker.Unlocked(r, resURL, time.Now()) {
+				if r.Method != http.MethodPost {
+					prompt(w, r, http.StatusOK, "")
+					return
+				}
+
+				r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+				if err := r.ParseForm(); err != nil || !password.Check(resURL.PasswordHash, r.PostForm.Get("password")) {
+					log.Info("wrong password", slog.String("alias", alias))
+
+					prompt(w, r, http.StatusForbidden, "Wrong password, try again.")
+					return
+				}
+
+				http.SetCookie(w, opts.Unlocker.Cookie(r, resURL, time.Now()))
+			}
+
+			// the browser has to follow with a GET, not post the password on to the destination
+			if r.Method == http.MethodPost {
+				code = http.StatusSeeOther
+			}
+		}
+
+		if resURL.MaxClicks > 0 {
+			// a browser that cached the redirect would never count against the limit
+			w.Header().Set("Cache-Control", "no-store")
+
+			// the link might have come from the cache, the storage has the real count
+			resURL, err = urlGetter.TakeClick(r.Context(), alias)
+			if errors.Is(err, storage.ErrURLExhausted) {
+				log.Info("url has no clicks left", slog.String("alias", alias))
+
+				w.WriteHeader(http.StatusGone)
+				render.JSON(w, r, resp.Error("url has no clicks left"))
+
+				return
+			}
+			if errors.Is(err, storage.ErrURLNotFound) {
+				// deleted since the lookup
+				w.WriteHeader(http.StatusNotFound)
+				render.JSON(w, r, resp.Error("not found"))
+
+				return
+			}
+			if err != nil {
+				log.Error("failed to take a click", sl.Err(err))
+
+				w.WriteHeader(http.StatusInternalServerError)
+				render.JSON(w, r, resp.Error("internal error"))
+
+				return
+			}
+		}
+
+		log.Info("got url", slog.String("url", target))
+
+		clickRecorder.Record(storage.Click{
+			URLID:     resURL.ID,
+			At:        time.Now(),
+			Referrer:  r.Referer(),
+			UserAgent: r.UserAgent(),
+			IP:        clientIP(r),
+			RequestID: middleware.GetReqID(r.Context()),
+		})
 
 		// redirect to the url
-		http.Redirect(w, r, resURL, http.StatusFound)
+		http.Redirect(w, r, target, code)
+	}
+}
+
+// clientIP - RealIP middleware already put the client address into RemoteAddr,
+// it only still has a port when there was no proxy header
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
 	}
+	return r.RemoteAddr
 }
@@ -2,24 +2,35 @@ package redirect_test
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"url-shortener/internal/http-server/handlers/url/redirect"
 	"url-shortener/internal/http-server/handlers/url/redirect/mocks"
 	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/lib/password"
 	"url-shortener/internal/storage"
 )
 
 func TestRedirectHandler(t *testing.T) { // Fixed name!
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
 	cases := []struct {
 		name           string
 		alias          string
 		url            string
+		expiresAt      *time.Time
+		redirectCode   int // what the link was saved with
 		mockError      error
 		expectedStatus int
 		expectedURL    string // For checking Location header
@@ -32,6 +43,30 @@ func TestRedirectHandler(t *testing.T) { // Fixed name!
 			expectedStatus: http.StatusFound, // 302
 			expectedURL:    "https://google.com",
 		},
+		{
+			name:           "Permanent",
+			alias:          "seo",
+			url:            "https://google.com",
+			redirectCode:   http.StatusMovedPermanently,
+			expectedStatus: http.StatusMovedPermanently,
+			expectedURL:    "https://google.com",
+		},
+		{
+			name:           "Permanent keeping the method",
+			alias:          "api",
+			url:            "https://google.com",
+			redirectCode:   http.StatusPermanentRedirect,
+			expectedStatus: http.StatusPermanentRedirect,
+			expectedURL:    "https://google.com",
+		},
+		{
+			name:           "Temporary keeping the method",
+			alias:          "campaign",
+			url:            "https://google.com",
+			redirectCode:   http.StatusTemporaryRedirect,
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedURL:    "https://google.com",
+		},
 		{
 			name:           "Empty alias",
 			alias:          "",
@@ -44,6 +79,27 @@ func TestRedirectHandler(t *testing.T) { // Fixed name!
 			mockError:      storage.ErrURLNotFound,
 			expectedStatus: http.StatusNotFound, // 404
 		},
+		{
+			name:           "Storage error",
+			alias:          "broken",
+			mockError:      errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError, // 500
+		},
+		{
+			name:           "Expired",
+			alias:          "expired",
+			url:            "https://google.com",
+			expiresAt:      &past,
+			expectedStatus: http.StatusGone, // 410
+		},
+		{
+			name:           "Not expired yet",
+			alias:          "notexpired",
+			url:            "https://google.com",
+			expiresAt:      &future,
+			expectedStatus: http.StatusFound, // 302
+			expectedURL:    "https://google.com",
+		},
 	}
 
 	for _, tc := range cases {
@@ -54,13 +110,26 @@ func TestRedirectHandler(t *testing.T) { // Fixed name!
 
 			urlGetterMock := mocks.NewURLGetter(t)
 
+			clickRecorderMock := mocks.NewClickRecorder(t)
+
 			// Only set up mock if alias is not empty
 			if tc.alias != "" {
-				urlGetterMock.On("GetURL", tc.alias).
-					Return(tc.url, tc.mockError).
+				urlGetterMock.On("GetURL", mock.Anything, tc.alias).
+					Return(storage.URL{ID: 42, Alias: tc.alias, URL: tc.url, ExpiresAt: tc.expiresAt, RedirectCode: tc.redirectCode}, tc.mockError).
 					Once()
 			}
 
+			// only real redirects are counted as clicks
+			if redirect.ValidCode(tc.expectedStatus) {
+				clickRecorderMock.On("Record", mock.MatchedBy(func(c storage.Click) bool {
+					return c.URLID == 42 &&
+						c.Referrer == "https://referrer.com" &&
+						c.UserAgent == "test-agent" &&
+						c.IP == "10.0.0.1" &&
+						!c.At.IsZero()
+				})).Once()
+			}
+
 			// Create chi context with alias
 			rctx := chi.NewRouteContext()
 			rctx.URLParams.Add("alias", tc.alias)
@@ -69,9 +138,12 @@ func TestRedirectHandler(t *testing.T) { // Fixed name!
 			req, err := http.NewRequest(http.MethodGet, "/"+tc.alias, nil)
 			require.NoError(t, err)
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			req.Header.Set("Referer", "https://referrer.com")
+			req.Header.Set("User-Agent", "test-agent")
+			req.RemoteAddr = "10.0.0.1:12345"
 
 			// Create handler and recorder
-			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock)
+			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, clickRecorderMock, redirect.Options{DefaultCode: http.StatusFound})
 			rr := httptest.NewRecorder()
 
 			// Execute
@@ -81,10 +153,510 @@ func TestRedirectHandler(t *testing.T) { // Fixed name!
 			require.Equal(t, tc.expectedStatus, rr.Code)
 
 			// For successful redirect, check Location header
-			if tc.expectedStatus == http.StatusFound {
+			if redirect.ValidCode(tc.expectedStatus) {
 				location := rr.Header().Get("Location")
 				require.Equal(t, tc.expectedURL, location)
 			}
 		})
 	}
 }
+
+// the storage gets the request context, so a client that went away cancels the query
+func TestRedirectHandler_Cancelled(t *testing.T) {
+	urlGetterMock := mocks.NewURLGetter(t)
+	urlGetterMock.On("GetURL", mock.MatchedBy(func(ctx context.Context) bool {
+		return errors.Is(ctx.Err(), context.Canceled)
+	}), "test_alias").
+		Return(storage.URL{}, context.Canceled).
+		Once()
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("alias", "test_alias")
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), chi.RouteCtxKey, rctx))
+	cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/test_alias", nil)
+	require.NoError(t, err)
+
+	handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, mocks.NewClickRecorder(t), redirect.Options{DefaultCode: http.StatusFound})
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	// nobody reads it, but it must not be a redirect
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestRedirectHandler_Password(t *testing.T) {
+	hash, err := password.Hash("secret")
+	require.NoError(t, err)
+	link := storage.URL{ID: 42, Alias: "private", URL: "https://docs.example.com", PasswordHash: hash}
+
+	unlocker := redirect.NewUnlocker("test-secret", time.Hour)
+	validCookie := unlocker.Cookie(httptest.NewRequest(http.MethodGet, "/private", nil), link, time.Now())
+
+	cases := []struct {
+		name string
+		// form is posted when set, otherwise it's a GET
+		form           url.Values
+		cookie         *http.Cookie
+		link           storage.URL
+		expectedStatus int
+		expectCookie   bool
+	}{
+		{
+			name:           "Prompt",
+			link:           link,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Right password",
+			form:           url.Values{"password": {"secret"}},
+			link:           link,
+			expectedStatus: http.StatusSeeOther,
+			expectCookie:   true,
+		},
+		{
+			name:           "Wrong password",
+			form:           url.Values{"password": {"Secret"}},
+			link:           link,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Cookie",
+			cookie:         validCookie,
+			link:           link,
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "Forged cookie",
+			cookie:         &http.Cookie{Name: validCookie.Name, Value: "9999999999.forged"},
+			link:           link,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Cookie after the password changed",
+			cookie:         validCookie,
+			link:           storage.URL{ID: 42, Alias: "private", URL: link.URL, PasswordHash: "$2a$10$another"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Cookie of a recreated link",
+			cookie:         validCookie,
+			link:           storage.URL{ID: 43, Alias: "private", URL: link.URL, PasswordHash: hash},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Post to an open link",
+			form:           url.Values{"password": {"secret"}},
+			link:           storage.URL{ID: 42, Alias: "private", URL: link.URL},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			urlGetterMock := mocks.NewURLGetter(t)
+			urlGetterMock.On("GetURL", mock.Anything, "private").Return(tc.link, nil).Once()
+
+			clickRecorderMock := mocks.NewClickRecorder(t)
+			redirected := tc.expectedStatus == http.StatusFound || tc.expectedStatus == http.StatusSeeOther
+			// the prompt isn't a click, only getting through is
+			if redirected {
+				clickRecorderMock.On("Record", mock.Anything).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", "private")
+
+			req := httptest.NewRequest(http.MethodGet, "/private", nil)
+			if tc.form != nil {
+				req = httptest.NewRequest(http.MethodPost, "/private", strings.NewReader(tc.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, clickRecorderMock, redirect.Options{
+				DefaultCode: http.StatusFound,
+				Unlocker:    unlocker,
+			})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			if redirected {
+				require.Equal(t, link.URL, rr.Header().Get("Location"))
+			}
+			if tc.expectedStatus == http.StatusOK || tc.expectedStatus == http.StatusForbidden {
+				require.Contains(t, rr.Body.String(), `<form method="post" action="/private">`)
+				require.NotContains(t, rr.Body.String(), link.URL)
+			}
+
+			cookies := rr.Result().Cookies()
+			require.Equal(t, tc.expectCookie, len(cookies) == 1)
+			if tc.expectCookie {
+				require.Equal(t, "/private", cookies[0].Path)
+				require.True(t, cookies[0].HttpOnly)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_MaxClicks(t *testing.T) {
+	cases := []struct {
+		name           string
+		maxClicks      int64
+		takeErr        error
+		expectTake     bool
+		expectedStatus int
+	}{
+		{
+			name:           "No limit",
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "Clicks left",
+			maxClicks:      1,
+			expectTake:     true,
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "Exhausted",
+			maxClicks:      1,
+			expectTake:     true,
+			takeErr:        storage.ErrURLExhausted,
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "Deleted in between",
+			maxClicks:      1,
+			expectTake:     true,
+			takeErr:        storage.ErrURLNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Storage error",
+			maxClicks:      1,
+			expectTake:     true,
+			takeErr:        errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			link := storage.URL{ID: 42, Alias: "once", URL: "https://google.com", MaxClicks: tc.maxClicks, ClicksLeft: tc.maxClicks}
+
+			urlGetterMock := mocks.NewURLGetter(t)
+			urlGetterMock.On("GetURL", mock.Anything, "once").Return(link, nil).Once()
+			if tc.expectTake {
+				taken := link
+				taken.ClicksLeft--
+				urlGetterMock.On("TakeClick", mock.Anything, "once").Return(taken, tc.takeErr).Once()
+			}
+
+			clickRecorderMock := mocks.NewClickRecorder(t)
+			if tc.expectedStatus == http.StatusFound {
+				clickRecorderMock.On("Record", mock.Anything).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", "once")
+			req := httptest.NewRequest(http.MethodGet, "/once", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, clickRecorderMock, redirect.Options{DefaultCode: http.StatusFound})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			// limited links must not be cached by the browser, or clicks go uncounted
+			require.Equal(t, tc.maxClicks > 0, rr.Header().Get("Cache-Control") == "no-store")
+		})
+	}
+}
+
+func TestRedirectHandler_NotBefore(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	cases := []struct {
+		name             string
+		notBefore        *time.Time
+		inactiveURL      string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "Live",
+			notBefore:        &past,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://google.com",
+		},
+		{
+			name:           "Not yet, no fallback",
+			notBefore:      &future,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:             "Not yet, fallback",
+			notBefore:        &future,
+			inactiveURL:      "https://example.com/coming-soon",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/coming-soon",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// a permanent code must not leak into the fallback, browsers would remember it
+			link := storage.URL{ID: 42, Alias: "launch", URL: "https://google.com", NotBefore: tc.notBefore, RedirectCode: http.StatusMovedPermanently}
+
+			urlGetterMock := mocks.NewURLGetter(t)
+			urlGetterMock.On("GetURL", mock.Anything, "launch").Return(link, nil).Once()
+
+			clickRecorderMock := mocks.NewClickRecorder(t)
+			if tc.expectedLocation == link.URL {
+				clickRecorderMock.On("Record", mock.Anything).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", "launch")
+			req := httptest.NewRequest(http.MethodGet, "/launch", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, clickRecorderMock, redirect.Options{
+				DefaultCode: http.StatusFound,
+				InactiveURL: tc.inactiveURL,
+			})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Equal(t, tc.expectedLocation, rr.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRedirectHandler_Forward(t *testing.T) {
+	cases := []struct {
+		name             string
+		url              string
+		forwardPath      bool
+		forwardQuery     bool
+		utm              string // tagged on redirect
+		target           string // request, escaped as a client would send it
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "Plain",
+			url:              "https://example.com/base?ref=link",
+			target:           "/docs",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base?ref=link",
+		},
+		{
+			name:             "Format suffix on a plain link",
+			url:              "https://example.com/base",
+			target:           "/docs.json",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base",
+		},
+		{
+			name:             "Format suffix isn't forwarded",
+			url:              "https://example.com/base",
+			forwardPath:      true,
+			target:           "/docs.json",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base",
+		},
+		{
+			name:             "Extensions below the alias are kept",
+			url:              "https://example.com/files",
+			forwardPath:      true,
+			target:           "/docs/report.pdf",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/files/report.pdf",
+		},
+		{
+			name:           "Path without opt-in",
+			url:            "https://example.com/base",
+			target:         "/docs/guide",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:             "Query without opt-in is dropped",
+			url:              "https://example.com/base",
+			target:           "/docs?utm_source=news",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base",
+		},
+		{
+			name:             "Path",
+			url:              "https://example.com/base?ref=link",
+			forwardPath:      true,
+			target:           "/docs/guide/intro",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base/guide/intro?ref=link",
+		},
+		{
+			name:             "Path no double slash",
+			url:              "https://example.com/base/",
+			forwardPath:      true,
+			target:           "/docs/guide",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base/guide",
+		},
+		{
+			name:             "Path onto bare host",
+			url:              "https://example.com",
+			forwardPath:      true,
+			target:           "/docs/guide",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/guide",
+		},
+		{
+			name:             "Trailing slash only",
+			url:              "https://example.com/base",
+			forwardPath:      true,
+			target:           "/docs/",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base/",
+		},
+		{
+			name:             "Path opt-in without extra path",
+			url:              "https://example.com/base",
+			forwardPath:      true,
+			target:           "/docs",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base",
+		},
+		{
+			name:             "Encoded slash stays encoded",
+			url:              "https://example.com/files",
+			forwardPath:      true,
+			target:           "/docs/a%2Fb",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/files/a%2Fb",
+		},
+		{
+			name:             "Spaces and unicode",
+			url:              "https://example.com/wiki",
+			forwardPath:      true,
+			target:           "/docs/hello%20world/%D0%BF%D1%80%D0%B8",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/wiki/hello%20world/%D0%BF%D1%80%D0%B8",
+		},
+		{
+			name:           "Dot segments",
+			url:            "https://example.com/base",
+			forwardPath:    true,
+			target:         "/docs/../admin",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Encoded dot segments",
+			url:            "https://example.com/base",
+			forwardPath:    true,
+			target:         "/docs/%2e%2E/admin",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:             "Query merged, the link wins",
+			url:              "https://example.com/base?ref=link",
+			forwardQuery:     true,
+			target:           "/docs?ref=evil&utm_source=news&tag=a&tag=b",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/base?ref=link&utm_source=news&tag=a&tag=b",
+		},
+		{
+			name:             "Query encoding",
+			url:              "https://example.com/search",
+			forwardQuery:     true,
+			target:           "/docs?q=a%26b%3Dc&empty=&flag&sp=a+b&pct=100%25",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/search?q=a%26b%3Dc&empty=&flag&sp=a+b&pct=100%25",
+		},
+		{
+			name:             "Broken pairs are dropped",
+			url:              "https://example.com/search",
+			forwardQuery:     true,
+			target:           "/docs?a=%zz&b=1",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/search?b=1",
+		},
+		{
+			name:             "Fragment stays last",
+			url:              "https://example.com/page#top",
+			forwardPath:      true,
+			forwardQuery:     true,
+			target:           "/docs/sub?a=1",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/page/sub?a=1#top",
+		},
+		{
+			name:             "UTM on redirect keeps params of the link",
+			url:              "https://example.com/sale?utm_source=partner",
+			utm:              "utm_source=news&utm_campaign=spring+sale",
+			target:           "/docs",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/sale?utm_source=partner&utm_campaign=spring+sale",
+		},
+		{
+			name:             "Visitor can't override UTM",
+			url:              "https://example.com/sale",
+			forwardQuery:     true,
+			utm:              "utm_campaign=spring",
+			target:           "/docs?utm_campaign=hijack&x=1",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://example.com/sale?utm_campaign=spring&x=1",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			link := storage.URL{ID: 42, Alias: "docs", URL: tc.url, ForwardPath: tc.forwardPath, ForwardQuery: tc.forwardQuery, UTM: tc.utm}
+
+			urlGetterMock := mocks.NewURLGetter(t)
+			urlGetterMock.On("GetURL", mock.Anything, "docs").Return(link, nil).Once()
+
+			clickRecorderMock := mocks.NewClickRecorder(t)
+			if tc.expectedStatus == http.StatusFound {
+				clickRecorderMock.On("Record", mock.Anything).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", "docs")
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			handler := redirect.New(slogdiscard.NewDiscardLogger(), urlGetterMock, clickRecorderMock, redirect.Options{DefaultCode: http.StatusFound})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Equal(t, tc.expectedLocation, rr.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package redirect
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// unlockCookie is scoped to the path of the link, every protected link has its own
+const unlockCookie = "us_unlock"
+
+// Unlocker remembers visitors who typed in the password of a link, so they aren't asked on every click.
+// the cookie is signed, it's bound to the link and its password - a new password locks everyone out again
+type Unlocker struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// defaultUnlockTTL is for a zero ttl
+const defaultUnlockTTL = time.Hour
+
+// NewUnlocker - an empty secret gets a random one, then cookies stop working after a restart
+// and on other replicas, visitors are just asked again
+func NewUnlocker(secret string, ttl time.Duration) *Unlocker {
+	if ttl <= 0 {
+		ttl = defaultUnlockTTL
+	}
+
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &Unlocker{secret: key, ttl: ttl}
+}
+
+// Cookie is set once the visitor typed in the right password
+func (u *Unlocker) Cookie(r *http.Request, link storage.URL, now time.Time) *http.Cookie {
+	expires := now.Add(u.ttl)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	return &http.Cookie{
+		Name:     unlockCookie,
+		Value:    exp + "." + u.sign(link, exp),
+		Path:     "/" + link.Alias,
+		Expires:  expires,
+		MaxAge:   int(u.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Unlocked reports whether the request carries a cookie for link that hasn't run out yet
+func (u *Unlocker) Unlocked(r *http.Request, link storage.URL, now time.Time) bool {
+	for _, c := range r.Cookies() {
+		if c.Name != unlockCookie {
+			continue
+		}
+
+		exp, mac, ok := strings.Cut(c.Value, ".")
+		if !ok {
+			continue
+		}
+		expires, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil || now.Unix() >= expires {
+			continue
+		}
+		if hmac.Equal([]byte(mac), []byte(u.sign(link, exp))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sign covers the id too, so a deleted link whose alias is taken again doesn't inherit the cookies
+func (u *Unlocker) sign(link storage.URL, exp string) string {
+	h := hmac.New(sha256.New, u.secret)
+	h.Write([]byte(strconv.FormatInt(link.ID, 10) + "|" + link.Alias + "|" + exp + "|" + link.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// the form posts back to the url it was shown on, with the path and query a forwarding link passes on
+var promptPage = template.Must(template.New("prompt").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+form { display: flex; flex-direction: column; gap: .75rem; width: 18rem; }
+input, button { font: inherit; padding: .5rem; }
+.error { color: #b00020; margin: 0; }
+</style>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<h1>Password required</h1>
+<p>This link is protected, enter its password to continue.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+func prompt(w http.ResponseWriter, r *http.Request, status int, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = promptPage.Execute(w, struct{ Action, Error string }{r.URL.RequestURI(), errMsg})
+}
@@ -1,18 +1,26 @@
 package save
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/alias"
 	"url-shortener/internal/lib/logger/sl"
-	"url-shortener/internal/lib/random"
+	"url-shortener/internal/lib/password"
+	"url-shortener/internal/lib/utm"
 	"url-shortener/internal/storage"
+	"url-shortener/internal/tracing"
 
 	resp "url-shortener/internal/lib/api/response"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Request struct {
@@ -20,31 +28,96 @@ type Request struct {
 	URL string `json:"url" validate:"required,url"`
 	// omitempty - if it's empty then it doesn't appear in json
 	Alias string `json:"alias,omitempty" validate:"omitempty,min=3,max=15,alphanum"`
+	// when the link stops working - either an absolute time or ttl in seconds from now, not both.
+	// ttl is at most 100 years, way before it would overflow time.Duration
+	ExpiresAt *time.Time `json:"expires_at,omitempty" validate:"omitempty,excluded_with=TTL"`
+	TTL       int64      `json:"ttl,omitempty" validate:"omitempty,gt=0,max=3155760000"`
+	// not_before and not_after are the activation window of campaign links,
+	// not_after is expires_at under the name campaigns use, so only one of the three
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty" validate:"omitempty,excluded_with=ExpiresAt TTL"`
+	// 301 or 308 for links that never change (seo), 302 or 307 for ones that might (campaigns).
+	// the server default when empty
+	RedirectCode int `json:"redirect_code,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	// visitors have to type it in before they are redirected, only its hash is stored
+	Password string `json:"password,omitempty" validate:"omitempty,min=4"`
+	// the link is gone after this many redirects, 1 for a burn-after-reading link
+	MaxClicks int64 `json:"max_clicks,omitempty" validate:"omitempty,gt=0"`
+	// /{alias}/docs/intro goes to the destination plus /docs/intro
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// the query of the visit is added to the destination, its own params win over the visitor's
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// only links with it are tagged, its empty fields come from the templates of the server
+	UTM *utm.Params `json:"utm,omitempty"`
+	// "save" (the default) writes the utm params into url, "redirect" adds them on every redirect
+	// and keeps url as it was sent
+	UTMAt string `json:"utm_at,omitempty" validate:"omitempty,oneof=save redirect,excluded_without=UTM"`
+}
+
+// LogValue keeps the password out of the logs
+func (r Request) LogValue() slog.Value {
+	if r.Password != "" {
+		r.Password = "***"
+	}
+	type plain Request
+	return slog.AnyValue(plain(r))
 }
 
 type Response struct {
 	resp.Response
 	Alias string `json:"alias,omitempty"`
+	// the destination as stored, with utm params tagged on save
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Protected bool       `json:"protected,omitempty"`
 }
 
-// TODO: move to config
-const aliasLength = 6
-
 //go:generate go run github.com/vektra/mockery/v2@latest --name=URLSaver
 type URLSaver interface {
-	SaveURL(urlToSave string, alias string) (int64, error)
+	SaveURL(ctx context.Context, u storage.URL) (int64, error)
 }
 
-// New - constructor for handler
-func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
+// URLChecker is the safety policy for destinations, see urlpolicy.
+// requestHost is where the request came in, links back to it would loop
+type URLChecker interface {
+	Check(rawURL string, requestHost string) error
+}
+
+// AliasChecker refuses aliases clients aren't allowed to pick, see alias.Reserved.
+// generated ones are filtered by the generator itself
+type AliasChecker interface {
+	Check(alias string) error
+}
+
+// New - constructor for handler, aliasGen makes aliases for links saved without one
+// and gets attempts tries to find a free one. checker decides which destinations are allowed,
+// reserved which aliases. templates fill in the utm params requests leave out
+func New(log *slog.Logger, urlSaver URLSaver, aliasGen alias.Generator, attempts int, checker URLChecker, reserved AliasChecker, templates utm.Templates) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.url.save.New"
 
-		log = log.With(
+		ctx, span := tracing.Start(r.Context(), op)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
 		)
 
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
 		var req Request
 
 		err := render.DecodeJSON(r.Body, &req)
@@ -58,24 +131,27 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 		}
 
 		log.Info("request body decoded", slog.Any("request", req))
-		// validating the response struct, in case of an error:
-		if err := validator.New().Struct(req); err != nil {
-			var validateErr validator.ValidationErrors
-			errors.As(err, &validateErr)
-			// we log the error as it is
-			log.Error("invalid request", sl.Err(err))
+
+		u, errResp, err := Prepare(req, time.Now(), checker, reserved, templates.For(principal.OwnerID), r.Host)
+		if err != nil {
+			log.Error("failed to prepare url", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to hash password"))
+
+			return
+		}
+		if errResp != nil {
+			log.Info("invalid request", slog.String("error", errResp.Error))
 			// then we return a proper readable error
 			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, resp.ValidationError(validateErr))
+			render.JSON(w, r, errResp)
 			return
 		}
 
-		alias := req.Alias
-		if alias == "" {
-			alias = random.NewRandomString(aliasLength)
-		}
+		u.OwnerID = principal.OwnerID
 
-		id, err := urlSaver.SaveURL(req.URL, alias)
+		id, u, err := Save(r.Context(), urlSaver, aliasGen, attempts, u)
 		if errors.Is(err, storage.ErrUrlExists) {
 			log.Info("url already exists", slog.String("url", req.URL))
 
@@ -86,6 +162,16 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 			return
 		}
 
+		if errors.Is(err, alias.ErrNoFreeAlias) {
+			// not the client's fault, the alias space is getting crowded
+			log.Error("no free alias", slog.Int("attempts", attempts))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to generate alias"))
+
+			return
+		}
+
 		if err != nil {
 			log.Error("failed to add url", sl.Err(err))
 
@@ -96,11 +182,143 @@ func New(log *slog.Logger, urlSaver URLSaver) http.HandlerFunc {
 		}
 
 		log.Info("url added", slog.Int64("id", id))
+		span.SetAttributes(attribute.String("alias", u.Alias))
 
 		render.Status(r, http.StatusCreated)
 		render.JSON(w, r, Response{
-			Response: resp.Created(),
-			Alias:    alias,
+			Response:  resp.Created(),
+			Alias:     u.Alias,
+			URL:       u.URL,
+			ExpiresAt: u.ExpiresAt,
+			NotBefore: u.NotBefore,
+			Protected: u.Protected(),
 		})
 	}
 }
+
+// Prepare validates the request and turns it into a link ready to be stored,
+// the alias stays empty if the client didn't ask for one - Save picks it.
+// template is what the utm params of the request go on top of.
+// when the request is invalid, or its url or alias is refused, it returns the error response to send back as is.
+// the error is for what isn't the client's fault, like bcrypt failing
+func Prepare(req Request, now time.Time, checker URLChecker, reserved AliasChecker, template utm.Params, requestHost string) (storage.URL, *resp.Response, error) {
+	const op = "handlers.url.save.Prepare"
+
+	// validating the request struct, in case of an error:
+	if err := validator.New().Struct(req); err != nil {
+		var validateErr validator.ValidationErrors
+		errors.As(err, &validateErr)
+
+		errResp := resp.ValidationError(validateErr)
+		return storage.URL{}, &errResp, nil
+	}
+
+	if err := checker.Check(req.URL, requestHost); err != nil {
+		errResp := resp.NotAllowed("URL", err)
+		return storage.URL{}, &errResp, nil
+	}
+
+	if req.Alias != "" {
+		if err := reserved.Check(req.Alias); err != nil {
+			errResp := resp.NotAllowed("Alias", err)
+			return storage.URL{}, &errResp, nil
+		}
+	}
+
+	expiryField := "ExpiresAt"
+	if req.NotAfter != nil {
+		req.ExpiresAt, expiryField = req.NotAfter, "NotAfter"
+	}
+
+	var expiresAt *time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		// a link that is dead on arrival is most likely a client bug
+		if !req.ExpiresAt.After(now) {
+			errResp := resp.Error(fmt.Sprintf("field %s must be in the future", expiryField))
+			return storage.URL{}, &errResp, nil
+		}
+		expiresAt = req.ExpiresAt
+	case req.TTL > 0:
+		t := now.Add(time.Duration(req.TTL) * time.Second)
+		expiresAt = &t
+	}
+
+	// a not_before in the past is fine, the link is simply live right away
+	if req.NotBefore != nil && expiresAt != nil && !req.NotBefore.Before(*expiresAt) {
+		errResp := resp.Error("field NotBefore must be before the expiry")
+		return storage.URL{}, &errResp, nil
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		// the validator counts runes, bcrypt counts bytes
+		if len(req.Password) > password.MaxBytes {
+			errResp := resp.Error(fmt.Sprintf("field Password must be at most %d bytes", password.MaxBytes))
+			return storage.URL{}, &errResp, nil
+		}
+
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return storage.URL{}, nil, fmt.Errorf("%s: %w", op, err)
+		}
+		passwordHash = hash
+	}
+
+	// the policy has seen the url already, utm params don't change where it goes
+	destination, utmQuery := req.URL, ""
+	if req.UTM != nil {
+		params := req.UTM.Or(template)
+		if req.UTMAt == "redirect" {
+			utmQuery = params.Encode()
+		} else {
+			tagged, err := utm.Apply(req.URL, params)
+			if err != nil {
+				errResp := resp.Error("field URL is not a valid URL")
+				return storage.URL{}, &errResp, nil
+			}
+			destination = tagged
+		}
+	}
+
+	return storage.URL{
+		URL:          destination,
+		Alias:        req.Alias,
+		ExpiresAt:    expiresAt,
+		NotBefore:    req.NotBefore,
+		RedirectCode: req.RedirectCode,
+		PasswordHash: passwordHash,
+		MaxClicks:    req.MaxClicks,
+		ForwardPath:  req.ForwardPath,
+		ForwardQuery: req.ForwardQuery,
+		UTM:          utmQuery,
+	}, nil, nil
+}
+
+// Save stores u, links without an alias get one from aliasGen and a new one every time
+// it's already taken, at most attempts times. the returned link has the alias it was saved with.
+// ErrUrlExists only comes back for aliases the client picked, ErrNoFreeAlias when the attempts ran out
+func Save(ctx context.Context, urlSaver URLSaver, aliasGen alias.Generator, attempts int, u storage.URL) (int64, storage.URL, error) {
+	const op = "handlers.url.save.Save"
+
+	if u.Alias != "" {
+		id, err := urlSaver.SaveURL(ctx, u)
+		return id, u, err
+	}
+
+	for i := 0; i < attempts; i++ {
+		generated, err := aliasGen.Generate(ctx)
+		if err != nil {
+			return 0, u, fmt.Errorf("%s: %w", op, err)
+		}
+
+		u.Alias = generated
+		id, err := urlSaver.SaveURL(ctx, u)
+		if errors.Is(err, storage.ErrUrlExists) {
+			continue
+		}
+		return id, u, err
+	}
+
+	return 0, storage.URL{}, fmt.Errorf("%s: %w", op, alias.ErrNoFreeAlias)
+}
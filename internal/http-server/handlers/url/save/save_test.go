@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -14,7 +16,13 @@ import (
 
 	"url-shortener/internal/http-server/handlers/url/save"
 	"url-shortener/internal/http-server/handlers/url/save/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/alias"
+	aliasMocks "url-shortener/internal/lib/alias/mocks"
 	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/lib/urlpolicy"
+	"url-shortener/internal/lib/utm"
+	"url-shortener/internal/storage"
 )
 
 // testing - we know if function actually works or not bc we test it not manually but with code (duh)
@@ -24,6 +32,9 @@ import (
 // so we use mock - and we generate that mock by using this:
 //go:generate go run github.com/vektra/mockery/v2@latest --name=URLSaver
 
+// who the requests come from, normally auth.New puts it into the context
+var user = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeWrite}, OwnerID: "alice"}
+
 func TestSaveHandler(t *testing.T) {
 	// this is a table-driven set, meaning that instead of writing 5 separate functions,
 	// we define the test case with 5 attributes
@@ -31,9 +42,18 @@ func TestSaveHandler(t *testing.T) {
 		name           string
 		alias          string
 		url            string
+		extra          string // raw json fields appended to the request body
 		respError      string
 		mockError      error
 		expectedStatus int
+		expectExpiry   bool   // the saved url has to carry expires_at
+		redirectCode   int    // what the saved url has to carry
+		protected      bool   // the saved url has to carry a password hash
+		maxClicks      int64  // what the saved url has to carry
+		scheduled      bool   // the saved url has to carry not_before
+		forward        bool   // the saved url has to forward path and query
+		savedURL       string // the destination as stored, url when empty
+		utmQuery       string // utm params kept for the redirect
 	}{
 		// this is a successful test case - user sends a valid alias with a valid url and it's all good
 		{
@@ -103,6 +123,259 @@ func TestSaveHandler(t *testing.T) {
 			respError:      "field Alias is not valid",
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "Permanent redirect",
+			alias:          "seoalias",
+			url:            "https://google.com",
+			extra:          `, "redirect_code": 301`,
+			redirectCode:   301,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Invalid redirect code",
+			alias:          "seoalias",
+			url:            "https://google.com",
+			extra:          `, "redirect_code": 303`,
+			respError:      "field RedirectCode is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		// ttl in seconds is turned into expires_at
+		{
+			name:           "With TTL",
+			alias:          "ttlalias",
+			url:            "https://google.com",
+			extra:          `, "ttl": 3600`,
+			expectedStatus: http.StatusCreated,
+			expectExpiry:   true,
+		},
+		{
+			name:           "With expires_at",
+			alias:          "expiresalias",
+			url:            "https://google.com",
+			extra:          `, "expires_at": "2999-01-01T00:00:00Z"`,
+			expectedStatus: http.StatusCreated,
+			expectExpiry:   true,
+		},
+		{
+			name:           "Negative TTL",
+			alias:          "ttlalias",
+			url:            "https://google.com",
+			extra:          `, "ttl": -5`,
+			respError:      "field TTL is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			// would overflow time.Duration and expire at some random time
+			name:           "TTL too big",
+			alias:          "ttlalias",
+			url:            "https://google.com",
+			extra:          `, "ttl": 9300000000`,
+			respError:      "field TTL is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "expires_at in the past",
+			alias:          "pastalias",
+			url:            "https://google.com",
+			extra:          `, "expires_at": "2000-01-01T00:00:00Z"`,
+			respError:      "field ExpiresAt must be in the future",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Both TTL and expires_at",
+			alias:          "bothalias",
+			url:            "https://google.com",
+			extra:          `, "ttl": 60, "expires_at": "2999-01-01T00:00:00Z"`,
+			respError:      "field ExpiresAt can't be used together with TTL",
+			expectedStatus: http.StatusBadRequest,
+		},
+		// campaign links, not_after is just another name for expires_at
+		{
+			name:           "Activation window",
+			alias:          "launch",
+			url:            "https://google.com",
+			extra:          `, "not_before": "2998-01-01T00:00:00Z", "not_after": "2999-01-01T00:00:00Z"`,
+			expectedStatus: http.StatusCreated,
+			expectExpiry:   true,
+			scheduled:      true,
+		},
+		{
+			name:           "not_before only",
+			alias:          "launch",
+			url:            "https://google.com",
+			extra:          `, "not_before": "2998-01-01T00:00:00Z"`,
+			expectedStatus: http.StatusCreated,
+			scheduled:      true,
+		},
+		{
+			name:           "not_before after the expiry",
+			alias:          "launch",
+			url:            "https://google.com",
+			extra:          `, "not_before": "2999-06-01T00:00:00Z", "not_after": "2999-01-01T00:00:00Z"`,
+			respError:      "field NotBefore must be before the expiry",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not_after in the past",
+			alias:          "launch",
+			url:            "https://google.com",
+			extra:          `, "not_after": "2000-01-01T00:00:00Z"`,
+			respError:      "field NotAfter must be in the future",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Both not_after and ttl",
+			alias:          "launch",
+			url:            "https://google.com",
+			extra:          `, "ttl": 60, "not_after": "2999-01-01T00:00:00Z"`,
+			respError:      "field NotAfter can't be used together with ExpiresAt or TTL",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Forwarding",
+			alias:          "docs",
+			url:            "https://google.com",
+			extra:          `, "forward_path": true, "forward_query": true`,
+			expectedStatus: http.StatusCreated,
+			forward:        true,
+		},
+		// the templates below fill in what the request leaves out, params of the url win over both
+		{
+			name:           "UTM on save",
+			alias:          "spring",
+			url:            "https://google.com/?ref=x",
+			extra:          `, "utm": {"source": "news", "campaign": "spring sale"}`,
+			expectedStatus: http.StatusCreated,
+			savedURL:       "https://google.com/?ref=x&utm_source=news&utm_medium=email&utm_campaign=spring+sale",
+		},
+		{
+			name:           "UTM from the templates only",
+			alias:          "spring",
+			url:            "https://google.com",
+			extra:          `, "utm": {}`,
+			expectedStatus: http.StatusCreated,
+			savedURL:       "https://google.com?utm_source=shortener&utm_medium=email",
+		},
+		{
+			name:           "UTM keeps params of the url",
+			alias:          "spring",
+			url:            "https://google.com/?utm_source=partner",
+			extra:          `, "utm": {"source": "news"}`,
+			expectedStatus: http.StatusCreated,
+			savedURL:       "https://google.com/?utm_source=partner&utm_medium=email",
+		},
+		{
+			name:           "UTM on redirect",
+			alias:          "spring",
+			url:            "https://google.com",
+			extra:          `, "utm": {"campaign": "spring"}, "utm_at": "redirect"`,
+			expectedStatus: http.StatusCreated,
+			utmQuery:       "utm_source=shortener&utm_medium=email&utm_campaign=spring",
+		},
+		{
+			name:           "utm_at without utm",
+			alias:          "spring",
+			url:            "https://google.com",
+			extra:          `, "utm_at": "redirect"`,
+			respError:      "field UTMAt is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown utm_at",
+			alias:          "spring",
+			url:            "https://google.com",
+			extra:          `, "utm": {}, "utm_at": "later"`,
+			respError:      "field UTMAt is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		// the url policy, the validator happily takes all of these
+		{
+			name:           "Javascript URL",
+			url:            "javascript:alert(1)",
+			respError:      `field URL is not allowed: scheme is not allowed: "javascript"`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Private address",
+			url:            "http://169.254.169.254/latest/meta-data",
+			respError:      "field URL is not allowed: private and loopback addresses are not allowed: 169.254.169.254",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Blocked host",
+			url:            "https://phish.evil.com/login",
+			respError:      "field URL is not allowed: host is blocked: phish.evil.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Link to itself",
+			url:            "https://sho.rt/abc",
+			respError:      "field URL is not allowed: links to this service would redirect to themselves: sho.rt",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Password",
+			alias:          "private",
+			url:            "https://google.com",
+			extra:          `, "password": "correct horse"`,
+			expectedStatus: http.StatusCreated,
+			protected:      true,
+		},
+		{
+			name:           "Password too short",
+			alias:          "private",
+			url:            "https://google.com",
+			extra:          `, "password": "abc"`,
+			respError:      "field Password is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Password too long for bcrypt",
+			alias:          "private",
+			url:            "https://google.com",
+			extra:          `, "password": "` + strings.Repeat("ж", 40) + `"`,
+			respError:      "field Password must be at most 72 bytes",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "One time link",
+			alias:          "once",
+			url:            "https://google.com",
+			extra:          `, "max_clicks": 1`,
+			expectedStatus: http.StatusCreated,
+			maxClicks:      1,
+		},
+		{
+			name:           "Negative max clicks",
+			alias:          "once",
+			url:            "https://google.com",
+			extra:          `, "max_clicks": -1`,
+			respError:      "field MaxClicks is not valid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		// reserved aliases, the case doesn't matter
+		{
+			name:           "Reserved alias",
+			alias:          "Admin",
+			url:            "https://google.com",
+			respError:      "field Alias is not allowed: alias is reserved",
+			expectedStatus: http.StatusBadRequest,
+		},
+		// profanity only matters for generated aliases
+		{
+			name:           "Picked alias with a blocked word",
+			alias:          "classic",
+			url:            "https://google.com",
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	policy, err := urlpolicy.New(urlpolicy.Options{DenyHosts: []string{"*.evil.com"}, SelfHosts: []string{"sho.rt"}})
+	require.NoError(t, err)
+	reserved := alias.NewReserved([]string{"admin"}, alias.DefaultProfanity)
+	templates := utm.Templates{
+		Default: utm.Params{Source: "shortener"},
+		Owners:  map[string]utm.Params{"alice": {Medium: "email"}},
 	}
 
 	// ok so here we go through the test cases
@@ -112,6 +385,11 @@ func TestSaveHandler(t *testing.T) {
 		// t.Run() creates a sub-test with the name in output and runs it in parallel
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel() // parallel is here btw
+
+			savedURL := tc.savedURL
+			if savedURL == "" {
+				savedURL = tc.url
+			}
 			// here we create a fake object, in this case, fake db object
 			urlSaverMock := mocks.NewURLSaver(t)
 			// here we program the mock so that
@@ -121,21 +399,29 @@ func TestSaveHandler(t *testing.T) {
 			// if the validation fails and url is empty, then we don't even call database
 			if tc.respError == "" || tc.mockError != nil {
 				// this line is - when SaveURL is called with the url from the test case,
-				// and some string - might be empty alias btw
-				urlSaverMock.On("SaveURL", tc.url, mock.AnythingOfType("string")).
+				// and any alias - might be generated btw
+				urlSaverMock.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool {
+					return u.URL == savedURL && u.UTM == tc.utmQuery && (u.ExpiresAt != nil) == tc.expectExpiry && u.OwnerID == "alice" &&
+						u.RedirectCode == tc.redirectCode && u.Protected() == tc.protected &&
+						u.MaxClicks == tc.maxClicks && (u.NotBefore != nil) == tc.scheduled &&
+						u.ForwardPath == tc.forward && u.ForwardQuery == tc.forward &&
+						// never the password itself
+						!strings.Contains(u.PasswordHash, "correct horse")
+				})).
 					// we return id = 1 and the error in the test case
 					Return(int64(1), tc.mockError).
 					// the call should be only once, if not, the test case is failed
 					Once()
 			}
 			// we create the save handler, pass it to the logger that discards logs, and pass the mock
-			handler := save.New(slogdiscard.NewDiscardLogger(), urlSaverMock)
+			handler := save.New(slogdiscard.NewDiscardLogger(), urlSaverMock, alias.Random{Length: 6}, 3, policy, reserved, templates)
 			// here we create a fake http request
 			// we build the json string
-			input := fmt.Sprintf(`{"url": "%s", "alias": "%s"}`, tc.url, tc.alias)
+			input := fmt.Sprintf(`{"url": "%s", "alias": "%s"%s}`, tc.url, tc.alias, tc.extra)
 			// we create a fake post request with the json
 			req, err := http.NewRequest(http.MethodPost, "/save", bytes.NewReader([]byte(input)))
 			require.NoError(t, err) // in case of creating request failed, we stop the test
+			req = req.WithContext(auth.WithPrincipal(req.Context(), user))
 			// here, we create a fake response recorder
 			rr := httptest.NewRecorder() // this is a fake http.ResponseWriter basically
 			handler.ServeHTTP(rr, req)   // this runs our handler with the fake request
@@ -149,6 +435,116 @@ func TestSaveHandler(t *testing.T) {
 			require.NoError(t, json.Unmarshal([]byte(body), &resp))
 
 			require.Equal(t, tc.respError, resp.Error)
+			require.Equal(t, tc.expectExpiry, resp.ExpiresAt != nil)
+			require.Equal(t, tc.protected, resp.Protected)
+			require.Equal(t, tc.scheduled, resp.NotBefore != nil)
+			if tc.expectedStatus == http.StatusCreated {
+				require.Equal(t, savedURL, resp.URL)
+			}
+		})
+	}
+}
+
+// generated aliases are picked again when taken, the ones clients ask for are not
+func TestSaveHandler_Retry(t *testing.T) {
+	const attempts = 3
+
+	cases := []struct {
+		name           string
+		alias          string          // what the client asked for
+		generated      []string        // what the generator hands out, in order
+		genError       error           // returned after generated runs out
+		taken          map[string]bool // aliases the storage already has
+		respError      string
+		expectedAlias  string
+		expectedStatus int
+	}{
+		{
+			name:           "Generated alias taken",
+			generated:      []string{"taken1", "fresh1"},
+			taken:          map[string]bool{"taken1": true},
+			expectedAlias:  "fresh1",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Every generated alias taken",
+			generated:      []string{"taken1", "taken2", "taken3"},
+			taken:          map[string]bool{"taken1": true, "taken2": true, "taken3": true},
+			respError:      "failed to generate alias",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Requested alias taken",
+			alias:          "mine",
+			taken:          map[string]bool{"mine": true},
+			respError:      "url already exists",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Generator error",
+			genError:       errors.New("sequence is gone"),
+			respError:      "failed to add url",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	policy, err := urlpolicy.New(urlpolicy.Options{})
+	require.NoError(t, err)
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			genMock := aliasMocks.NewGenerator(t)
+			for _, a := range tc.generated {
+				genMock.On("Generate", mock.Anything).Return(a, nil).Once()
+			}
+			if tc.genError != nil {
+				genMock.On("Generate", mock.Anything).Return("", tc.genError).Once()
+			}
+
+			urlSaverMock := mocks.NewURLSaver(t)
+			for _, a := range append(tc.generated, tc.alias) {
+				if a == "" {
+					continue
+				}
+				var err error
+				if tc.taken[a] {
+					err = storage.ErrUrlExists
+				}
+				urlSaverMock.On("SaveURL", mock.Anything, mock.MatchedBy(func(u storage.URL) bool { return u.Alias == a })).
+					Return(int64(1), err).Once()
+			}
+
+			handler := save.New(slogdiscard.NewDiscardLogger(), urlSaverMock, genMock, attempts, policy, alias.NewReserved(nil, nil), utm.Templates{})
+
+			input := fmt.Sprintf(`{"url": "https://google.com", "alias": "%s"}`, tc.alias)
+			req, err := http.NewRequest(http.MethodPost, "/url", bytes.NewReader([]byte(input)))
+			require.NoError(t, err)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), user))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp save.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			require.Equal(t, tc.expectedAlias, resp.Alias)
 		})
 	}
 }
+
+// the handler logs every request it decodes
+func TestRequest_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	log.Info("request body decoded", slog.Any("request", save.Request{URL: "https://google.com", Password: "correct horse"}))
+
+	require.Contains(t, buf.String(), "https://google.com")
+	require.NotContains(t, buf.String(), "correct horse")
+}
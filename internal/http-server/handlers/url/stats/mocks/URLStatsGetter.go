@@ -0,0 +1,59 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+	storage "url-shortener/internal/storage"
+)
+
+// URLStatsGetter is an autogenerated mock type for the URLStatsGetter type
+type URLStatsGetter struct {
+	mock.Mock
+}
+
+// GetURLStats provides a mock function with given fields: ctx, alias, owner, since, top
+func (_m *URLStatsGetter) GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	ret := _m.Called(ctx, alias, owner, since, top)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetURLStats")
+	}
+
+	var r0 storage.URLStats
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, int) (storage.URLStats, error)); ok {
+		return rf(ctx, alias, owner, since, top)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, int) storage.URLStats); ok {
+		r0 = rf(ctx, alias, owner, since, top)
+	} else {
+		r0 = ret.Get(0).(storage.URLStats)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Time, int) error); ok {
+		r1 = rf(ctx, alias, owner, since, top)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewURLStatsGetter creates a new instance of URLStatsGetter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewURLStatsGetter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *URLStatsGetter {
+	mock := &URLStatsGetter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
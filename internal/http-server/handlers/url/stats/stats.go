@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"url-shortener/internal/http-server/middleware/auth"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultDays = 30
+	maxDays     = 365
+	defaultTop  = 10
+	maxTop      = 100
+)
+
+type Response struct {
+	resp.Response
+	Alias         string    `json:"alias"`
+	Total         int64     `json:"total"`
+	Daily         []Day     `json:"daily"`
+	TopReferrers  []Counter `json:"top_referrers"`
+	TopUserAgents []Counter `json:"top_user_agents"`
+}
+
+// Day is one bar of the daily histogram
+type Day struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Counter is a referrer or user agent with its number of clicks
+type Counter struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=URLStatsGetter
+type URLStatsGetter interface {
+	GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error)
+}
+
+// New - GET /url/{alias}/stats?days=30&top=10
+func New(log *slog.Logger, statsGetter URLStatsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.stats.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		alias := chi.URLParam(r, "alias")
+		if alias == "" {
+			log.Info("alias is empty")
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("not found"))
+
+			return
+		}
+
+		days, ok := queryInt(r, "days", defaultDays, maxDays)
+		if !ok {
+			log.Info("invalid days", slog.String("days", r.URL.Query().Get("days")))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid days"))
+
+			return
+		}
+
+		top, ok := queryInt(r, "top", defaultTop, maxTop)
+		if !ok {
+			log.Info("invalid top", slog.String("top", r.URL.Query().Get("top")))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid top"))
+
+			return
+		}
+
+		// histogram covers today plus the days before it, counted in UTC days
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(days - 1))
+
+		stats, err := statsGetter.GetURLStats(r.Context(), alias, principal.OwnerScope(), since, top)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			log.Info("url not found", slog.String("alias", alias))
+
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("url not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get stats", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to get stats"))
+
+			return
+		}
+
+		response := Response{
+			Response:      resp.OK(),
+			Alias:         stats.Alias,
+			Total:         stats.Total,
+			Daily:         make([]Day, 0, len(stats.Daily)),
+			TopReferrers:  counters(stats.TopReferrers),
+			TopUserAgents: counters(stats.TopUserAgents),
+		}
+		for _, d := range stats.Daily {
+			response.Daily = append(response.Daily, Day{Date: d.Day, Clicks: d.Clicks})
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// queryInt reads a positive int query param, missing means def
+func queryInt(r *http.Request, name string, def int, max int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+// counters never returns nil, so the json has [] instead of null
+func counters(in []storage.Counter) []Counter {
+	out := make([]Counter, 0, len(in))
+	for _, c := range in {
+		out = append(out, Counter{Value: c.Value, Clicks: c.Clicks})
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package stats_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/url/stats"
+	"url-shortener/internal/http-server/handlers/url/stats/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/storage"
+)
+
+// auth.New puts a principal into every request that gets this far
+var user = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeRead}, OwnerID: "alice"}
+
+func TestStatsHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		alias          string
+		query          string
+		top            int // what the storage should be asked for, 0 means not called
+		mockStats      storage.URLStats
+		mockError      error
+		respError      string
+		expectedStatus int
+	}{
+		{
+			name:  "Success",
+			alias: "test_alias",
+			top:   10,
+			mockStats: storage.URLStats{
+				Alias:         "test_alias",
+				Total:         3,
+				Daily:         []storage.DailyClicks{{Day: "2025-01-01", Clicks: 3}},
+				TopReferrers:  []storage.Counter{{Value: "https://a.com", Clicks: 3}},
+				TopUserAgents: []storage.Counter{{Value: "curl", Clicks: 3}},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Custom top",
+			alias:          "test_alias",
+			query:          "?top=3&days=7",
+			top:            3,
+			mockStats:      storage.URLStats{Alias: "test_alias"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Empty alias",
+			alias:          "",
+			respError:      "not found",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid days",
+			alias:          "test_alias",
+			query:          "?days=abc",
+			respError:      "invalid days",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Too many days",
+			alias:          "test_alias",
+			query:          "?days=1000",
+			respError:      "invalid days",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid top",
+			alias:          "test_alias",
+			query:          "?top=0",
+			respError:      "invalid top",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "URL not found",
+			alias:          "notfound",
+			top:            10,
+			mockError:      storage.ErrURLNotFound,
+			respError:      "url not found",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "GetURLStats error",
+			alias:          "test_alias",
+			top:            10,
+			mockError:      errors.New("unexpected error"),
+			respError:      "failed to get stats",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			statsGetterMock := mocks.NewURLStatsGetter(t)
+			if tc.top != 0 {
+				statsGetterMock.On("GetURLStats", mock.Anything, tc.alias, "alice", mock.AnythingOfType("time.Time"), tc.top).
+					Return(tc.mockStats, tc.mockError).
+					Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", tc.alias)
+
+			req, err := http.NewRequest(http.MethodGet, "/url/"+tc.alias+"/stats"+tc.query, nil)
+			require.NoError(t, err)
+			req = req.WithContext(auth.WithPrincipal(context.WithValue(req.Context(), chi.RouteCtxKey, rctx), user))
+
+			handler := stats.New(slogdiscard.NewDiscardLogger(), statsGetterMock)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+
+			var resp stats.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, tc.mockStats.Total, resp.Total)
+				require.Len(t, resp.Daily, len(tc.mockStats.Daily))
+				require.Len(t, resp.TopReferrers, len(tc.mockStats.TopReferrers))
+			}
+		})
+	}
+}
+
+// the histogram window always starts at midnight UTC
+func TestStatsHandler_Since(t *testing.T) {
+	statsGetterMock := mocks.NewURLStatsGetter(t)
+	statsGetterMock.On("GetURLStats", mock.Anything, "test_alias", "alice", mock.MatchedBy(func(since time.Time) bool {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		return since.Equal(today.AddDate(0, 0, -6))
+	}), 10).Return(storage.URLStats{Alias: "test_alias"}, nil).Once()
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("alias", "test_alias")
+
+	req, err := http.NewRequest(http.MethodGet, "/url/test_alias/stats?days=7", nil)
+	require.NoError(t, err)
+	req = req.WithContext(auth.WithPrincipal(context.WithValue(req.Context(), chi.RouteCtxKey, rctx), user))
+
+	rr := httptest.NewRecorder()
+	stats.New(slogdiscard.NewDiscardLogger(), statsGetterMock).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+}
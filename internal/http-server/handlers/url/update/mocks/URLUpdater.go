@@ -0,0 +1,57 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	storage "url-shortener/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// URLUpdater is an autogenerated mock type for the URLUpdater type
+type URLUpdater struct {
+	mock.Mock
+}
+
+// UpdateURL provides a mock function with given fields: ctx, alias, owner, newURL, version
+func (_m *URLUpdater) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	ret := _m.Called(ctx, alias, owner, newURL, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateURL")
+	}
+
+	var r0 storage.URL
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int64) (storage.URL, error)); ok {
+		return rf(ctx, alias, owner, newURL, version)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int64) storage.URL); ok {
+		r0 = rf(ctx, alias, owner, newURL, version)
+	} else {
+		r0 = ret.Get(0).(storage.URL)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, int64) error); ok {
+		r1 = rf(ctx, alias, owner, newURL, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewURLUpdater creates a new instance of URLUpdater. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewURLUpdater(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *URLUpdater {
+	mock := &URLUpdater{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,201 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"url-shortener/internal/http-server/handlers/url/save"
+	"url-shortener/internal/http-server/middleware/auth"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Request struct {
+	// same rules as save.Request
+	URL string `json:"url" validate:"required,url"`
+}
+
+type Response struct {
+	resp.Response
+	Alias   string `json:"alias,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Version int64  `json:"version,omitempty"`
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=URLUpdater
+type URLUpdater interface {
+	UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error)
+}
+
+// New - PATCH /url/{alias}, send If-Match: "<version>" to only update what you've seen.
+// the new url goes through the same checker as on save, links with a reserved alias
+// (saved before it was reserved) can only be deleted
+func New(log *slog.Logger, urlUpdater URLUpdater, checker save.URLChecker, reserved save.AliasChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.url.update.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			sl.Trace(r.Context()),
+		)
+
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok {
+			// the route is not behind auth.New, better nothing than everyone's links
+			log.Error("no principal in request context")
+
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		alias := chi.URLParam(r, "alias")
+		if alias == "" {
+			log.Info("alias is empty")
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("not found"))
+
+			return
+		}
+
+		if len(alias) < 3 || len(alias) > 15 {
+			log.Info("invalid alias length", slog.String("alias", alias))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid alias"))
+
+			return
+		}
+
+		if err := reserved.Check(alias); err != nil {
+			log.Info("alias is reserved", slog.String("alias", alias))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.NotAllowed("Alias", err))
+
+			return
+		}
+
+		version, err := parseIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			log.Info("invalid If-Match", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid If-Match header"))
+
+			return
+		}
+
+		var req Request
+
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Any("request", req))
+
+		if err := validator.New().Struct(req); err != nil {
+			var validateErr validator.ValidationErrors
+			errors.As(err, &validateErr)
+
+			log.Error("invalid request", sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		if err := checker.Check(req.URL, r.Host); err != nil {
+			log.Info("url is not allowed", slog.String("url", req.URL), sl.Err(err))
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.NotAllowed("URL", err))
+
+			return
+		}
+
+		updated, err := urlUpdater.UpdateURL(r.Context(), alias, principal.OwnerScope(), req.URL, version)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			log.Info("url not found", slog.String("alias", alias))
+
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, resp.Error("url not found"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			log.Info("version mismatch", slog.String("alias", alias), slog.Int64("version", version))
+
+			render.Status(r, http.StatusPreconditionFailed)
+			render.JSON(w, r, resp.Error("url was changed, fetch it again"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to update url", sl.Err(err))
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to update url"))
+
+			return
+		}
+
+		log.Info("url updated", slog.String("alias", alias), slog.Int64("version", updated.Version))
+
+		w.Header().Set("ETag", ETag(updated.Version))
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Alias:    updated.Alias,
+			URL:      updated.URL,
+			Version:  updated.Version,
+		})
+	}
+}
+
+// ETag is how a link version is shown in headers
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch returns the version from If-Match, 0 when there is no precondition
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	// If-Match always uses strong comparison, a weak tag never matches
+	if strings.HasPrefix(header, "W/") {
+		return 0, fmt.Errorf("weak etag %s", header)
+	}
+
+	raw, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, fmt.Errorf("etag %s is not quoted", header)
+	}
+
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("etag %s is not a version", header)
+	}
+	return version, nil
+}
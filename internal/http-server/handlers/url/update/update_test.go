@@ -0,0 +1,234 @@
+package update_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/handlers/url/update"
+	"url-shortener/internal/http-server/handlers/url/update/mocks"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/lib/alias"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/lib/urlpolicy"
+)
+
+var (
+	user  = auth.Principal{Name: "ci", Scopes: []string{auth.ScopeRead, auth.ScopeWrite}, OwnerID: "alice"}
+	admin = auth.Principal{Name: "root", Scopes: []string{auth.ScopeAdmin}, OwnerID: "root"}
+)
+
+func TestUpdateHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		alias          string
+		body           string
+		ifMatch        string
+		version        int64 // version the storage is expected to be called with
+		respError      string
+		mockError      error
+		expectedStatus int
+		expectedETag   string
+		admin          bool   // updates anyone's link
+		anonymous      bool   // no principal at all
+		host           string // Host of the request
+	}{
+		{
+			name:           "Success",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"2"`,
+		},
+		{
+			name:           "Success with If-Match",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			ifMatch:        `"4"`,
+			version:        4,
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"5"`,
+		},
+		{
+			name:           "Admin",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			admin:          true,
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"2"`,
+		},
+		{
+			name:           "No principal",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			anonymous:      true,
+			respError:      "unauthorized",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "If-Match any",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			ifMatch:        "*",
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"2"`,
+		},
+		{
+			name:           "Stale version",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			ifMatch:        `"1"`,
+			version:        1,
+			respError:      "url was changed, fetch it again",
+			mockError:      storage.ErrVersionMismatch,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Invalid If-Match",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			ifMatch:        "1",
+			respError:      "invalid If-Match header",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Weak If-Match",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			ifMatch:        `W/"1"`,
+			respError:      "invalid If-Match header",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "URL not found",
+			alias:          "no_url",
+			body:           `{"url": "https://example.com/new"}`,
+			respError:      "url not found",
+			mockError:      storage.ErrURLNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Empty URL",
+			alias:          "test_alias",
+			body:           `{"url": ""}`,
+			respError:      "field URL is a required field",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid URL",
+			alias:          "test_alias",
+			body:           `{"url": "not a url"}`,
+			respError:      "field URL is not a valid URL",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Private address",
+			alias:          "test_alias",
+			body:           `{"url": "http://10.0.0.1/admin"}`,
+			respError:      "field URL is not allowed: private and loopback addresses are not allowed: 10.0.0.1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Link to itself",
+			alias:          "test_alias",
+			body:           `{"url": "https://sho.rt/other"}`,
+			host:           "sho.rt",
+			respError:      "field URL is not allowed: links to this service would redirect to themselves: sho.rt",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Broken body",
+			alias:          "test_alias",
+			body:           `{"url":`,
+			respError:      "failed to decode request",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Alias too short",
+			alias:          "ab",
+			body:           `{"url": "https://example.com/new"}`,
+			respError:      "invalid alias",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Reserved alias",
+			alias:          "healthz",
+			body:           `{"url": "https://example.com/new"}`,
+			respError:      "field Alias is not allowed: alias is reserved",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "UpdateURL error",
+			alias:          "test_alias",
+			body:           `{"url": "https://example.com/new"}`,
+			respError:      "failed to update url",
+			mockError:      errors.New("unexpected error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	policy, err := urlpolicy.New(urlpolicy.Options{})
+	require.NoError(t, err)
+	reserved := alias.NewReserved([]string{"healthz"}, nil)
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			urlUpdaterMock := mocks.NewURLUpdater(t)
+			principal := user
+			if tc.admin {
+				principal = admin
+			}
+			// only requests that passed validation reach the storage
+			if tc.mockError != nil || tc.expectedStatus == http.StatusOK {
+				updated := storage.URL{Alias: tc.alias, URL: "https://example.com/new", Version: tc.version + 1}
+				if tc.version == 0 {
+					updated.Version = 2
+				}
+				urlUpdaterMock.On("UpdateURL", mock.Anything, tc.alias, principal.OwnerScope(), "https://example.com/new", tc.version).
+					Return(updated, tc.mockError).Once()
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("alias", tc.alias)
+
+			req, err := http.NewRequest(http.MethodPatch, "/url/"+tc.alias, bytes.NewReader([]byte(tc.body)))
+			require.NoError(t, err)
+			req.Host = tc.host
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			if !tc.anonymous {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+			}
+
+			handler := update.New(slogdiscard.NewDiscardLogger(), urlUpdaterMock, policy, reserved)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Equal(t, tc.expectedETag, rr.Header().Get("ETag"))
+
+			var resp update.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tc.respError, resp.Error)
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, "https://example.com/new", resp.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/apikey"
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	// admin can do everything, including minting and revoking keys
+	ScopeAdmin = "admin"
+)
+
+// Scopes is every scope a key can have
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Principal is whoever made the request
+type Principal struct {
+	// 0 for the bootstrap BasicAuth user
+	KeyID  int64
+	Name   string
+	Scopes []string
+	// links created by the principal get this owner, never empty
+	OwnerID string
+}
+
+// Can reports whether the principal is allowed to use scope
+func (p Principal) Can(scope string) bool {
+	return slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+// OwnerScope is the owner storage calls are limited to, admins get "" and see every link
+func (p Principal) OwnerScope() string {
+	if p.Can(ScopeAdmin) {
+		return ""
+	}
+	return p.OwnerID
+}
+
+type ctxKey struct{}
+
+// WithPrincipal stores p in ctx, the middleware does it for every authenticated request
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// PrincipalFrom returns the principal the middleware put into ctx
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+//go:generate go run github.com/vektra/mockery/v2@latest --name=KeyStore
+type KeyStore interface {
+	GetAPIKey(ctx context.Context, hash string) (storage.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64, at time.Time) error
+}
+
+// last_used_at doesn't need to be exact, this saves a write per request
+const touchEvery = time.Minute
+
+// New checks "Authorization: Bearer <api key>".
+// user and password, if set, also let in a BasicAuth admin - that's how the first key gets minted
+func New(log *slog.Logger, keys KeyStore, user, password string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log = log.With(
+			slog.String("component", "middleware/auth"),
+		)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				sl.Trace(r.Context()),
+			)
+
+			if token, ok := bearer(r); ok {
+				k, err := keys.GetAPIKey(r.Context(), apikey.Hash(token))
+				if errors.Is(err, storage.ErrAPIKeyNotFound) || (err == nil && k.RevokedAt != nil) {
+					log.Info("invalid api key")
+
+					unauthorized(w, r, user != "", "invalid api key")
+
+					return
+				}
+				if err != nil {
+					log.Error("failed to get api key", sl.Err(err))
+
+					render.Status(r, http.StatusInternalServerError)
+					render.JSON(w, r, resp.Error("internal error"))
+
+					return
+				}
+
+				now := time.Now()
+				if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > touchEvery {
+					// a failed touch shouldn't fail the request
+					if err := keys.TouchAPIKey(r.Context(), k.ID, now); err != nil {
+						log.Error("failed to touch api key", slog.Int64("key_id", k.ID), sl.Err(err))
+					}
+				}
+
+				owner := k.OwnerID
+				if owner == "" {
+					// an empty owner would mean "everyone's links" in storage
+					owner = k.Name
+				}
+
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), Principal{
+					KeyID:   k.ID,
+					Name:    k.Name,
+					Scopes:  k.Scopes,
+					OwnerID: owner,
+				})))
+				return
+			}
+
+			if u, p, ok := r.BasicAuth(); ok && user != "" {
+				// constant time so the password can't be guessed char by char
+				if subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1 &&
+					subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1 {
+					next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), Principal{
+						Name:    user,
+						Scopes:  []string{ScopeAdmin},
+						OwnerID: user,
+					})))
+					return
+				}
+
+				log.Info("invalid basic auth credentials")
+			}
+
+			unauthorized(w, r, user != "", "unauthorized")
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Require lets through only principals with scope, it goes after New
+func Require(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFrom(r.Context())
+			if !ok || !p.Can(scope) {
+				render.Status(r, http.StatusForbidden)
+				render.JSON(w, r, resp.Error("missing scope "+scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func bearer(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, basic bool, msg string) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="url-shortener"`)
+	if basic {
+		w.Header().Add("WWW-Authenticate", `Basic realm="url-shortener"`)
+	}
+
+	render.Status(r, http.StatusUnauthorized)
+	render.JSON(w, r, resp.Error(msg))
+}
@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"url-shortener/internal/storage"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/http-server/middleware/auth/mocks"
+	"url-shortener/internal/lib/apikey"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestAuth(t *testing.T) {
+	const key = "us_test-key"
+	recently := time.Now().Add(-time.Second)
+	revoked := time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		name           string
+		user, password string // bootstrap credentials in config
+		setup          func(r *http.Request)
+		mockKey        *storage.APIKey
+		mockError      error
+		expectTouch    bool
+		scope          string // what the route requires
+		expectedStatus int
+		expectedName   string
+	}{
+		{
+			name:           "Bearer key",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockKey:        &storage.APIKey{ID: 7, Name: "ci", Scopes: []string{auth.ScopeWrite}},
+			expectTouch:    true,
+			scope:          auth.ScopeWrite,
+			expectedStatus: http.StatusOK,
+			expectedName:   "ci",
+		},
+		{
+			name:           "Recently used key is not touched",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "bearer "+key) },
+			mockKey:        &storage.APIKey{ID: 7, Name: "ci", Scopes: []string{auth.ScopeRead}, LastUsedAt: &recently},
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusOK,
+			expectedName:   "ci",
+		},
+		{
+			name:           "Missing scope",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockKey:        &storage.APIKey{ID: 7, Name: "ci", Scopes: []string{auth.ScopeRead}},
+			expectTouch:    true,
+			scope:          auth.ScopeAdmin,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Admin can do anything",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockKey:        &storage.APIKey{ID: 7, Name: "root", Scopes: []string{auth.ScopeAdmin}},
+			expectTouch:    true,
+			scope:          auth.ScopeWrite,
+			expectedStatus: http.StatusOK,
+			expectedName:   "root",
+		},
+		{
+			name:           "Revoked key",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockKey:        &storage.APIKey{ID: 7, Name: "ci", Scopes: []string{auth.ScopeRead}, RevokedAt: &revoked},
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown key",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockError:      storage.ErrAPIKeyNotFound,
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Storage error",
+			setup:          func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) },
+			mockError:      errors.New("unexpected error"),
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Bootstrap admin",
+			user:           "myuser",
+			password:       "mypass",
+			setup:          func(r *http.Request) { r.SetBasicAuth("myuser", "mypass") },
+			scope:          auth.ScopeAdmin,
+			expectedStatus: http.StatusOK,
+			expectedName:   "myuser",
+		},
+		{
+			name:           "Wrong password",
+			user:           "myuser",
+			password:       "mypass",
+			setup:          func(r *http.Request) { r.SetBasicAuth("myuser", "nope") },
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "BasicAuth disabled",
+			setup:          func(r *http.Request) { r.SetBasicAuth("", "") },
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "No credentials",
+			user:           "myuser",
+			password:       "mypass",
+			setup:          func(r *http.Request) {},
+			scope:          auth.ScopeRead,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keyStoreMock := mocks.NewKeyStore(t)
+			if tc.mockKey != nil || tc.mockError != nil {
+				var k storage.APIKey
+				if tc.mockKey != nil {
+					k = *tc.mockKey
+				}
+				keyStoreMock.On("GetAPIKey", mock.Anything, apikey.Hash(key)).Return(k, tc.mockError).Once()
+			}
+			if tc.expectTouch {
+				keyStoreMock.On("TouchAPIKey", mock.Anything, tc.mockKey.ID, mock.AnythingOfType("time.Time")).Return(nil).Once()
+			}
+
+			var gotName string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p, ok := auth.PrincipalFrom(r.Context())
+				require.True(t, ok)
+				gotName = p.Name
+			})
+
+			handler := auth.New(slogdiscard.NewDiscardLogger(), keyStoreMock, tc.user, tc.password)(
+				auth.Require(tc.scope)(next),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/url", nil)
+			tc.setup(req)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Equal(t, tc.expectedName, gotName)
+			if tc.expectedStatus == http.StatusUnauthorized {
+				require.NotEmpty(t, rr.Header().Values("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"log/slog"
+	"url-shortener/internal/lib/logger/sl"
 
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -26,6 +27,7 @@ func New(log *slog.Logger) func(next http.Handler) http.Handler {
 				slog.String("remote_addr", r.RemoteAddr),
 				slog.String("user_agent", r.UserAgent()),
 				slog.String("request_id", middleware.GetReqID(r.Context())),
+				sl.Trace(r.Context()),
 			)
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneEvery - how often Memory forgets buckets that are full anyway
+const pruneEvery = time.Minute
+
+// Memory keeps the buckets of this instance, with N replicas a client gets N times the limit
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// when the bucket is full again, after that it's the same as no bucket at all
+	full time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket)}
+}
+
+func (m *Memory) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	return m.take(key, limit, now, true), nil
+}
+
+func (m *Memory) Peek(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	return m.take(key, limit, now, false), nil
+}
+
+// take refills the bucket of key, and spends a token when spend is set and there is one
+func (m *Memory) take(key string, limit Limit, now time.Time, spend bool) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune(now)
+
+	burst := float64(limit.Burst)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		// a peek at a bucket nobody used yet doesn't need to keep it around
+		if !spend {
+			return Result{Allowed: true, Remaining: limit.Burst}
+		}
+		b = &bucket{tokens: burst, at: now}
+		m.buckets[key] = b
+	}
+
+	// refill for the time since the last request
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*limit.Rate)
+		b.at = now
+	}
+
+	var res Result
+	if b.tokens >= 1 {
+		if spend {
+			b.tokens--
+		}
+		res.Allowed = true
+	} else {
+		res.RetryAfter = refill(1-b.tokens, limit.Rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = refill(burst-b.tokens, limit.Rate)
+	b.full = now.Add(res.Reset)
+
+	return res
+}
+
+// prune runs under the lock, at most once every pruneEvery
+func (m *Memory) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < pruneEvery {
+		return
+	}
+	m.lastPrune = now
+
+	for key, b := range m.buckets {
+		if !b.full.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// refill is how long tokens take to come back
+func refill(tokens float64, rate float64) time.Duration {
+	if tokens <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,86 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	time "time"
+	ratelimit "url-shortener/internal/http-server/middleware/ratelimit"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Store is an autogenerated mock type for the Store type
+type Store struct {
+	mock.Mock
+}
+
+// Peek provides a mock function with given fields: ctx, key, limit, now
+func (_m *Store) Peek(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	ret := _m.Called(ctx, key, limit, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Peek")
+	}
+
+	var r0 ratelimit.Result
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, ratelimit.Limit, time.Time) (ratelimit.Result, error)); ok {
+		return rf(ctx, key, limit, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, ratelimit.Limit, time.Time) ratelimit.Result); ok {
+		r0 = rf(ctx, key, limit, now)
+	} else {
+		r0 = ret.Get(0).(ratelimit.Result)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, ratelimit.Limit, time.Time) error); ok {
+		r1 = rf(ctx, key, limit, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Take provides a mock function with given fields: ctx, key, limit, now
+func (_m *Store) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	ret := _m.Called(ctx, key, limit, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Take")
+	}
+
+	var r0 ratelimit.Result
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, ratelimit.Limit, time.Time) (ratelimit.Result, error)); ok {
+		return rf(ctx, key, limit, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, ratelimit.Limit, time.Time) ratelimit.Result); ok {
+		r0 = rf(ctx, key, limit, now)
+	} else {
+		r0 = ret.Get(0).(ratelimit.Result)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, ratelimit.Limit, time.Time) error); ok {
+		r1 = rf(ctx, key, limit, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewStore creates a new instance of Store. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *Store {
+	mock := &Store{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
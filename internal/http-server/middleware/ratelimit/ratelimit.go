@@ -0,0 +1,172 @@
+// Package ratelimit throttles clients with token buckets - every request takes a token,
+// tokens come back at a steady rate and a bucket holds at most a burst of them
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"url-shortener/internal/http-server/middleware/auth"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/sl"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// Limit is Rate requests a second on average, up to Burst of them at once
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is what a Store says about a request
+type Result struct {
+	Allowed bool
+	// tokens left after this request
+	Remaining int
+	// until the bucket is full again
+	Reset time.Duration
+	// until the next token, only set when the request isn't allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. Memory is enough for a single instance,
+// replicas that should share their limits need one backed by something they all talk to
+//
+//go:generate go run github.com/vektra/mockery/v2@latest --name=Store
+type Store interface {
+	// Take spends a token from the bucket of key, if there is one
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// Peek says what Take would, without spending anything
+	Peek(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// KeyFunc picks the bucket of a request
+type KeyFunc func(r *http.Request) string
+
+// ByIP is the client address, after middleware.RealIP that's the one from the proxy headers
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// RealIP leaves just the ip, no port
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ByPrincipal is the api key, or the BasicAuth user. it goes after auth.New,
+// without a principal it falls back to ByIP
+func ByPrincipal(r *http.Request) string {
+	p, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		return ByIP(r)
+	}
+	if p.KeyID != 0 {
+		return "key:" + strconv.FormatInt(p.KeyID, 10)
+	}
+	return "user:" + p.Name
+}
+
+// New lets through limit.Rate requests a second per key, with bursts of limit.Burst.
+// name separates the buckets of different limits in a shared store.
+// every answer gets RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset,
+// a refused one is a 429 with Retry-After. when the store fails the request goes through
+func New(log *slog.Logger, store Store, name string, limit Limit, key KeyFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log = log.With(
+			slog.String("component", "middleware/ratelimit"),
+			slog.String("limit", name),
+		)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			res, err := store.Take(r.Context(), name+":"+key(r), limit, time.Now())
+			if err != nil {
+				// better an unthrottled request than an outage because of the limiter
+				log.Error("failed to take a token",
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+					sl.Err(err),
+				)
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", seconds(res.Reset))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", seconds(res.RetryAfter))
+
+				render.Status(r, http.StatusTooManyRequests)
+				render.JSON(w, r, resp.Error("rate limit exceeded"))
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// NewFailures only charges requests answered with status - a 401 when it goes before auth.New,
+// a 403 for the password of a protected link. it keeps clients from guessing keys and passwords,
+// without throttling the ones that have them.
+// once the bucket of a client is empty every request of it is a 429, the right credentials too
+func NewFailures(log *slog.Logger, store Store, name string, limit Limit, key KeyFunc, status int) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		log := log.With(
+			slog.String("component", "middleware/ratelimit"),
+			slog.String("limit", name),
+		)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			bucket := name + ":" + key(r)
+
+			res, err := store.Peek(r.Context(), bucket, limit, time.Now())
+			if err != nil {
+				// same as New, the limiter failing doesn't take the api down
+				log.Error("failed to peek at bucket",
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+					sl.Err(err),
+				)
+			} else if !res.Allowed {
+				w.Header().Set("Retry-After", seconds(res.RetryAfter))
+
+				render.Status(r, http.StatusTooManyRequests)
+				render.JSON(w, r, resp.Error("too many failed attempts"))
+
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			if ww.Status() != status {
+				return
+			}
+			// the request is already answered, only the next ones can be refused
+			if _, err := store.Take(context.WithoutCancel(r.Context()), bucket, limit, time.Now()); err != nil {
+				log.Error("failed to take a token",
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+					sl.Err(err),
+				)
+			}
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// seconds rounds up, a client retrying a moment too early is refused again
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,230 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/http-server/middleware/ratelimit"
+	"url-shortener/internal/http-server/middleware/ratelimit/mocks"
+	resp "url-shortener/internal/lib/api/response"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+)
+
+func TestMemory_Take(t *testing.T) {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+	now := time.Now()
+
+	m := ratelimit.NewMemory()
+
+	// a new client gets the whole burst
+	for i := 2; i >= 0; i-- {
+		res, err := m.Take(ctx, "a", limit, now)
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+		require.Equal(t, i, res.Remaining)
+	}
+
+	res, err := m.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	require.Equal(t, 1500*time.Millisecond, res.Reset)
+
+	// other keys have their own bucket
+	res, err = m.Take(ctx, "b", limit, now)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+
+	// 2 a second, so half a second later there is a token again
+	res, err = m.Take(ctx, "a", limit, now.Add(500*time.Millisecond))
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+
+	// and never more than the burst, however long the client was away
+	res, err = m.Take(ctx, "a", limit, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, res.Remaining)
+}
+
+func TestMemory_Peek(t *testing.T) {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	m := ratelimit.NewMemory()
+
+	// peeking is free, however often
+	for i := 0; i < 3; i++ {
+		res, err := m.Peek(ctx, "a", limit, now)
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+	}
+
+	_, err := m.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+
+	res, err := m.Peek(ctx, "a", limit, now)
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+
+	res, err = m.Peek(ctx, "a", limit, now.Add(time.Second))
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+}
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		name           string
+		result         ratelimit.Result
+		storeErr       error
+		expectedStatus int
+		// headers expected in the answer, "" means not set
+		remaining, reset, retryAfter string
+	}{
+		{
+			name:           "Allowed",
+			result:         ratelimit.Result{Allowed: true, Remaining: 4, Reset: 300 * time.Millisecond},
+			expectedStatus: http.StatusOK,
+			remaining:      "4",
+			reset:          "1",
+		},
+		{
+			name:           "Limited",
+			result:         ratelimit.Result{Remaining: 0, Reset: 2500 * time.Millisecond, RetryAfter: 100 * time.Millisecond},
+			expectedStatus: http.StatusTooManyRequests,
+			remaining:      "0",
+			reset:          "3",
+			retryAfter:     "1",
+		},
+		{
+			name:           "Store error lets it through",
+			storeErr:       errors.New("connection refused"),
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			limit := ratelimit.Limit{Rate: 1, Burst: 5}
+
+			store := mocks.NewStore(t)
+			store.On("Take", mock.Anything, "api:ip:10.0.0.1", limit, mock.Anything).
+				Return(tc.result, tc.storeErr).Once()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+			handler := ratelimit.New(slogdiscard.NewDiscardLogger(), store, "api", limit, ratelimit.ByIP)(next)
+
+			req := httptest.NewRequest(http.MethodGet, "/url", nil)
+			req.RemoteAddr = "10.0.0.1:51234"
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Equal(t, tc.remaining, rr.Header().Get("RateLimit-Remaining"))
+			require.Equal(t, tc.reset, rr.Header().Get("RateLimit-Reset"))
+			require.Equal(t, tc.retryAfter, rr.Header().Get("Retry-After"))
+			if tc.storeErr == nil {
+				require.Equal(t, "5", rr.Header().Get("RateLimit-Limit"))
+			}
+
+			if tc.expectedStatus == http.StatusTooManyRequests {
+				var body resp.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+				require.Equal(t, "rate limit exceeded", body.Error)
+			}
+		})
+	}
+}
+
+func TestFailures(t *testing.T) {
+	limit := ratelimit.Limit{Rate: 0.01, Burst: 3}
+
+	// stands in for auth.New, only the right password gets through
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, _ := r.BasicAuth(); pass != "right" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := ratelimit.NewFailures(slogdiscard.NewDiscardLogger(), ratelimit.NewMemory(), "auth", limit, ratelimit.ByIP, http.StatusUnauthorized)(next)
+
+	do := func(ip string, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/url", nil)
+		req.RemoteAddr = ip + ":51234"
+		req.SetBasicAuth("admin", pass)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// successful requests are never charged
+	for i := 0; i < 10; i++ {
+		require.Equal(t, http.StatusOK, do("10.0.0.1", "right").Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusUnauthorized, do("10.0.0.1", "guess").Code)
+	}
+
+	rr := do("10.0.0.1", "guess")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	var body resp.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	require.Equal(t, "too many failed attempts", body.Error)
+
+	// locked out is locked out, the right password included
+	require.Equal(t, http.StatusTooManyRequests, do("10.0.0.1", "right").Code)
+	// other clients aren't
+	require.Equal(t, http.StatusOK, do("10.0.0.2", "right").Code)
+}
+
+func TestFailures_StoreError(t *testing.T) {
+	store := mocks.NewStore(t)
+	store.On("Peek", mock.Anything, "auth:ip:10.0.0.1", mock.Anything, mock.Anything).
+		Return(ratelimit.Result{}, errors.New("connection refused")).Once()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := ratelimit.NewFailures(slogdiscard.NewDiscardLogger(), store, "auth", ratelimit.Limit{Rate: 1, Burst: 1}, ratelimit.ByIP, http.StatusUnauthorized)(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/url", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestByPrincipal(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/url", nil)
+	req.RemoteAddr = "10.0.0.1"
+	require.Equal(t, "ip:10.0.0.1", ratelimit.ByPrincipal(req))
+
+	key := req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{KeyID: 7, Name: "ci"}))
+	require.Equal(t, "key:7", ratelimit.ByPrincipal(key))
+
+	user := req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Name: "admin"}))
+	require.Equal(t, "user:admin", ratelimit.ByPrincipal(user))
+}
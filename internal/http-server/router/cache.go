@@ -0,0 +1,51 @@
+package router
+
+import (
+	"context"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+)
+
+// cachedStorage answers redirects from the cache and drops a link from it whenever it's written,
+// saves included - the alias could be remembered as missing
+type cachedStorage struct {
+	Storage
+	cache *cache.Cache
+}
+
+func (s cachedStorage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	return s.cache.GetURL(ctx, alias)
+}
+
+// invalidation runs even when the write failed, it's cheap and the write might have gone through anyway
+
+func (s cachedStorage) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
+	defer s.cache.Invalidate(u.Alias)
+	return s.Storage.SaveURL(ctx, u)
+}
+
+func (s cachedStorage) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	aliases := make([]string, 0, len(urls))
+	for _, u := range urls {
+		aliases = append(aliases, u.Alias)
+	}
+
+	defer s.cache.Invalidate(aliases...)
+	return s.Storage.SaveURLs(ctx, urls)
+}
+
+func (s cachedStorage) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	defer s.cache.Invalidate(alias)
+	return s.Storage.UpdateURL(ctx, alias, owner, newURL, version)
+}
+
+func (s cachedStorage) DeleteURL(ctx context.Context, alias string, owner string) error {
+	defer s.cache.Invalidate(alias)
+	return s.Storage.DeleteURL(ctx, alias, owner)
+}
+
+func (s cachedStorage) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	defer s.cache.Invalidate(aliases...)
+	return s.Storage.DeleteURLs(ctx, aliases, owner)
+}
@@ -0,0 +1,230 @@
+package router
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"url-shortener/internal/config"
+	keyList "url-shortener/internal/http-server/handlers/apikey/list"
+	"url-shortener/internal/http-server/handlers/apikey/mint"
+	"url-shortener/internal/http-server/handlers/apikey/revoke"
+	"url-shortener/internal/http-server/handlers/cachestats"
+	"url-shortener/internal/http-server/handlers/health"
+	"url-shortener/internal/http-server/handlers/url/batch"
+	"url-shortener/internal/http-server/handlers/url/delete"
+	"url-shortener/internal/http-server/handlers/url/list"
+	"url-shortener/internal/http-server/handlers/url/redirect"
+	"url-shortener/internal/http-server/handlers/url/save"
+	"url-shortener/internal/http-server/handlers/url/stats"
+	"url-shortener/internal/http-server/handlers/url/update"
+	"url-shortener/internal/http-server/middleware/auth"
+	"url-shortener/internal/http-server/middleware/ratelimit"
+	"url-shortener/internal/lib/alias"
+	"url-shortener/internal/lib/urlpolicy"
+	"url-shortener/internal/lib/utm"
+	"url-shortener/internal/metrics"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/tracing"
+
+	mwLogger "url-shortener/internal/http-server/middleware/logger"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Storage is everything the handlers need, every backend has to provide it
+type Storage interface {
+	save.URLSaver
+	redirect.URLGetter
+	delete.URLDeleter
+	stats.URLStatsGetter
+	list.URLLister
+	update.URLUpdater
+	batch.URLBatchSaver
+	batch.URLBatchDeleter
+	auth.KeyStore
+	mint.APIKeySaver
+	revoke.APIKeyRevoker
+	keyList.APIKeyLister
+	health.ReadinessChecker
+}
+
+// New wires middlewares and handlers together,
+// it lives here and not in main.go so the e2e tests can run the same router in-process.
+// urlCache sits in front of the storage for redirects, nil turns it off, so does a nil appMetrics for /metrics.
+// urlPolicy checks destinations on save and update, it can't be nil.
+// reservedAliases gets every route added to it, nil reserves just the routes.
+// limitStore keeps the rate limit buckets, nil keeps them in memory.
+// schemaVersion is the migration the storage has to be at for /readyz, 0 for memory storage
+func New(
+	log *slog.Logger,
+	configuration *config.Config,
+	storage Storage,
+	urlCache *cache.Cache,
+	clickRecorder redirect.ClickRecorder,
+	aliasGen alias.Generator,
+	urlPolicy *urlpolicy.Policy,
+	reservedAliases *alias.Reserved,
+	limitStore ratelimit.Store,
+	appMetrics *metrics.Metrics,
+	schemaVersion uint,
+) http.Handler {
+	if urlCache != nil {
+		storage = cachedStorage{Storage: storage, cache: urlCache}
+	}
+	if reservedAliases == nil {
+		reservedAliases = alias.NewReserved(nil, nil)
+	}
+	aliasGen = reservedAliases.Filter(aliasGen)
+	if limitStore == nil {
+		limitStore = ratelimit.NewMemory()
+	}
+
+	router := chi.NewRouter()
+	// middleware - other handlers for like auth
+	// this one adds request id to every request
+	router.Use(middleware.RequestID)
+	// why would you need user's IP but alright brodie
+	router.Use(middleware.RealIP)
+	// a span per request, before the loggers so their lines carry the trace id
+	router.Use(tracing.Middleware)
+	// logging, except for the probes - they come every few seconds and bury the real requests
+	router.Use(middleware.Maybe(middleware.Logger, isNotProbe))
+	router.Use(middleware.Maybe(mwLogger.New(log), isNotProbe))
+	// outside of Recoverer, so panics are counted as the 500 they turn into
+	if appMetrics != nil {
+		router.Use(appMetrics.Middleware)
+	}
+	// in case of panics
+	router.Use(middleware.Recoverer)
+	// cancels the request context, and with it the queries of the request, once the server gave up on it
+	if configuration.HTTPServer.Timeout > 0 {
+		router.Use(middleware.Timeout(configuration.HTTPServer.Timeout))
+	}
+	// /address/{id}
+	router.Use(middleware.URLFormat)
+
+	// api keys, plus the BasicAuth user from config as a bootstrap admin
+	authenticate := auth.New(log, storage, configuration.HTTPServer.User, configuration.HTTPServer.Password)
+
+	// before authenticate, clients that keep failing it are locked out for a while.
+	// by ip, there is no principal yet
+	authFailures := failureLimit(log, limitStore, "auth", configuration.RateLimit.AuthFailureRate, configuration.RateLimit.AuthFailureBurst, ratelimit.ByIP, http.StatusUnauthorized)
+	// same for the password of protected links, a wrong one is a 403
+	passwordFailures := failureLimit(log, limitStore, "password", configuration.RateLimit.AuthFailureRate, configuration.RateLimit.AuthFailureBurst, byLinkAndIP, http.StatusForbidden)
+	// after authenticate, the api is limited per key
+	apiLimit := limit(log, limitStore, "api", configuration.RateLimit.APIRate, configuration.RateLimit.APIBurst, ratelimit.ByPrincipal)
+	redirectLimit := limit(log, limitStore, "redirect", configuration.RateLimit.RedirectRate, configuration.RateLimit.RedirectBurst, ratelimit.ByIP)
+
+	templates := utmTemplates(configuration.UTM)
+
+	router.Route("/url", func(r chi.Router) {
+		r.Use(authFailures)
+		r.Use(authenticate)
+		r.Use(apiLimit)
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Require(auth.ScopeRead))
+			r.Get("/", list.New(log, storage))
+			r.Get("/{alias}/stats", stats.New(log, storage))
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Require(auth.ScopeWrite))
+			r.Post("/", save.New(log, storage, aliasGen, configuration.Alias.Attempts, urlPolicy, reservedAliases, templates))
+			r.Post("/batch", batch.NewSave(log, storage, aliasGen, configuration.Alias.Attempts, urlPolicy, reservedAliases, templates))
+			r.Delete("/batch", batch.NewDelete(log, storage))
+			r.Patch("/{alias}", update.New(log, storage, urlPolicy, reservedAliases))
+			r.Delete("/{alias}", delete.New(log, storage))
+		})
+	})
+
+	router.Route("/admin/keys", func(r chi.Router) {
+		r.Use(authFailures)
+		r.Use(authenticate)
+		r.Use(apiLimit)
+		r.Use(auth.Require(auth.ScopeAdmin))
+		r.Get("/", keyList.New(log, storage))
+		r.Post("/", mint.New(log, storage))
+		r.Delete("/{id}", revoke.New(log, storage))
+	})
+
+	if urlCache != nil {
+		router.With(authFailures, authenticate, apiLimit, auth.Require(auth.ScopeAdmin)).Get("/admin/cache", cachestats.New(urlCache))
+	}
+
+	router.Get("/healthz", health.NewLive())
+	router.Get("/readyz", health.NewReady(log, storage, schemaVersion))
+	if appMetrics != nil {
+		router.Method(http.MethodGet, "/metrics", appMetrics.Handler())
+	}
+
+	redirectHandler := redirect.New(log, storage, clickRecorder, redirect.Options{
+		DefaultCode: configuration.Redirect.Code,
+		Unlocker:    redirect.NewUnlocker(configuration.Redirect.UnlockSecret, configuration.Redirect.UnlockTTL),
+		InactiveURL: configuration.Redirect.InactiveURL,
+	})
+	// the password form of protected links posts back to the same url
+	for _, pattern := range []string{"/{alias}", "/{alias}/*"} {
+		router.With(redirectLimit).Get(pattern, redirectHandler)
+		router.With(redirectLimit, passwordFailures).Post(pattern, redirectHandler)
+	}
+
+	// every fixed part of a route is reserved - /metrics would shadow an alias called metrics,
+	// and DELETE /url/batch one called batch. routes added later are picked up automatically
+	_ = chi.Walk(router, func(_ string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		reservedAliases.Add(staticSegments(route)...)
+		return nil
+	})
+
+	return router
+}
+
+// staticSegments - "/url/{alias}/stats" is url and stats
+func staticSegments(route string) []string {
+	var segments []string
+	for _, segment := range strings.Split(route, "/") {
+		if segment == "" || strings.HasPrefix(segment, "{") || segment == "*" {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// utmTemplates - the config only has plain fields, like in its other sections
+func utmTemplates(c config.UTM) utm.Templates {
+	t := utm.Templates{
+		Default: utm.Params{Source: c.Source, Medium: c.Medium, Campaign: c.Campaign, Term: c.Term, Content: c.Content},
+		Owners:  make(map[string]utm.Params, len(c.Owners)),
+	}
+	for owner, o := range c.Owners {
+		t.Owners[owner] = utm.Params{Source: o.Source, Medium: o.Medium, Campaign: o.Campaign, Term: o.Term, Content: o.Content}
+	}
+	return t
+}
+
+// limit is a pass-through when the rate is 0
+func limit(log *slog.Logger, store ratelimit.Store, name string, rate float64, burst int, key ratelimit.KeyFunc) func(http.Handler) http.Handler {
+	if rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return ratelimit.New(log, store, name, ratelimit.Limit{Rate: rate, Burst: max(burst, 1)}, key)
+}
+
+// failureLimit is limit for ratelimit.NewFailures, status is the answer that counts as a failure
+func failureLimit(log *slog.Logger, store ratelimit.Store, name string, rate float64, burst int, key ratelimit.KeyFunc, status int) func(http.Handler) http.Handler {
+	if rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return ratelimit.NewFailures(log, store, name, ratelimit.Limit{Rate: rate, Burst: max(burst, 1)}, key, status)
+}
+
+// byLinkAndIP - a guesser only locks itself out of the one link, the other visitors of it can still unlock it
+func byLinkAndIP(r *http.Request) string {
+	return "alias:" + chi.URLParam(r, "alias") + ":" + ratelimit.ByIP(r)
+}
+
+func isNotProbe(r *http.Request) bool {
+	return r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && r.URL.Path != "/metrics"
+}
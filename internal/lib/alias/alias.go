@@ -0,0 +1,277 @@
+// Package alias generates aliases for links that are saved without one
+package alias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	mrand "math/rand/v2"
+	"strings"
+	"url-shortener/internal/lib/random"
+)
+
+const (
+	// StrategyRandom - random string of the given length
+	StrategyRandom = "random"
+	// StrategySequence - an ever growing id written with the alphabet: short, but anyone can count your links
+	StrategySequence = "sequence"
+	// StrategyObfuscated - same ids scrambled with a salt, Hashids/Sqids style
+	StrategyObfuscated = "obfuscated"
+	// StrategyWords - a few capitalized words, e.g. CalmBraveOtter
+	StrategyWords = "words"
+)
+
+// aliases have to fit the 3..15 chars every handler accepts
+const (
+	minLength = 3
+	maxLength = 15
+	// 62^10 still fits into uint64, ids won't get anywhere near it
+	maxIDLength = 10
+	// fewer characters make aliases long and sequences run out of space
+	minAlphabet = 10
+)
+
+// ErrNoFreeAlias - every generated alias was already taken
+var ErrNoFreeAlias = errors.New("no free alias")
+
+// Generator makes aliases, callers retry with a new one when it's taken
+//
+//go:generate go run github.com/vektra/mockery/v2@latest --name=Generator
+type Generator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Sequence hands out ids that are never repeated, even across restarts
+type Sequence interface {
+	NextAliasID(ctx context.Context) (int64, error)
+}
+
+type Options struct {
+	Strategy string
+	// exact length for random, minimum length for sequence and obfuscated
+	Length int
+	// number of words for the words strategy
+	Words int
+	// scrambles obfuscated ids, changing it changes every alias generated after that
+	Salt string
+	// characters for random, sequence and obfuscated, random.Alphanumeric when empty
+	Alphabet string
+}
+
+// New picks the strategy, seq is only used by sequence and obfuscated
+func New(opts Options, seq Sequence) (Generator, error) {
+	const op = "lib.alias.New"
+
+	alphabet := opts.Alphabet
+	if alphabet == "" {
+		alphabet = random.Alphanumeric
+	}
+	if err := checkAlphabet(alphabet); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch opts.Strategy {
+	case StrategyRandom:
+		if opts.Length < minLength || opts.Length > maxLength {
+			return nil, fmt.Errorf("%s: length must be between %d and %d", op, minLength, maxLength)
+		}
+		return Random{Length: opts.Length, Alphabet: alphabet}, nil
+	case StrategySequence, StrategyObfuscated:
+		if opts.Length < minLength || opts.Length > maxIDLength {
+			return nil, fmt.Errorf("%s: length must be between %d and %d", op, minLength, maxIDLength)
+		}
+		if opts.Strategy == StrategySequence {
+			return &SequenceGenerator{seq: seq, length: opts.Length, alphabet: alphabet}, nil
+		}
+		return NewObfuscated(seq, opts.Length, opts.Salt, alphabet), nil
+	case StrategyWords:
+		if opts.Words < 2 || opts.Words > maxLength/maxWordLength {
+			return nil, fmt.Errorf("%s: words must be between 2 and %d", op, maxLength/maxWordLength)
+		}
+		return Words{Count: opts.Words}, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown strategy %q", op, opts.Strategy)
+	}
+}
+
+// checkAlphabet makes sure aliases stay valid for the handlers and every character is used once,
+// a repeated one would come up twice as often in random aliases and break sequences
+func checkAlphabet(alphabet string) error {
+	if len(alphabet) < minAlphabet {
+		return fmt.Errorf("alphabet needs at least %d characters", minAlphabet)
+	}
+
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return fmt.Errorf("alphabet can only have latin letters and digits, got %q", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("alphabet has %q twice", c)
+		}
+		seen[c] = true
+	}
+
+	return nil
+}
+
+// Random - random aliases, collisions are rare but possible
+type Random struct {
+	Length int
+	// random.Alphanumeric when empty
+	Alphabet string
+}
+
+func (g Random) Generate(context.Context) (string, error) {
+	if g.Alphabet == "" {
+		return random.NewRandomString(g.Length), nil
+	}
+	return random.String(g.Length, g.Alphabet), nil
+}
+
+// SequenceGenerator - "00001", "00002", ... "0000A"
+type SequenceGenerator struct {
+	seq      Sequence
+	length   int
+	alphabet string
+}
+
+func (g *SequenceGenerator) Generate(ctx context.Context) (string, error) {
+	const op = "lib.alias.SequenceGenerator.Generate"
+
+	id, err := g.seq.NextAliasID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return encode(uint64(id), g.alphabet, g.length), nil
+}
+
+// Obfuscated - sequence ids mapped one to one onto scrambled strings,
+// so they stay unique without showing how many links there are
+type Obfuscated struct {
+	seq      Sequence
+	length   int
+	alphabet string
+	// x -> (x*mul + add) mod base^n is a bijection as long as mul shares no factors with the base
+	mul uint64
+	add uint64
+}
+
+// NewObfuscated - alphabet is random.Alphanumeric when empty
+func NewObfuscated(seq Sequence, length int, salt string, alphabet string) *Obfuscated {
+	if alphabet == "" {
+		alphabet = random.Alphanumeric
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	sum := h.Sum64()
+
+	mul := sum | 1
+	for gcd(mul, uint64(len(alphabet))) != 1 {
+		mul += 2
+	}
+
+	// the salt also shuffles the alphabet, otherwise small ids would still share prefixes
+	shuffled := []byte(alphabet)
+	rnd := mrand.New(mrand.NewPCG(sum, ^sum))
+	rnd.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	return &Obfuscated{
+		seq:      seq,
+		length:   length,
+		alphabet: string(shuffled),
+		mul:      mul,
+		add:      bits.RotateLeft64(sum, 32),
+	}
+}
+
+func (g *Obfuscated) Generate(ctx context.Context) (string, error) {
+	const op = "lib.alias.Obfuscated.Generate"
+
+	id, err := g.seq.NextAliasID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// grow past the configured length once the ids don't fit anymore
+	base := len(g.alphabet)
+	n, space := g.length, pow(base, g.length)
+	for uint64(id) >= space && n < maxIDLength {
+		n++
+		space = pow(base, n)
+	}
+
+	return encode(scramble(uint64(id), g.mul, g.add, space), g.alphabet, n), nil
+}
+
+// scramble returns (x*mul + add) mod m without overflowing
+func scramble(x, mul, add, m uint64) uint64 {
+	hi, lo := bits.Mul64(x%m, mul%m)
+	_, prod := bits.Div64(hi, lo, m)
+
+	sum, carry := bits.Add64(prod, add%m, 0)
+	if carry != 0 || sum >= m {
+		sum -= m
+	}
+	return sum
+}
+
+func pow(base, n int) uint64 {
+	p := uint64(1)
+	for i := 0; i < n; i++ {
+		p *= uint64(base)
+	}
+	return p
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// encode writes x in base len(alphabet), padded with the zero digit up to length
+func encode(x uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+
+	var digits []byte
+	for x > 0 {
+		digits = append(digits, alphabet[x%base])
+		x /= base
+	}
+	for len(digits) < length {
+		digits = append(digits, alphabet[0])
+	}
+
+	// least significant digit was written first
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// Words - readable aliases, easy to say over the phone
+type Words struct {
+	Count int
+}
+
+func (g Words) Generate(context.Context) (string, error) {
+	var b strings.Builder
+	for i := 0; i < g.Count; i++ {
+		// adjectives first, the last word is a noun
+		list := adjectives
+		if i == g.Count-1 {
+			list = nouns
+		}
+		w := list[random.Intn(len(list))]
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String(), nil
+}
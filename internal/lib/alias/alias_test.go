@@ -0,0 +1,156 @@
+package alias_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/alias"
+	"url-shortener/internal/lib/random"
+)
+
+// counter is a Sequence without a database
+type counter struct {
+	last int64
+}
+
+func (c *counter) NextAliasID(context.Context) (int64, error) {
+	c.last++
+	return c.last, nil
+}
+
+// what every handler accepts as an alias
+var valid = regexp.MustCompile(`^[0-9A-Za-z]{3,15}$`)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name      string
+		opts      alias.Options
+		expectErr bool
+	}{
+		{name: "Random", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 6}},
+		{name: "Sequence", opts: alias.Options{Strategy: alias.StrategySequence, Length: 4}},
+		{name: "Obfuscated", opts: alias.Options{Strategy: alias.StrategyObfuscated, Length: 6, Salt: "s"}},
+		{name: "Words", opts: alias.Options{Strategy: alias.StrategyWords, Words: 3}},
+		{name: "Unknown strategy", opts: alias.Options{Strategy: "uuid", Length: 6}, expectErr: true},
+		{name: "Random too short", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 2}, expectErr: true},
+		{name: "Random too long", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 16}, expectErr: true},
+		{name: "Sequence too long", opts: alias.Options{Strategy: alias.StrategySequence, Length: 11}, expectErr: true},
+		{name: "Too many words", opts: alias.Options{Strategy: alias.StrategyWords, Words: 4}, expectErr: true},
+		{name: "One word", opts: alias.Options{Strategy: alias.StrategyWords, Words: 1}, expectErr: true},
+		{name: "Custom alphabet", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 6, Alphabet: random.Unambiguous}},
+		{name: "Obfuscated custom alphabet", opts: alias.Options{Strategy: alias.StrategyObfuscated, Length: 3, Alphabet: "0123456789"}},
+		{name: "Alphabet too short", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 6, Alphabet: "abc"}, expectErr: true},
+		{name: "Alphabet not alphanumeric", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 6, Alphabet: "abcdefghij-"}, expectErr: true},
+		{name: "Alphabet repeats", opts: alias.Options{Strategy: alias.StrategyRandom, Length: 6, Alphabet: "abcdefghija"}, expectErr: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			gen, err := alias.New(tc.opts, &counter{})
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for i := 0; i < 100; i++ {
+				a, err := gen.Generate(t.Context())
+				require.NoError(t, err)
+				require.Regexp(t, valid, a)
+			}
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	a, err := alias.Random{Length: 8}.Generate(t.Context())
+	require.NoError(t, err)
+	require.Len(t, a, 8)
+}
+
+func TestSequence(t *testing.T) {
+	gen, err := alias.New(alias.Options{Strategy: alias.StrategySequence, Length: 3}, &counter{last: 59})
+	require.NoError(t, err)
+
+	for _, expected := range []string{"00y", "00z", "010"} {
+		a, err := gen.Generate(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, expected, a)
+	}
+}
+
+func TestSequence_Alphabet(t *testing.T) {
+	gen, err := alias.New(alias.Options{Strategy: alias.StrategySequence, Length: 3, Alphabet: "abcdefghij"}, &counter{last: 9})
+	require.NoError(t, err)
+
+	for _, expected := range []string{"aba", "abb"} {
+		a, err := gen.Generate(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, expected, a)
+	}
+}
+
+func TestObfuscated_Alphabet(t *testing.T) {
+	// ids 1..999 fit into 3 digits, every one of them has to get its own alias
+	gen := alias.NewObfuscated(&counter{}, 3, "salt", "0123456789")
+
+	seen := make(map[string]bool, 999)
+	for i := 0; i < 999; i++ {
+		a, err := gen.Generate(t.Context())
+		require.NoError(t, err)
+		require.Regexp(t, `^[0-9]{3}$`, a)
+		seen[a] = true
+	}
+	require.Len(t, seen, 999)
+}
+
+func TestObfuscated(t *testing.T) {
+	const n = 100_000
+
+	gen := alias.NewObfuscated(&counter{}, 3, "salt", "")
+
+	seen := make(map[string]bool, n)
+	prev := ""
+	increasing := 0
+	for i := 0; i < n; i++ {
+		a, err := gen.Generate(t.Context())
+		require.NoError(t, err)
+		require.Regexp(t, valid, a)
+		require.False(t, seen[a], "duplicate alias %q after %d ids", a, i)
+
+		if a > prev {
+			increasing++
+		}
+		seen[a] = true
+		prev = a
+	}
+	// a plain sequence would always grow
+	require.Less(t, increasing, n*9/10)
+	// 62^3 is 238328, more ids than that get one more char
+	require.Len(t, prev, 3)
+
+	big := alias.NewObfuscated(&counter{last: 62 * 62 * 62}, 3, "salt", "")
+	a, err := big.Generate(t.Context())
+	require.NoError(t, err)
+	require.Len(t, a, 4)
+}
+
+func TestObfuscated_Salt(t *testing.T) {
+	first, err := alias.NewObfuscated(&counter{}, 6, "one", "").Generate(t.Context())
+	require.NoError(t, err)
+
+	again, err := alias.NewObfuscated(&counter{}, 6, "one", "").Generate(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+
+	other, err := alias.NewObfuscated(&counter{}, 6, "two", "").Generate(t.Context())
+	require.NoError(t, err)
+	require.NotEqual(t, first, other)
+}
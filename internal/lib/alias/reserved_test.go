@@ -0,0 +1,82 @@
+package alias_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/alias"
+)
+
+// fixed hands out the aliases it was given, one per call
+type fixed struct {
+	aliases []string
+	calls   int
+}
+
+func (f *fixed) Generate(context.Context) (string, error) {
+	a := f.aliases[f.calls%len(f.aliases)]
+	f.calls++
+	return a, nil
+}
+
+func TestReserved_Check(t *testing.T) {
+	r := alias.NewReserved([]string{"admin", " Login "}, []string{"shit"})
+	r.Add("healthz", "")
+
+	cases := []struct {
+		alias        string
+		err          error
+		generatedErr error
+	}{
+		{alias: "admin", err: alias.ErrReserved, generatedErr: alias.ErrReserved},
+		{alias: "ADMIN", err: alias.ErrReserved, generatedErr: alias.ErrReserved},
+		{alias: "login", err: alias.ErrReserved, generatedErr: alias.ErrReserved},
+		{alias: "healthz", err: alias.ErrReserved, generatedErr: alias.ErrReserved},
+		{alias: "admins"},
+		{alias: "xShiTx", generatedErr: alias.ErrProfane},
+		{alias: "google"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.alias, func(t *testing.T) {
+			t.Parallel()
+
+			require.ErrorIs(t, r.Check(tc.alias), tc.err)
+			require.ErrorIs(t, r.CheckGenerated(tc.alias), tc.generatedErr)
+		})
+	}
+}
+
+func TestReserved_Filter(t *testing.T) {
+	r := alias.NewReserved([]string{"admin"}, alias.DefaultProfanity)
+
+	gen := &fixed{aliases: []string{"Admin", "aSSet", "k7Qp2x"}}
+	got, err := r.Filter(gen).Generate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "k7Qp2x", got)
+	require.Equal(t, 3, gen.calls)
+
+	// nothing usable ever comes out, so it gives up instead of spinning
+	gen = &fixed{aliases: []string{"admin"}}
+	_, err = r.Filter(gen).Generate(context.Background())
+	require.ErrorIs(t, err, alias.ErrNoFreeAlias)
+	require.Equal(t, 10, gen.calls)
+}
+
+func TestLoadWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# house rules\nbanana\n\n  kiwi # fruit\n"), 0o644))
+
+	words, err := alias.LoadWords(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"banana", "kiwi"}, words)
+
+	_, err = alias.LoadWords(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
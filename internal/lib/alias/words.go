@@ -0,0 +1,28 @@
+package alias
+
+// no word is longer than this, so Count words always fit into an alias
+const maxWordLength = 5
+
+var adjectives = []string{
+	"able", "bold", "brave", "brief", "busy", "calm", "clean", "clear",
+	"cool", "crisp", "cute", "dark", "dear", "eager", "early", "easy",
+	"fair", "fancy", "fast", "fine", "fresh", "glad", "gold", "good",
+	"grand", "great", "green", "happy", "handy", "jolly", "keen", "kind",
+	"large", "light", "live", "loud", "lucky", "merry", "mild", "neat",
+	"new", "nice", "noble", "odd", "plain", "proud", "quick", "quiet",
+	"rapid", "rare", "ready", "rich", "royal", "safe", "sharp", "shiny",
+	"silly", "smart", "soft", "solid", "sunny", "swift", "tidy", "warm",
+	"wild", "wise", "witty", "young", "zesty",
+}
+
+var nouns = []string{
+	"ant", "apple", "bear", "bee", "bird", "boat", "bread", "cake",
+	"cat", "cloud", "comet", "crab", "crow", "deer", "dove", "duck",
+	"eagle", "fern", "fish", "fox", "frog", "goat", "goose", "hawk",
+	"hill", "horse", "koala", "lake", "lamp", "leaf", "lemon", "lion",
+	"llama", "maple", "moon", "moose", "mouse", "otter", "owl", "panda",
+	"peach", "pear", "pine", "plum", "pony", "quail", "raven", "river",
+	"robin", "rock", "rose", "seal", "shark", "sheep", "snail", "star",
+	"stone", "swan", "tiger", "toad", "tree", "tulip", "whale", "wolf",
+	"yak", "zebra",
+}
@@ -50,6 +50,9 @@ func ValidationError(errs validator.ValidationErrors) Response {
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is a required field", err.Field()))
 		case "url":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not a valid URL", err.Field()))
+		case "excluded_with":
+			// the param is a space separated list of fields
+			errMsgs = append(errMsgs, fmt.Sprintf("field %s can't be used together with %s", err.Field(), strings.ReplaceAll(err.Param(), " ", " or ")))
 		default:
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not valid", err.Field()))
 		}
@@ -60,3 +63,8 @@ func ValidationError(errs validator.ValidationErrors) Response {
 		Error:  strings.Join(errMsgs, ", "),
 	}
 }
+
+// NotAllowed is for values that are well-formed but refused by a policy, err says why
+func NotAllowed(field string, err error) Response {
+	return Error(fmt.Sprintf("field %s is not allowed: %v", field, err))
+}
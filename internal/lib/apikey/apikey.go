@@ -0,0 +1,28 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// prefix makes leaked keys easy to spot in logs and by secret scanners
+const prefix = "us_"
+
+// Generate returns a new random key, it's shown to the client once and never stored
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash is what gets stored and looked up.
+// keys are 256 random bits, so a plain sha256 is enough - no need for bcrypt here
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,11 @@
 package sl
 
-import "log/slog"
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 func Err(err error) slog.Attr {
 	return slog.Attr{
@@ -8,3 +13,18 @@ func Err(err error) slog.Attr {
 		Value: slog.StringValue(err.Error()),
 	}
 }
+
+// Trace - trace_id and span_id of the span in ctx, so a log line can be found from the trace and back.
+// without a span it's an empty attr and slog leaves it out
+func Trace(ctx context.Context) slog.Attr {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return slog.Attr{}
+	}
+
+	// a group without a key is inlined
+	return slog.Group("",
+		slog.String("trace_id", sc.TraceID().String()),
+		slog.String("span_id", sc.SpanID().String()),
+	)
+}
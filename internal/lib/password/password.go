@@ -0,0 +1,24 @@
+package password
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxBytes - bcrypt ignores everything after 72 bytes, so longer passwords are refused instead
+const MaxBytes = 72
+
+// Hash is what gets stored. unlike api keys people pick these, so it's bcrypt and not a plain sha256
+func Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Check reports whether password is the one hash was made from, in constant time
+func Check(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -1,22 +1,52 @@
 package random
 
-// TODO: use crypto random
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
 )
 
-// NewRandomString generates random string with given size
-func NewRandomString(size int) string {
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+const (
+	// Alphanumeric - every digit and latin letter, the default for aliases
+	Alphanumeric = "0123456789" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"abcdefghijklmnopqrstuvwxyz"
+	// Unambiguous - Alphanumeric without the characters people mix up when typing: 0/O, 1/I/l
+	Unambiguous = "23456789" +
+		"ABCDEFGHJKLMNPQRSTUVWXYZ" +
+		"abcdefghijkmnopqrstuvwxyz"
+)
 
-	chars := []rune("ABCDEFGHIJKLMNOPRSTUVWXYZ" +
-		"abcdefghijklmnopqrstuvwxyz" + "0123456789")
+// NewRandomString generates random string with given size from Alphanumeric
+func NewRandomString(size int) string {
+	return String(size, Alphanumeric)
+}
 
-	b := make([]rune, size)
+// String generates a string of size characters, each picked uniformly from alphabet.
+// alphabet is treated as bytes, so keep it ascii and not empty
+func String(size int, alphabet string) string {
+	b := make([]byte, size)
 	for i := range b {
-		b[i] = chars[rnd.Intn(len(chars))]
+		b[i] = alphabet[Intn(len(alphabet))]
 	}
 
 	return string(b)
 }
+
+// Intn returns a uniform random number in [0, n) from crypto/rand, n has to be positive
+func Intn(n int) int {
+	// plain v % n would favour the small numbers whenever n doesn't divide 2^64,
+	// so throw away the values from the incomplete last round
+	limit := math.MaxUint64 - math.MaxUint64%uint64(n)
+
+	var buf [8]byte
+	for {
+		// never fails, crypto/rand crashes the program if the os has no randomness to give
+		_, _ = rand.Read(buf[:])
+
+		v := binary.LittleEndian.Uint64(buf[:])
+		if v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package random_test
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/random"
+)
+
+func TestString(t *testing.T) {
+	for _, alphabet := range []string{random.Alphanumeric, random.Unambiguous, "ab"} {
+		s := random.String(64, alphabet)
+		require.Len(t, s, 64)
+		for _, c := range s {
+			require.True(t, strings.ContainsRune(alphabet, c), "%q is not in %q", c, alphabet)
+		}
+	}
+
+	require.Len(t, random.NewRandomString(6), 6)
+	require.Empty(t, random.NewRandomString(0))
+}
+
+// every character has to come up equally often - chi-squared goodness of fit.
+// Unambiguous has 57 characters, so modulo bias of a naive implementation would show up there
+func TestString_Distribution(t *testing.T) {
+	for _, alphabet := range []string{random.Alphanumeric, random.Unambiguous} {
+		const perChar = 2000
+
+		counts := make(map[rune]int, len(alphabet))
+		for _, c := range random.String(perChar*len(alphabet), alphabet) {
+			counts[c]++
+		}
+		// "Q" was missing once, nothing else catches that
+		require.Len(t, counts, len(alphabet))
+
+		chi2 := 0.0
+		for _, c := range alphabet {
+			d := float64(counts[c] - perChar)
+			chi2 += d * d / perChar
+		}
+
+		require.Less(t, chi2, chi2Critical(len(alphabet)-1), "alphabet %q", alphabet)
+	}
+}
+
+// 100k aliases of 6 characters should collide about 0.09 times on average
+func TestNewRandomString_Collisions(t *testing.T) {
+	const n = 100_000
+
+	seen := make(map[string]bool, n)
+	collisions := 0
+	for i := 0; i < n; i++ {
+		s := random.NewRandomString(6)
+		if seen[s] {
+			collisions++
+		}
+		seen[s] = true
+	}
+
+	// poisson(0.09): more than 3 happens less than once in a million runs
+	require.LessOrEqual(t, collisions, 3)
+}
+
+// the old math/rand version seeded with the current time handed out
+// the same alias to requests that came in the same nanosecond
+func TestNewRandomString_Concurrent(t *testing.T) {
+	const workers = 1000
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[string]bool, workers)
+	)
+	start := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			s := random.NewRandomString(12)
+
+			mu.Lock()
+			defer mu.Unlock()
+			seen[s] = true
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	require.Len(t, seen, workers)
+}
+
+func TestIntn(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		v := random.Intn(7)
+		require.GreaterOrEqual(t, v, 0)
+		require.Less(t, v, 7)
+	}
+	require.Zero(t, random.Intn(1))
+}
+
+// chi2Critical is the chi-squared value with df degrees of freedom that a fair generator
+// goes over about once in a million runs (Wilson-Hilferty approximation)
+func chi2Critical(df int) float64 {
+	const z = 4.75 // one in a million, one sided
+
+	k := float64(df)
+	x := 1 - 2/(9*k) + z*math.Sqrt(2/(9*k))
+	return k * x * x * x
+}
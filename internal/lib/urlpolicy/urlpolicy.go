@@ -0,0 +1,251 @@
+// Package urlpolicy decides which destinations can be shortened at all,
+// so nobody turns the service into a launcher for javascript: links or a proxy into our own network
+package urlpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalid        = errors.New("url can't be parsed")
+	ErrScheme         = errors.New("scheme is not allowed")
+	ErrHostDenied     = errors.New("host is blocked")
+	ErrHostNotAllowed = errors.New("host is not in the allow list")
+	ErrPrivateHost    = errors.New("private and loopback addresses are not allowed")
+	ErrLoop           = errors.New("links to this service would redirect to themselves")
+)
+
+// DefaultSchemes are the only ones browsers follow without surprises
+var DefaultSchemes = []string{"http", "https"}
+
+type Options struct {
+	// http and https when empty
+	Schemes []string
+	// only these hosts can be shortened, anything goes when both this and AllowFile are empty.
+	// "example.com" is just that host, "*.example.com" is every subdomain but not example.com itself
+	AllowHosts []string
+	// same patterns as AllowHosts, checked first
+	DenyHosts []string
+	// files with one pattern per line, # starts a comment. added to the lists above
+	AllowFile string
+	DenyFile  string
+	// lets through localhost, 10.0.0.0/8 and friends - for running inside a private network
+	AllowPrivate bool
+	// hosts the service itself is reachable on, the host of the request is always added on top
+	SelfHosts []string
+}
+
+// Policy is read-only after New, safe to share between requests
+type Policy struct {
+	schemes      map[string]bool
+	allow        []string
+	deny         []string
+	allowPrivate bool
+	self         []string
+}
+
+func New(opts Options) (*Policy, error) {
+	const op = "lib.urlpolicy.New"
+
+	schemes := opts.Schemes
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+
+	p := &Policy{
+		schemes:      make(map[string]bool, len(schemes)),
+		allowPrivate: opts.AllowPrivate,
+	}
+	for _, s := range schemes {
+		p.schemes[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+
+	allow, err := withFile(opts.AllowHosts, opts.AllowFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	deny, err := withFile(opts.DenyHosts, opts.DenyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if p.allow, err = normalizePatterns(allow); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if p.deny, err = normalizePatterns(deny); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, h := range opts.SelfHosts {
+		if h = normalizeHost(h); h != "" {
+			p.self = append(p.self, h)
+		}
+	}
+
+	return p, nil
+}
+
+// Check returns nil when rawURL can be shortened, otherwise one of the errors above with the details.
+// requestHost is the Host the request came in on, links back to it would loop
+func (p *Policy) Check(rawURL string, requestHost string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !p.schemes[scheme] {
+		return fmt.Errorf("%w: %q", ErrScheme, scheme)
+	}
+
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: no host", ErrInvalid)
+	}
+
+	if host == normalizeHost(requestHost) || matchesAny(host, p.self) {
+		return fmt.Errorf("%w: %s", ErrLoop, host)
+	}
+
+	if matchesAny(host, p.deny) {
+		return fmt.Errorf("%w: %s", ErrHostDenied, host)
+	}
+	if len(p.allow) > 0 && !matchesAny(host, p.allow) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	if !p.allowPrivate && isPrivate(host) {
+		return fmt.Errorf("%w: %s", ErrPrivateHost, host)
+	}
+
+	return nil
+}
+
+// LoadHosts reads a list of host patterns, blank lines and # comments are skipped
+func LoadHosts(path string) ([]string, error) {
+	const op = "lib.urlpolicy.LoadHosts"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return hosts, nil
+}
+
+func withFile(hosts []string, path string) ([]string, error) {
+	if path == "" {
+		return hosts, nil
+	}
+
+	fromFile, err := LoadHosts(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string(nil), hosts...), fromFile...), nil
+}
+
+func normalizePatterns(patterns []string) ([]string, error) {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = normalizeHost(pattern)
+		if pattern == "" {
+			continue
+		}
+		// a star anywhere else would need real glob matching, nobody needs that for hosts
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return nil, fmt.Errorf("invalid host pattern %q, only a leading *. is supported", pattern)
+		}
+		normalized = append(normalized, pattern)
+	}
+	return normalized, nil
+}
+
+// normalizeHost drops the port, the trailing dot and the case, "Example.COM.:443" is "example.com"
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func matchesAny(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivate is about what the host says by itself, names aren't resolved -
+// a public name pointing at 127.0.0.1 gets through, the browser of the visitor resolves it anyway
+func isPrivate(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		// browsers read 2130706433 and 0x7f.1 as 127.0.0.1, no real domain ends with a number
+		labels := strings.Split(host, ".")
+		return isNumeric(labels[len(labels)-1])
+	}
+
+	addr = addr.Unmap()
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+// 100.64.0.0/10 is carrier-grade NAT, cloud providers use it for internal endpoints
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isNumeric - decimal, or hex with 0x. "cafe" is a real tld, "0xcafe" isn't
+func isNumeric(label string) bool {
+	digits := "0123456789"
+	if hex, ok := strings.CutPrefix(label, "0x"); ok {
+		label, digits = hex, "0123456789abcdef"
+	}
+	if label == "" {
+		return false
+	}
+	for _, r := range label {
+		if !strings.ContainsRune(digits, r) {
+			return false
+		}
+	}
+	return true
+}
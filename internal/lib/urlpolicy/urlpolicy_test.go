@@ -0,0 +1,138 @@
+package urlpolicy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/urlpolicy"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	cases := []struct {
+		name string
+		opts urlpolicy.Options
+		url  string
+		// the Host of the request
+		host    string
+		wantErr error
+	}{
+		{name: "Plain https", url: "https://google.com/search?q=go"},
+		{name: "Plain http", url: "http://example.com"},
+		{name: "Javascript", url: "javascript:alert(1)", wantErr: urlpolicy.ErrScheme},
+		{name: "Data", url: "data:text/html,<script>alert(1)</script>", wantErr: urlpolicy.ErrScheme},
+		{name: "Ftp", url: "ftp://example.com/file", wantErr: urlpolicy.ErrScheme},
+		{
+			name: "Ftp allowed",
+			opts: urlpolicy.Options{Schemes: []string{"https", "FTP"}},
+			url:  "ftp://example.com/file",
+		},
+		{name: "No host", url: "https:///path", wantErr: urlpolicy.ErrInvalid},
+		{name: "Localhost", url: "http://localhost:8080/admin", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Localhost subdomain", url: "http://api.localhost", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Loopback", url: "http://127.0.0.1/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Loopback v6", url: "http://[::1]:80/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Mapped v6", url: "http://[::ffff:10.0.0.1]/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Private", url: "http://192.168.1.1/router", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Link local", url: "http://169.254.169.254/latest/meta-data", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Unspecified", url: "http://0.0.0.0/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Shared address space", url: "http://100.100.100.200/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Decimal ip", url: "http://2130706433/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Hex ip", url: "http://0x7f.1/", wantErr: urlpolicy.ErrPrivateHost},
+		{name: "Hex looking tld", url: "https://coffee.cafe"},
+		{name: "Public ip", url: "http://8.8.8.8/"},
+		{
+			name: "Private allowed",
+			opts: urlpolicy.Options{AllowPrivate: true},
+			url:  "http://10.0.0.5/wiki",
+		},
+		{name: "Loop", url: "https://sho.rt/abc", host: "sho.rt", wantErr: urlpolicy.ErrLoop},
+		{name: "Loop case and port", url: "https://SHO.RT./abc", host: "sho.rt:443", wantErr: urlpolicy.ErrLoop},
+		{
+			name:    "Loop self host",
+			opts:    urlpolicy.Options{SelfHosts: []string{"*.sho.rt"}},
+			url:     "https://eu.sho.rt/abc",
+			host:    "internal:8082",
+			wantErr: urlpolicy.ErrLoop,
+		},
+		{
+			name:    "Denied",
+			opts:    urlpolicy.Options{DenyHosts: []string{"evil.com"}},
+			url:     "https://evil.com/login",
+			wantErr: urlpolicy.ErrHostDenied,
+		},
+		{
+			name: "Denied exact only",
+			opts: urlpolicy.Options{DenyHosts: []string{"evil.com"}},
+			url:  "https://www.evil.com/login",
+		},
+		{
+			name:    "Denied wildcard",
+			opts:    urlpolicy.Options{DenyHosts: []string{"*.evil.com"}},
+			url:     "https://a.b.EVIL.com/login",
+			wantErr: urlpolicy.ErrHostDenied,
+		},
+		{
+			name: "Denied wildcard not apex",
+			opts: urlpolicy.Options{DenyHosts: []string{"*.evil.com"}},
+			url:  "https://evil.com/login",
+		},
+		{
+			name: "Allowed",
+			opts: urlpolicy.Options{AllowHosts: []string{"example.com", "*.example.com"}},
+			url:  "https://docs.example.com/",
+		},
+		{
+			name:    "Not allowed",
+			opts:    urlpolicy.Options{AllowHosts: []string{"example.com", "*.example.com"}},
+			url:     "https://notexample.com/",
+			wantErr: urlpolicy.ErrHostNotAllowed,
+		},
+		{
+			name:    "Deny beats allow",
+			opts:    urlpolicy.Options{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"evil.example.com"}},
+			url:     "https://evil.example.com/",
+			wantErr: urlpolicy.ErrHostDenied,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := urlpolicy.New(tc.opts)
+			require.NoError(t, err)
+
+			err = p.Check(tc.url, tc.host)
+			if tc.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestNew_Files(t *testing.T) {
+	dir := t.TempDir()
+	deny := filepath.Join(dir, "deny.txt")
+	require.NoError(t, os.WriteFile(deny, []byte("# phishing\nevil.com\n\n*.tracker.net # ads\n"), 0o644))
+
+	p, err := urlpolicy.New(urlpolicy.Options{DenyHosts: []string{"bad.org"}, DenyFile: deny})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, p.Check("https://evil.com", ""), urlpolicy.ErrHostDenied)
+	require.ErrorIs(t, p.Check("https://px.tracker.net", ""), urlpolicy.ErrHostDenied)
+	require.ErrorIs(t, p.Check("https://bad.org", ""), urlpolicy.ErrHostDenied)
+	require.NoError(t, p.Check("https://good.org", ""))
+
+	_, err = urlpolicy.New(urlpolicy.Options{AllowFile: filepath.Join(dir, "missing.txt")})
+	require.Error(t, err)
+
+	_, err = urlpolicy.New(urlpolicy.Options{DenyHosts: []string{"ev*l.com"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+// Package utm tags destinations with the utm_* params analytics tools read campaigns from
+package utm
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Params are the five utm_* params, empty ones are left out
+type Params struct {
+	Source   string `json:"source,omitempty" validate:"omitempty,max=200"`
+	Medium   string `json:"medium,omitempty" validate:"omitempty,max=200"`
+	Campaign string `json:"campaign,omitempty" validate:"omitempty,max=200"`
+	Term     string `json:"term,omitempty" validate:"omitempty,max=200"`
+	Content  string `json:"content,omitempty" validate:"omitempty,max=200"`
+}
+
+// pairs is every param with its query key, always in this order
+func (p Params) pairs() [][2]string {
+	return [][2]string{
+		{"utm_source", p.Source},
+		{"utm_medium", p.Medium},
+		{"utm_campaign", p.Campaign},
+		{"utm_term", p.Term},
+		{"utm_content", p.Content},
+	}
+}
+
+// Or fills the empty fields of p from def
+func (p Params) Or(def Params) Params {
+	pick := func(v, d string) string {
+		if v != "" {
+			return v
+		}
+		return d
+	}
+	return Params{
+		Source:   pick(p.Source, def.Source),
+		Medium:   pick(p.Medium, def.Medium),
+		Campaign: pick(p.Campaign, def.Campaign),
+		Term:     pick(p.Term, def.Term),
+		Content:  pick(p.Content, def.Content),
+	}
+}
+
+// Encode is the query string of the set params, "" when there are none
+func (p Params) Encode() string {
+	var out []string
+	for _, kv := range p.pairs() {
+		if kv[1] != "" {
+			out = append(out, kv[0]+"="+url.QueryEscape(kv[1]))
+		}
+	}
+	return strings.Join(out, "&")
+}
+
+// Decode reads what Encode wrote, other keys are ignored
+func Decode(query string) (Params, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return Params{}, err
+	}
+	return Params{
+		Source:   q.Get("utm_source"),
+		Medium:   q.Get("utm_medium"),
+		Campaign: q.Get("utm_campaign"),
+		Term:     q.Get("utm_term"),
+		Content:  q.Get("utm_content"),
+	}, nil
+}
+
+// Apply adds p to the query of rawURL. params the url already has are left as they are,
+// even when they are empty - whoever built the url meant it
+func Apply(rawURL string, p Params) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	// a broken query still has keys, ParseQuery returns what it could read
+	own, _ := url.ParseQuery(u.RawQuery)
+
+	var added []string
+	if u.RawQuery != "" {
+		added = append(added, u.RawQuery)
+	}
+	for _, kv := range p.pairs() {
+		if _, ok := own[kv[0]]; ok || kv[1] == "" {
+			continue
+		}
+		added = append(added, kv[0]+"="+url.QueryEscape(kv[1]))
+	}
+
+	u.RawQuery = strings.Join(added, "&")
+	return u.String(), nil
+}
+
+// Templates are the defaults for links saved with utm params - Owners on top of Default,
+// and the fields of the request on top of both
+type Templates struct {
+	Default Params
+	Owners  map[string]Params
+}
+
+// For is the template of owner, Default for owners without one
+func (t Templates) For(owner string) Params {
+	return t.Owners[owner].Or(t.Default)
+}
@@ -0,0 +1,94 @@
+package utm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/utm"
+)
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		params   utm.Params
+		expected string
+	}{
+		{
+			name:     "No query",
+			url:      "https://example.com/sale",
+			params:   utm.Params{Source: "newsletter", Medium: "email"},
+			expected: "https://example.com/sale?utm_source=newsletter&utm_medium=email",
+		},
+		{
+			name:     "Existing params are kept",
+			url:      "https://example.com/sale?ref=abc&utm_source=partner",
+			params:   utm.Params{Source: "newsletter", Campaign: "spring"},
+			expected: "https://example.com/sale?ref=abc&utm_source=partner&utm_campaign=spring",
+		},
+		{
+			name:     "Empty existing param wins too",
+			url:      "https://example.com/?utm_medium=",
+			params:   utm.Params{Medium: "email"},
+			expected: "https://example.com/?utm_medium=",
+		},
+		{
+			name:     "Values are encoded",
+			url:      "https://example.com/",
+			params:   utm.Params{Campaign: "spring sale & more", Content: "ünï"},
+			expected: "https://example.com/?utm_campaign=spring+sale+%26+more&utm_content=%C3%BCn%C3%AF",
+		},
+		{
+			name:     "Fragment stays last",
+			url:      "https://example.com/page#top",
+			params:   utm.Params{Term: "shoes"},
+			expected: "https://example.com/page?utm_term=shoes#top",
+		},
+		{
+			name:     "Nothing to add",
+			url:      "https://example.com/a%2Fb?x=1",
+			expected: "https://example.com/a%2Fb?x=1",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := utm.Apply(tc.url, tc.params)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestTemplates_For(t *testing.T) {
+	templates := utm.Templates{
+		Default: utm.Params{Source: "shortener", Medium: "link"},
+		Owners: map[string]utm.Params{
+			"marketing": {Medium: "email", Campaign: "always-on"},
+		},
+	}
+
+	require.Equal(t, utm.Params{Source: "shortener", Medium: "email", Campaign: "always-on"}, templates.For("marketing"))
+	require.Equal(t, templates.Default, templates.For("someone"))
+
+	// the request goes on top of the template
+	p := utm.Params{Campaign: "spring"}.Or(templates.For("marketing"))
+	require.Equal(t, utm.Params{Source: "shortener", Medium: "email", Campaign: "spring"}, p)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	p := utm.Params{Source: "news letter", Campaign: "a&b=c"}
+
+	require.Equal(t, "utm_source=news+letter&utm_campaign=a%26b%3Dc", p.Encode())
+
+	got, err := utm.Decode(p.Encode())
+	require.NoError(t, err)
+	require.Equal(t, p, got)
+
+	require.Empty(t, utm.Params{}.Encode())
+}
@@ -0,0 +1,159 @@
+// Package metrics collects what GET /metrics shows to Prometheus
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"url-shortener/internal/storage/cache"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "url_shortener"
+
+// aliasBuckets - aliases are hashed into this many buckets, a label per alias
+// would grow with every link ever created
+const aliasBuckets = 16
+
+// redirectRoutes are the patterns of the redirect in the router, /{alias}/* is for links that forward the path
+var redirectRoutes = []string{"/{alias}", "/{alias}/*"}
+
+// Metrics has its own registry instead of the global one, so tests can make as many as they like
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	redirects       *prometheus.CounterVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "GET /{alias} requests by alias hash bucket and status.",
+		}, []string{"bucket", "status"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Storage call latency by method.",
+			// queries are mostly well under a millisecond, DefBuckets starts at 5ms
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"method"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_errors_total",
+			Help:      "Failed storage calls by method, not found and conflicts are not failures.",
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.redirects,
+		m.storageDuration,
+		m.storageErrors,
+	)
+
+	return m
+}
+
+// Handler - GET /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware counts requests by the chi route pattern, not the path - /url/{alias}/stats
+// is one series however many links there are
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		// the pattern is only known once the router has matched the request
+		route := "unmatched"
+		rctx := chi.RouteContext(r.Context())
+		if rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		// nothing written means net/http sends 200
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+
+		m.requests.WithLabelValues(route, r.Method, code).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+
+		if slices.Contains(redirectRoutes, route) {
+			m.redirects.WithLabelValues(aliasBucket(rctx.URLParam("alias")), code).Inc()
+		}
+	})
+}
+
+// aliasBucket - the same alias always lands in the same bucket, so a hot link shows up as a hot bucket
+func aliasBucket(alias string) string {
+	h := fnv.New32a()
+	h.Write([]byte(alias))
+	return fmt.Sprintf("%x", h.Sum32()%aliasBuckets)
+}
+
+// CacheStatsGetter is the redirect cache
+type CacheStatsGetter interface {
+	Stats() cache.Stats
+}
+
+// RegisterCache exports the counters of the redirect cache
+func (m *Metrics) RegisterCache(c CacheStatsGetter) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Redirects answered from the cache, missing aliases included.",
+		}, func() float64 { return float64(c.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Redirects the cache had to ask the storage for.",
+		}, func() float64 { return float64(c.Stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Links pushed out of the cache to make room.",
+		}, func() float64 { return float64(c.Stats().Evictions) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_entries",
+			Help:      "Links in the cache right now.",
+		}, func() float64 { return float64(c.Stats().Size) }),
+	)
+}
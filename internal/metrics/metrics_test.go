@@ -0,0 +1,186 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/metrics"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/storage/memory"
+)
+
+// scrape returns what Prometheus would see
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	body, err := io.ReadAll(rr.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New()
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Route("/url", func(r chi.Router) {
+		r.Get("/{alias}/stats", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	router.Get("/{alias}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com", http.StatusFound)
+	})
+
+	for _, path := range []string{"/url/one/stats", "/url/two/stats", "/one", "/two", "/a/b"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrape(t, m)
+
+	// one series per pattern, not per path
+	require.Contains(t, out, `url_shortener_http_requests_total{method="GET",route="/url/{alias}/stats",status="200"} 2`)
+	require.Contains(t, out, `url_shortener_http_requests_total{method="GET",route="/{alias}",status="302"} 2`)
+	require.Contains(t, out, `url_shortener_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	require.Contains(t, out, `url_shortener_http_request_duration_seconds_count{method="GET",route="/{alias}",status="302"} 2`)
+
+	// only redirects get a bucket
+	redirects := 0
+	for _, line := range redirectLines(out) {
+		require.Contains(t, line, `status="302"}`)
+		redirects++
+	}
+	require.Positive(t, redirects)
+}
+
+func redirectLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "url_shortener_redirects_total{") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestMiddleware_SameAliasSameBucket(t *testing.T) {
+	m := metrics.New()
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/{alias}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hot", nil))
+	}
+
+	lines := redirectLines(scrape(t, m))
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasSuffix(lines[0], `status="404"} 3`), lines[0])
+}
+
+func TestMiddleware_ForwardedPath(t *testing.T) {
+	m := metrics.New()
+
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com", http.StatusFound)
+	}
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/{alias}", redirect)
+	router.Get("/{alias}/*", redirect)
+
+	for _, path := range []string{"/hot", "/hot/docs/intro", "/hot/"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// both routes count for the same alias
+	lines := redirectLines(scrape(t, m))
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasSuffix(lines[0], `status="302"} 3`), lines[0])
+}
+
+// failing is a storage where every call fails
+type failing struct {
+	*memory.Storage
+}
+
+func (failing) GetURL(context.Context, string) (storage.URL, error) {
+	return storage.URL{}, storage.ErrDatabaseError
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	m := metrics.New()
+	s := m.InstrumentStorage(memory.New())
+
+	_, err := s.SaveURL(t.Context(), storage.URL{Alias: "google", URL: "https://google.com"})
+	require.NoError(t, err)
+
+	// taken and missing are answers, not failures
+	_, err = s.SaveURL(t.Context(), storage.URL{Alias: "google", URL: "https://google.com"})
+	require.ErrorIs(t, err, storage.ErrUrlExists)
+	_, err = s.GetURL(t.Context(), "missing")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	broken := m.InstrumentStorage(failing{memory.New()})
+	_, err = broken.GetURL(t.Context(), "google")
+	require.ErrorIs(t, err, storage.ErrDatabaseError)
+
+	out := scrape(t, m)
+	require.Contains(t, out, `url_shortener_storage_operation_duration_seconds_count{method="SaveURL"} 2`)
+	require.Contains(t, out, `url_shortener_storage_operation_duration_seconds_count{method="GetURL"} 2`)
+	require.Contains(t, out, `url_shortener_storage_errors_total{method="GetURL"} 1`)
+	require.NotContains(t, out, `url_shortener_storage_errors_total{method="SaveURL"}`)
+}
+
+type pool struct{}
+
+func (pool) DBStats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 5, WaitDuration: 2 * time.Second}
+}
+
+func TestRegisterDBStats(t *testing.T) {
+	m := metrics.New()
+	m.RegisterDBStats(pool{})
+
+	out := scrape(t, m)
+	require.Contains(t, out, "url_shortener_db_max_open_connections 10")
+	require.Contains(t, out, "url_shortener_db_open_connections 3")
+	require.Contains(t, out, "url_shortener_db_in_use_connections 1")
+	require.Contains(t, out, "url_shortener_db_idle_connections 2")
+	require.Contains(t, out, "url_shortener_db_wait_count_total 5")
+	require.Contains(t, out, "url_shortener_db_wait_duration_seconds_total 2")
+}
+
+func TestRegisterCache(t *testing.T) {
+	m := metrics.New()
+
+	c, err := cache.New(memory.New(), cache.Options{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+	require.NoError(t, err)
+	m.RegisterCache(c)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetURL(t.Context(), "missing")
+		require.True(t, errors.Is(err, storage.ErrURLNotFound))
+	}
+
+	out := scrape(t, m)
+	require.Contains(t, out, "url_shortener_cache_hits_total 2")
+	require.Contains(t, out, "url_shortener_cache_misses_total 1")
+	require.Contains(t, out, "url_shortener_cache_entries 1")
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"context"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// InstrumentStorage times every call to the storage, put it under the cache
+// so hits don't count as queries
+func (m *Metrics) InstrumentStorage(s storage.Storage) storage.Storage {
+	return &instrumentedStorage{next: s, m: m}
+}
+
+type instrumentedStorage struct {
+	next storage.Storage
+	m    *Metrics
+}
+
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	s.m.storageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if storage.IsFailure(err) {
+		s.m.storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	start := time.Now()
+	version, dirty, err := s.next.SchemaVersion(ctx)
+	s.observe("SchemaVersion", start, err)
+	return version, dirty, err
+}
+
+// Close isn't timed, it happens once on shutdown
+func (s *instrumentedStorage) Close() error {
+	return s.next.Close()
+}
+
+func (s *instrumentedStorage) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
+	start := time.Now()
+	id, err := s.next.SaveURL(ctx, u)
+	s.observe("SaveURL", start, err)
+	return id, err
+}
+
+func (s *instrumentedStorage) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	start := time.Now()
+	ids, err := s.next.SaveURLs(ctx, urls)
+	s.observe("SaveURLs", start, err)
+	return ids, err
+}
+
+func (s *instrumentedStorage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	start := time.Now()
+	u, err := s.next.GetURL(ctx, alias)
+	s.observe("GetURL", start, err)
+	return u, err
+}
+
+func (s *instrumentedStorage) TakeClick(ctx context.Context, alias string) (storage.URL, error) {
+	start := time.Now()
+	u, err := s.next.TakeClick(ctx, alias)
+	s.observe("TakeClick", start, err)
+	return u, err
+}
+
+func (s *instrumentedStorage) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	start := time.Now()
+	u, err := s.next.UpdateURL(ctx, alias, owner, newURL, version)
+	s.observe("UpdateURL", start, err)
+	return u, err
+}
+
+func (s *instrumentedStorage) DeleteURL(ctx context.Context, alias string, owner string) error {
+	start := time.Now()
+	err := s.next.DeleteURL(ctx, alias, owner)
+	s.observe("DeleteURL", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	start := time.Now()
+	err := s.next.DeleteURLs(ctx, aliases, owner)
+	s.observe("DeleteURLs", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	start := time.Now()
+	n, err := s.next.DeleteExpiredURLs(ctx, now)
+	s.observe("DeleteExpiredURLs", start, err)
+	return n, err
+}
+
+func (s *instrumentedStorage) ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	start := time.Now()
+	n, err := s.next.ArchiveExpiredURLs(ctx, now)
+	s.observe("ArchiveExpiredURLs", start, err)
+	return n, err
+}
+
+func (s *instrumentedStorage) ListURLs(ctx context.Context, filter storage.ListFilter) ([]storage.URL, error) {
+	start := time.Now()
+	urls, err := s.next.ListURLs(ctx, filter)
+	s.observe("ListURLs", start, err)
+	return urls, err
+}
+
+func (s *instrumentedStorage) NextAliasID(ctx context.Context) (int64, error) {
+	start := time.Now()
+	id, err := s.next.NextAliasID(ctx)
+	s.observe("NextAliasID", start, err)
+	return id, err
+}
+
+func (s *instrumentedStorage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	start := time.Now()
+	err := s.next.SaveClicks(ctx, clicks)
+	s.observe("SaveClicks", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	start := time.Now()
+	stats, err := s.next.GetURLStats(ctx, alias, owner, since, top)
+	s.observe("GetURLStats", start, err)
+	return stats, err
+}
+
+func (s *instrumentedStorage) SaveAPIKey(ctx context.Context, k storage.APIKey) (int64, error) {
+	start := time.Now()
+	id, err := s.next.SaveAPIKey(ctx, k)
+	s.observe("SaveAPIKey", start, err)
+	return id, err
+}
+
+func (s *instrumentedStorage) GetAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	start := time.Now()
+	k, err := s.next.GetAPIKey(ctx, hash)
+	s.observe("GetAPIKey", start, err)
+	return k, err
+}
+
+func (s *instrumentedStorage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	start := time.Now()
+	keys, err := s.next.ListAPIKeys(ctx)
+	s.observe("ListAPIKeys", start, err)
+	return keys, err
+}
+
+func (s *instrumentedStorage) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	start := time.Now()
+	err := s.next.RevokeAPIKey(ctx, id, at)
+	s.observe("RevokeAPIKey", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) TouchAPIKey(ctx context.Context, id int64, at time.Time) error {
+	start := time.Now()
+	err := s.next.TouchAPIKey(ctx, id, at)
+	s.observe("TouchAPIKey", start, err)
+	return err
+}
@@ -0,0 +1,79 @@
+package reaper
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"url-shortener/internal/lib/logger/sl"
+)
+
+// ExpiredURLRemover is implemented by every storage backend
+type ExpiredURLRemover interface {
+	DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error)
+	ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Reaper periodically cleans expired links out of the storage.
+// redirect already answers 410 for them, this just keeps the table small
+type Reaper struct {
+	log      *slog.Logger
+	remover  ExpiredURLRemover
+	interval time.Duration
+	archive  bool
+}
+
+// New - archive moves expired links to the archive table instead of just deleting them
+func New(log *slog.Logger, remover ExpiredURLRemover, interval time.Duration, archive bool) *Reaper {
+	return &Reaper{
+		log:      log.With(slog.String("component", "reaper")),
+		remover:  remover,
+		interval: interval,
+		archive:  archive,
+	}
+}
+
+// Run blocks and reaps every interval until ctx is done
+func (r *Reaper) Run(ctx context.Context) {
+	r.log.Info("reaper started",
+		slog.String("interval", r.interval.String()),
+		slog.Bool("archive", r.archive),
+	)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.log.Info("reaper stopped")
+			return
+		case <-ticker.C:
+			r.Reap(ctx, time.Now())
+		}
+	}
+}
+
+// Reap does a single pass, errors are only logged - next tick will try again
+func (r *Reaper) Reap(ctx context.Context, now time.Time) {
+	const op = "reaper.Reap"
+
+	log := r.log.With(slog.String("op", op))
+
+	var (
+		removed int64
+		err     error
+	)
+	if r.archive {
+		removed, err = r.remover.ArchiveExpiredURLs(ctx, now)
+	} else {
+		removed, err = r.remover.DeleteExpiredURLs(ctx, now)
+	}
+	if err != nil {
+		log.Error("failed to remove expired urls", sl.Err(err))
+		return
+	}
+
+	if removed > 0 {
+		log.Info("removed expired urls", slog.Int64("count", removed), slog.Bool("archived", r.archive))
+	}
+}
@@ -0,0 +1,54 @@
+package reaper_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/reaper"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/memory"
+)
+
+func TestReaper_Reap(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		s := memory.New()
+
+		past := time.Now().Add(-time.Minute)
+		future := time.Now().Add(time.Hour)
+
+		_, err := s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "expired", ExpiresAt: &past})
+		require.NoError(t, err)
+		_, err = s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "live", ExpiresAt: &future})
+		require.NoError(t, err)
+
+		reaper.New(slogdiscard.NewDiscardLogger(), s, time.Minute, archive).Reap(t.Context(), time.Now())
+
+		_, err = s.GetURL(t.Context(), "expired")
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		_, err = s.GetURL(t.Context(), "live")
+		require.NoError(t, err)
+	}
+}
+
+func TestReaper_RunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		reaper.New(slogdiscard.NewDiscardLogger(), memory.New(), time.Millisecond, false).Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after cancel")
+	}
+}
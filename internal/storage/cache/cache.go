@@ -0,0 +1,195 @@
+// Package cache keeps recently redirected links in memory so GET /{alias} doesn't query the database every time
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// URLGetter is the storage the cache sits in front of
+type URLGetter interface {
+	GetURL(ctx context.Context, alias string) (storage.URL, error)
+}
+
+type Options struct {
+	// how many aliases are kept, the least recently used one goes first
+	Size int
+	// how long a found link is served from memory
+	TTL time.Duration
+	// how long an alias that doesn't exist is remembered, keep it short -
+	// a link saved on another instance stays missing here until it runs out
+	NegativeTTL time.Duration
+	// time.Now when nil
+	Now func() time.Time
+}
+
+// Stats are counted since the cache was created
+type Stats struct {
+	// answered from memory, missing aliases included
+	Hits uint64
+	// went to the storage
+	Misses uint64
+	// pushed out to make room, expired entries don't count
+	Evictions uint64
+	// entries right now
+	Size int
+}
+
+// Cache is an LRU of aliases with a TTL, it remembers missing aliases too
+// so nobody can hammer the database with made up ones
+type Cache struct {
+	next URLGetter
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// front is the most recently used
+	order *list.List
+	// bumped by every Invalidate, see GetURL
+	generation uint64
+	stats      Stats
+}
+
+type entry struct {
+	alias string
+	url   storage.URL
+	// false - the alias doesn't exist
+	found     bool
+	expiresAt time.Time
+}
+
+func New(next URLGetter, opts Options) (*Cache, error) {
+	const op = "storage.cache.New"
+
+	if opts.Size <= 0 {
+		return nil, fmt.Errorf("%s: size must be positive", op)
+	}
+	if opts.TTL <= 0 {
+		return nil, fmt.Errorf("%s: ttl must be positive", op)
+	}
+	if opts.NegativeTTL < 0 {
+		return nil, fmt.Errorf("%s: negative ttl can't be below zero", op)
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Cache{
+		next:    next,
+		opts:    opts,
+		entries: make(map[string]*list.Element, opts.Size),
+		order:   list.New(),
+	}, nil
+}
+
+// GetURL answers from memory when it can and asks the storage otherwise.
+// only ErrURLNotFound is remembered, any other error (a cancelled ctx included) goes straight back to the caller
+func (c *Cache) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.cache.GetURL"
+
+	c.mu.Lock()
+	if u, found, ok := c.lookup(alias); ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+
+		if !found {
+			return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+		}
+		return u, nil
+	}
+	c.stats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	// the lock isn't held while the storage answers, one slow query shouldn't stop every redirect
+	u, err := c.next.GetURL(ctx, alias)
+	found := err == nil
+	if err != nil && !errors.Is(err, storage.ErrURLNotFound) {
+		return storage.URL{}, err
+	}
+
+	c.mu.Lock()
+	// the link could have changed while we were reading it - storing what we read
+	// would bring back the old version after Invalidate already dropped it
+	if c.generation == generation {
+		c.store(alias, u, found)
+	}
+	c.mu.Unlock()
+
+	return u, err
+}
+
+// Invalidate forgets the aliases, call it after every write that touches them.
+// other instances only find out when their entries run out
+func (c *Cache) Invalidate(aliases ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, alias := range aliases {
+		if el, ok := c.entries[alias]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// lookup has to be called with mu held, ok is false when there's nothing fresh
+func (c *Cache) lookup(alias string) (u storage.URL, found bool, ok bool) {
+	el, ok := c.entries[alias]
+	if !ok {
+		return storage.URL{}, false, false
+	}
+
+	e := el.Value.(*entry)
+	if !c.opts.Now().Before(e.expiresAt) {
+		c.remove(el)
+		return storage.URL{}, false, false
+	}
+
+	c.order.MoveToFront(el)
+	return e.url, e.found, true
+}
+
+// store has to be called with mu held
+func (c *Cache) store(alias string, u storage.URL, found bool) {
+	ttl := c.opts.TTL
+	if !found {
+		ttl = c.opts.NegativeTTL
+	}
+	if ttl == 0 {
+		return
+	}
+
+	e := &entry{alias: alias, url: u, found: found, expiresAt: c.opts.Now().Add(ttl)}
+
+	if el, ok := c.entries[alias]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[alias] = c.order.PushFront(e)
+	if c.order.Len() > c.opts.Size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).alias)
+}
@@ -0,0 +1,268 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/storage/memory"
+)
+
+// counting is the storage behind the cache, it remembers how often it was asked
+type counting struct {
+	*memory.Storage
+
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (s *counting) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	s.mu.Lock()
+	s.calls++
+	err := s.err
+	s.mu.Unlock()
+
+	if err != nil {
+		return storage.URL{}, err
+	}
+	return s.Storage.GetURL(ctx, alias)
+}
+
+func (s *counting) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// clock is moved by hand so TTLs can run out without sleeping
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func setup(t *testing.T, size int) (*cache.Cache, *counting, *clock) {
+	t.Helper()
+
+	next := &counting{Storage: memory.New()}
+	clk := &clock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	c, err := cache.New(next, cache.Options{
+		Size:        size,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+		Now:         clk.Now,
+	})
+	require.NoError(t, err)
+
+	return c, next, clk
+}
+
+func TestNew(t *testing.T) {
+	next := memory.New()
+
+	_, err := cache.New(next, cache.Options{Size: 0, TTL: time.Minute})
+	require.Error(t, err)
+
+	_, err = cache.New(next, cache.Options{Size: 10, TTL: 0})
+	require.Error(t, err)
+
+	_, err = cache.New(next, cache.Options{Size: 10, TTL: time.Minute, NegativeTTL: -time.Second})
+	require.Error(t, err)
+
+	_, err = cache.New(next, cache.Options{Size: 10, TTL: time.Minute})
+	require.NoError(t, err)
+}
+
+func TestCache_Hit(t *testing.T) {
+	c, next, clk := setup(t, 10)
+
+	_, err := next.SaveURL(t.Context(), storage.URL{Alias: "google", URL: "https://google.com"})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		u, err := c.GetURL(t.Context(), "google")
+		require.NoError(t, err)
+		require.Equal(t, "https://google.com", u.URL)
+	}
+	require.Equal(t, 1, next.Calls())
+	require.Equal(t, cache.Stats{Hits: 2, Misses: 1, Size: 1}, c.Stats())
+
+	// ttl ran out - asked again
+	clk.now = clk.now.Add(time.Minute)
+	_, err = c.GetURL(t.Context(), "google")
+	require.NoError(t, err)
+	require.Equal(t, 2, next.Calls())
+}
+
+func TestCache_Negative(t *testing.T) {
+	c, next, clk := setup(t, 10)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetURL(t.Context(), "missing")
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	}
+	require.Equal(t, 1, next.Calls())
+
+	// saved behind the cache's back - stays missing until the negative ttl runs out
+	_, err := next.SaveURL(t.Context(), storage.URL{Alias: "missing", URL: "https://example.com"})
+	require.NoError(t, err)
+
+	_, err = c.GetURL(t.Context(), "missing")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	clk.now = clk.now.Add(10 * time.Second)
+	u, err := c.GetURL(t.Context(), "missing")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", u.URL)
+	require.Equal(t, 2, next.Calls())
+}
+
+func TestCache_NegativeDisabled(t *testing.T) {
+	next := &counting{Storage: memory.New()}
+	c, err := cache.New(next, cache.Options{Size: 10, TTL: time.Minute})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetURL(t.Context(), "missing")
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	}
+	require.Equal(t, 3, next.Calls())
+	require.Zero(t, c.Stats().Size)
+}
+
+func TestCache_ErrorsAreNotCached(t *testing.T) {
+	c, next, _ := setup(t, 10)
+
+	next.err = storage.ErrDatabaseError
+	_, err := c.GetURL(t.Context(), "google")
+	require.ErrorIs(t, err, storage.ErrDatabaseError)
+	require.False(t, errors.Is(err, storage.ErrURLNotFound))
+
+	next.err = nil
+	_, err = c.GetURL(t.Context(), "google")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+	require.Equal(t, 2, next.Calls())
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	c, next, _ := setup(t, 10)
+
+	_, err := next.SaveURL(t.Context(), storage.URL{Alias: "google", URL: "https://google.com"})
+	require.NoError(t, err)
+
+	_, err = c.GetURL(t.Context(), "google")
+	require.NoError(t, err)
+
+	_, err = next.UpdateURL(t.Context(), "google", "", "https://google.de", 0)
+	require.NoError(t, err)
+	c.Invalidate("google")
+
+	u, err := c.GetURL(t.Context(), "google")
+	require.NoError(t, err)
+	require.Equal(t, "https://google.de", u.URL)
+
+	require.NoError(t, next.DeleteURL(t.Context(), "google", ""))
+	c.Invalidate("google", "not-cached")
+
+	_, err = c.GetURL(t.Context(), "google")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func TestCache_Evict(t *testing.T) {
+	c, next, _ := setup(t, 2)
+
+	for _, alias := range []string{"one", "two", "three"} {
+		_, err := next.SaveURL(t.Context(), storage.URL{Alias: alias, URL: "https://example.com/" + alias})
+		require.NoError(t, err)
+	}
+
+	// "one" is used after "two", so "two" is the least recently used when "three" comes
+	_, _ = c.GetURL(t.Context(), "one")
+	_, _ = c.GetURL(t.Context(), "two")
+	_, _ = c.GetURL(t.Context(), "one")
+	_, _ = c.GetURL(t.Context(), "three")
+	require.Equal(t, cache.Stats{Hits: 1, Misses: 3, Evictions: 1, Size: 2}, c.Stats())
+
+	calls := next.Calls()
+	_, _ = c.GetURL(t.Context(), "one")
+	_, _ = c.GetURL(t.Context(), "three")
+	require.Equal(t, calls, next.Calls())
+
+	_, _ = c.GetURL(t.Context(), "two")
+	require.Equal(t, calls+1, next.Calls())
+}
+
+// slow hands out what it read before Invalidate only after it
+type slow struct {
+	url     storage.URL
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *slow) GetURL(context.Context, string) (storage.URL, error) {
+	close(s.reading)
+	<-s.release
+	return s.url, nil
+}
+
+func TestCache_InvalidateDuringRead(t *testing.T) {
+	next := &slow{
+		url:     storage.URL{Alias: "google", URL: "https://old.example.com"},
+		reading: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	c, err := cache.New(next, cache.Options{Size: 10, TTL: time.Minute})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.GetURL(t.Context(), "google")
+	}()
+
+	<-next.reading
+	c.Invalidate("google")
+	close(next.release)
+	<-done
+
+	// the old url must not have been kept
+	require.Zero(t, c.Stats().Size)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c, next, _ := setup(t, 50)
+
+	for i := 0; i < 100; i++ {
+		_, err := next.SaveURL(t.Context(), storage.URL{Alias: fmt.Sprintf("link%d", i), URL: "https://example.com"})
+		require.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				alias := fmt.Sprintf("link%d", (i*w)%100)
+				if i%100 == 0 {
+					c.Invalidate(alias)
+					continue
+				}
+				_, err := c.GetURL(t.Context(), alias)
+				require.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, c.Stats().Size, 50)
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+func (s *Storage) SaveAPIKey(_ context.Context, k storage.APIKey) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastKeyID++
+	k.ID = s.lastKeyID
+	k.CreatedAt = time.Now()
+	k.Scopes = append([]string(nil), k.Scopes...)
+	s.apiKeys[k.ID] = k
+
+	return k.ID, nil
+}
+
+// GetAPIKey looks a key up by its hash, revoked keys are returned too
+func (s *Storage) GetAPIKey(_ context.Context, hash string) (storage.APIKey, error) {
+	const op = "storage.memory.GetAPIKey"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// there are only a handful of keys, no need for a second index
+	for _, k := range s.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return storage.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+}
+
+func (s *Storage) ListAPIKeys(_ context.Context) ([]storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]storage.APIKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops the key from working, revoking it again keeps the first timestamp
+func (s *Storage) RevokeAPIKey(_ context.Context, id int64, at time.Time) error {
+	const op = "storage.memory.RevokeAPIKey"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		s.apiKeys[id] = k
+	}
+	return nil
+}
+
+// TouchAPIKey records when the key was last used
+func (s *Storage) TouchAPIKey(_ context.Context, id int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.apiKeys[id]; ok {
+		k.LastUsedAt = &at
+		s.apiKeys[id] = k
+	}
+	return nil
+}
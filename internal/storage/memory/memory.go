@@ -0,0 +1,430 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// Storage keeps everything in a map - for local dev and tests, nothing survives a restart.
+// nothing here waits on the network, so contexts are accepted and ignored
+type Storage struct {
+	mu       sync.RWMutex
+	lastID   int64
+	urls     map[string]storage.URL // alias -> url
+	ids      map[int64]string       // url id -> alias, clicks only know the id
+	archived []storage.URL
+	clicks   map[int64][]storage.Click // url id -> clicks
+	// clicks of archived links, by url id
+	archivedClicks map[int64][]storage.Click
+
+	lastKeyID int64
+	apiKeys   map[int64]storage.APIKey
+
+	lastAliasID int64
+}
+
+func New() *Storage {
+	return &Storage{
+		urls:    make(map[string]storage.URL),
+		ids:     make(map[int64]string),
+		clicks:  make(map[int64][]storage.Click),
+		apiKeys: make(map[int64]storage.APIKey),
+
+		archivedClicks: make(map[int64][]storage.Click),
+	}
+}
+
+// Ping always works, there is nothing to connect to
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
+}
+
+// SchemaVersion is always 0, the memory storage has no migrations
+func (s *Storage) SchemaVersion(_ context.Context) (uint, bool, error) {
+	return 0, false, nil
+}
+
+// Close is a no-op, it's here so every backend can be closed the same way
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) SaveURL(_ context.Context, u storage.URL) (int64, error) {
+	const op = "storage.memory.SaveURL"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[u.Alias]; ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUrlExists)
+	}
+
+	return s.insert(u), nil
+}
+
+// SaveURLs saves all links or none of them, same as the transaction in the sql backends
+func (s *Storage) SaveURLs(_ context.Context, urls []storage.URL) ([]int64, error) {
+	const op = "storage.memory.SaveURLs"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// check everything first so a failure leaves nothing behind
+	seen := make(map[string]bool, len(urls))
+	for i, u := range urls {
+		if _, ok := s.urls[u.Alias]; ok || seen[u.Alias] {
+			return nil, fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: storage.ErrUrlExists})
+		}
+		seen[u.Alias] = true
+	}
+
+	ids := make([]int64, 0, len(urls))
+	for _, u := range urls {
+		ids = append(ids, s.insert(u))
+	}
+	return ids, nil
+}
+
+// owned returns the link if it belongs to owner, any owner matches an empty one.
+// the caller holds the lock
+func (s *Storage) owned(alias string, owner string) (storage.URL, bool) {
+	u, ok := s.urls[alias]
+	if !ok || (owner != "" && u.OwnerID != owner) {
+		return storage.URL{}, false
+	}
+	return u, true
+}
+
+// insert stores a new link, the caller holds the lock and has checked the alias is free
+func (s *Storage) insert(u storage.URL) int64 {
+	s.lastID++
+	u.ID = s.lastID
+	u.CreatedAt = time.Now()
+	u.Version = 1
+	u.ClicksLeft = u.MaxClicks
+	// copy the pointer target so the caller can't change it behind our back
+	if u.ExpiresAt != nil {
+		expiresAt := *u.ExpiresAt
+		u.ExpiresAt = &expiresAt
+	}
+	if u.NotBefore != nil {
+		notBefore := *u.NotBefore
+		u.NotBefore = &notBefore
+	}
+	s.urls[u.Alias] = u
+	s.ids[u.ID] = u.Alias
+
+	return u.ID
+}
+
+func (s *Storage) GetURL(_ context.Context, alias string) (storage.URL, error) {
+	const op = "storage.memory.GetURL"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.urls[alias]
+	if !ok {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+	return u, nil
+}
+
+// TakeClick spends a click of a link with MaxClicks and returns the link as it is after that,
+// ErrURLExhausted once there are none left. links without a limit come back untouched
+func (s *Storage) TakeClick(_ context.Context, alias string) (storage.URL, error) {
+	const op = "storage.memory.TakeClick"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.urls[alias]
+	if !ok {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+	if u.MaxClicks == 0 {
+		return u, nil
+	}
+	if u.ClicksLeft <= 0 {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLExhausted)
+	}
+
+	u.ClicksLeft--
+	s.urls[alias] = u
+
+	return u, nil
+}
+
+// UpdateURL points alias to a new destination and bumps its version.
+// version 0 updates unconditionally, anything else has to match the stored version
+func (s *Storage) UpdateURL(_ context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	const op = "storage.memory.UpdateURL"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.owned(alias, owner)
+	if !ok {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+	if version != 0 && version != u.Version {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrVersionMismatch)
+	}
+
+	u.URL = newURL
+	u.Version++
+	s.urls[alias] = u
+
+	return u, nil
+}
+
+func (s *Storage) DeleteURL(_ context.Context, alias string, owner string) error {
+	const op = "storage.memory.DeleteURL"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.owned(alias, owner)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrNoURLDeleted)
+	}
+	s.remove(u)
+
+	return nil
+}
+
+// DeleteURLs deletes all aliases, if one of them doesn't exist nothing is deleted
+func (s *Storage) DeleteURLs(_ context.Context, aliases []string, owner string) error {
+	const op = "storage.memory.DeleteURLs"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the same alias twice fails on the second one, like a second DELETE would
+	seen := make(map[string]bool, len(aliases))
+	for i, alias := range aliases {
+		if _, ok := s.owned(alias, owner); !ok || seen[alias] {
+			return fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: storage.ErrNoURLDeleted})
+		}
+		seen[alias] = true
+	}
+
+	for _, alias := range aliases {
+		s.remove(s.urls[alias])
+	}
+	return nil
+}
+
+// DeleteExpiredURLs removes every link that expired before now
+func (s *Storage) DeleteExpiredURLs(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for _, u := range s.urls {
+		if u.Expired(now) {
+			s.remove(u)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ArchiveExpiredURLs moves every link that expired before now, and its clicks, to the archive
+func (s *Storage) ArchiveExpiredURLs(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for _, u := range s.urls {
+		if u.Expired(now) {
+			s.archived = append(s.archived, u)
+			if clicks := s.clicks[u.ID]; len(clicks) > 0 {
+				s.archivedClicks[u.ID] = clicks
+			}
+			s.remove(u)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ListURLs returns one page of links, see storage.ListFilter
+func (s *Storage) ListURLs(_ context.Context, filter storage.ListFilter) ([]storage.URL, error) {
+	const op = "storage.memory.ListURLs"
+
+	less, err := lessFunc(filter.Sort)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	switch filter.State {
+	case "", storage.StateScheduled, storage.StateActive, storage.StateExpired:
+	default:
+		return nil, fmt.Errorf("%s: unknown state %q", op, filter.State)
+	}
+	// before reports whether a comes first in the requested order
+	before := func(a, b storage.URL) bool {
+		if filter.Desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host := strings.ToLower(filter.Host)
+
+	var urls []storage.URL
+	for _, u := range s.urls {
+		if filter.Owner != "" && u.OwnerID != filter.Owner {
+			continue
+		}
+		if !strings.HasPrefix(u.Alias, filter.AliasPrefix) {
+			continue
+		}
+		if host != "" && !strings.Contains(storage.HostOf(u.URL), host) {
+			continue
+		}
+		if filter.CreatedFrom != nil && u.CreatedAt.Before(*filter.CreatedFrom) {
+			continue
+		}
+		if filter.CreatedTo != nil && !u.CreatedAt.Before(*filter.CreatedTo) {
+			continue
+		}
+		if filter.State != "" && u.State(filter.Now) != filter.State {
+			continue
+		}
+		if filter.After != nil && !before(*filter.After, u) {
+			continue
+		}
+		urls = append(urls, u)
+	}
+
+	sort.Slice(urls, func(i, j int) bool { return before(urls[i], urls[j]) })
+
+	if len(urls) > filter.Limit {
+		urls = urls[:filter.Limit]
+	}
+	return urls, nil
+}
+
+// lessFunc orders by the sort column and then by id, same as the sql backends
+func lessFunc(sortBy storage.ListSort) (func(a, b storage.URL) bool, error) {
+	switch sortBy {
+	case storage.SortByID, "":
+		return func(a, b storage.URL) bool { return a.ID < b.ID }, nil
+	case storage.SortByCreatedAt:
+		return func(a, b storage.URL) bool {
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return a.ID < b.ID
+		}, nil
+	case storage.SortByAlias:
+		return func(a, b storage.URL) bool {
+			if a.Alias != b.Alias {
+				return a.Alias < b.Alias
+			}
+			return a.ID < b.ID
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sort %q", sortBy)
+	}
+}
+
+// SaveClicks stores a batch of clicks, clicks of deleted links are skipped
+func (s *Storage) SaveClicks(_ context.Context, clicks []storage.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range clicks {
+		if _, ok := s.ids[c.URLID]; !ok {
+			continue
+		}
+		s.clicks[c.URLID] = append(s.clicks[c.URLID], c)
+	}
+	return nil
+}
+
+// GetURLStats aggregates the clicks of alias, the histogram starts at since
+func (s *Storage) GetURLStats(_ context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	const op = "storage.memory.GetURLStats"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.owned(alias, owner)
+	if !ok {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+
+	clicks := s.clicks[u.ID]
+
+	daily := make(map[string]int64)
+	referrers := make(map[string]int64)
+	userAgents := make(map[string]int64)
+	for _, c := range clicks {
+		if !c.At.Before(since) {
+			daily[c.At.UTC().Format(time.DateOnly)]++
+		}
+		if c.Referrer != "" {
+			referrers[c.Referrer]++
+		}
+		if c.UserAgent != "" {
+			userAgents[c.UserAgent]++
+		}
+	}
+
+	stats := storage.URLStats{
+		Alias:         alias,
+		Total:         int64(len(clicks)),
+		TopReferrers:  topCounters(referrers, top),
+		TopUserAgents: topCounters(userAgents, top),
+	}
+	for day, n := range daily {
+		stats.Daily = append(stats.Daily, storage.DailyClicks{Day: day, Clicks: n})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Day < stats.Daily[j].Day })
+
+	return stats, nil
+}
+
+// remove drops u and its clicks, caller holds the write lock
+func (s *Storage) remove(u storage.URL) {
+	delete(s.urls, u.Alias)
+	delete(s.ids, u.ID)
+	delete(s.clicks, u.ID)
+}
+
+// topCounters sorts the same way the sql backends do - most clicks first, then by value
+func topCounters(counts map[string]int64, top int) []storage.Counter {
+	var counters []storage.Counter
+	for value, n := range counts {
+		counters = append(counters, storage.Counter{Value: value, Clicks: n})
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Clicks != counters[j].Clicks {
+			return counters[i].Clicks > counters[j].Clicks
+		}
+		return counters[i].Value < counters[j].Value
+	})
+	if len(counters) > top {
+		counters = counters[:top]
+	}
+	return counters
+}
+
+// NextAliasID hands out ids for generated aliases, starts over after a restart like everything else here
+func (s *Storage) NextAliasID(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAliasID++
+	return s.lastAliasID, nil
+}
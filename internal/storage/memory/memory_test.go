@@ -0,0 +1,77 @@
+package memory_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/memory"
+	"url-shortener/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, memory.New())
+}
+
+// run with -race, the whole point of the mutex
+func TestStorage_Concurrent(t *testing.T) {
+	s := memory.New()
+
+	const workers = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	ownErrs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// everyone fights over the same alias, and also saves their own one
+			_, err := s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "shared"})
+			errs <- err
+
+			_, err = s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: fmt.Sprintf("alias%d", i)})
+			ownErrs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	close(ownErrs)
+
+	for err := range ownErrs {
+		require.NoError(t, err)
+	}
+
+	saved := 0
+	for err := range errs {
+		if err == nil {
+			saved++
+			continue
+		}
+		require.ErrorIs(t, err, storage.ErrUrlExists)
+	}
+	require.Equal(t, 1, saved)
+}
+
+func TestStorage_CopiesTimes(t *testing.T) {
+	s := memory.New()
+
+	expiresAt := time.Now().Add(time.Hour)
+	notBefore := time.Now().Add(time.Minute)
+	_, err := s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "google", ExpiresAt: &expiresAt, NotBefore: &notBefore})
+	require.NoError(t, err)
+
+	// the caller reusing its variables doesn't move the stored window
+	want := [2]time.Time{expiresAt, notBefore}
+	expiresAt = expiresAt.Add(time.Hour)
+	notBefore = notBefore.Add(time.Hour)
+
+	u, err := s.GetURL(t.Context(), "google")
+	require.NoError(t, err)
+	require.Equal(t, want, [2]time.Time{*u.ExpiresAt, *u.NotBefore})
+}
@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"url-shortener/internal/storage"
+)
+
+// apiKeyColumns is what scanAPIKey expects, in this order
+const apiKeyColumns = `id, name, key_hash, scopes, owner_id, created_at, last_used_at, revoked_at`
+
+func (s *Storage) SaveAPIKey(ctx context.Context, k storage.APIKey) (int64, error) {
+	const op = "storage.postgres.SaveAPIKey"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO public.api_keys(name, key_hash, scopes, owner_id) VALUES($1, $2, $3, $4) RETURNING id`,
+		k.Name, k.Hash, strings.Join(k.Scopes, " "), k.OwnerID,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// GetAPIKey looks a key up by its hash, revoked keys are returned too
+func (s *Storage) GetAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	const op = "storage.postgres.GetAPIKey"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM public.api_keys WHERE key_hash=$1`, hash,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return storage.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+		return storage.APIKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return k, nil
+}
+
+func (s *Storage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	const op = "storage.postgres.ListAPIKeys"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM public.api_keys ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []storage.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey stops the key from working, revoking it again keeps the first timestamp
+func (s *Storage) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	const op = "storage.postgres.RevokeAPIKey"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE public.api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id=$1`, id, at,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+	return nil
+}
+
+// TouchAPIKey records when the key was last used
+func (s *Storage) TouchAPIKey(ctx context.Context, id int64, at time.Time) error {
+	const op = "storage.postgres.TouchAPIKey"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE public.api_keys SET last_used_at=$2 WHERE id=$1`, id, at); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row scanner) (storage.APIKey, error) {
+	var k storage.APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.Hash, &scopes, &k.OwnerID, &k.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return storage.APIKey{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return k, nil
+}
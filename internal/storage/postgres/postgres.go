@@ -1,8 +1,12 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 	"url-shortener/internal/storage"
 
 	"github.com/lib/pq"
@@ -10,10 +14,20 @@ import (
 )
 
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts storage.Timeouts
 }
 
-func New(connString string) (*Storage, error) {
+// urlColumns is what scanURL expects, in this order
+const urlColumns = `id, alias, url, expires_at, not_before, created_at, version, owner_id, redirect_code, password_hash, max_clicks, clicks_left, forward_path, forward_query, utm`
+
+// insertURL is shared by SaveURL and SaveURLs, insertArgs fills it
+const insertURL = `
+	INSERT INTO public.url(url, alias, expires_at, not_before, host, owner_id, redirect_code, password_hash, max_clicks, clicks_left, forward_path, forward_query, utm)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10, $11, $12)
+	RETURNING id`
+
+func New(connString string, timeouts storage.Timeouts) (*Storage, error) {
 	const op = "storage.postgres.New"
 
 	db, err := sql.Open("postgres", connString)
@@ -48,17 +62,71 @@ func New(connString string) (*Storage, error) {
 	//	return nil, fmt.Errorf("%s: %w", op, err)
 	//}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: db, timeouts: timeouts}, nil
+}
+
+// Ping checks that the database still answers
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.postgres.Ping"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SchemaVersion is the last migration golang-migrate applied,
+// dirty means it failed halfway and the schema needs fixing by hand
+func (s *Storage) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	const op = "storage.postgres.SchemaVersion"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	var (
+		version int64
+		dirty   bool
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the table is there but nothing was applied yet
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uint(version), dirty, nil
+}
+
+// DBStats - the connection pool, for /metrics
+func (s *Storage) DBStats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Close closes the connection pool, the storage is unusable after that
+func (s *Storage) Close() error {
+	const op = "storage.postgres.Close"
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
 }
 
-func (s *Storage) SaveURL(urlToSave string, alias string) (int64, error) {
+func (s *Storage) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
 	const op = "storage.postgres.SaveURL"
 
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
 	var id int64
-	err := s.db.QueryRow(
-		`INSERT INTO public.url(url, alias) VALUES($1, $2) RETURNING id`,
-		urlToSave, alias,
-	).Scan(&id)
+	err := s.db.QueryRowContext(ctx, insertURL, insertArgs(u)...).Scan(&id)
 	if err != nil {
 		// check if it's a unique constraint violation - duplicate alias
 		if pqErr, ok := err.(*pq.Error); ok {
@@ -72,24 +140,142 @@ func (s *Storage) SaveURL(urlToSave string, alias string) (int64, error) {
 	return id, nil
 }
 
-func (s *Storage) GetURL(alias string) (string, error) {
+// SaveURLs saves all links in one transaction - either all of them are stored or none.
+// on failure the error wraps a *storage.BatchError pointing at the offending link
+func (s *Storage) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	const op = "storage.postgres.SaveURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, insertURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, 0, len(urls))
+	for i, u := range urls {
+		var id int64
+		err := stmt.QueryRowContext(ctx, insertArgs(u)...).Scan(&id)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				err = storage.ErrUrlExists
+			}
+			return nil, fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: err})
+		}
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return ids, nil
+}
+
+func (s *Storage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
 	const op = "storage.postgres.GetURL"
 
-	var urlToGet string
-	err := s.db.QueryRow(`SELECT url FROM public.url WHERE alias=$1;`, alias).Scan(&urlToGet)
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx,
+		`SELECT `+urlColumns+` FROM public.url WHERE alias=$1;`, alias,
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+			return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
 		}
-		return "", fmt.Errorf("%s: %w", op, err)
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
 	}
-	return urlToGet, nil
+	return u, nil
 }
 
-func (s *Storage) DeleteURL(alias string) error {
+// TakeClick spends a click of a link with MaxClicks and returns the link as it is after that,
+// ErrURLExhausted once there are none left. links without a limit come back untouched.
+// the check and the decrement are one statement, so concurrent redirects can't go over the limit
+func (s *Storage) TakeClick(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.postgres.TakeClick"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx, `
+		UPDATE public.url SET clicks_left = clicks_left - 1
+		WHERE alias = $1 AND max_clicks > 0 AND clicks_left > 0
+		RETURNING `+urlColumns,
+		alias,
+	))
+	if err == nil {
+		return u, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// nothing was updated - the link is missing, has no limit or used it up
+	u, err = scanURL(s.db.QueryRowContext(ctx, `SELECT `+urlColumns+` FROM public.url WHERE alias = $1`, alias))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if u.MaxClicks > 0 {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLExhausted)
+	}
+	return u, nil
+}
+
+// UpdateURL points alias to a new destination and bumps its version.
+// version 0 updates unconditionally, anything else has to match the stored version
+func (s *Storage) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	const op = "storage.postgres.UpdateURL"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx, `
+		UPDATE public.url SET url=$1, host=$2, version=version+1
+		WHERE alias=$3 AND ($4 = '' OR owner_id=$4) AND ($5 = 0 OR version=$5)
+		RETURNING `+urlColumns,
+		newURL, storage.HostOf(newURL), alias, owner, version,
+	))
+	if err == nil {
+		return u, nil
+	}
+	if err != sql.ErrNoRows {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// nothing was updated - either there is no such alias or the version is stale
+	var exists bool
+	err = s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM public.url WHERE alias=$1 AND ($2 = '' OR owner_id=$2))`, alias, owner,
+	).Scan(&exists)
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if exists {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrVersionMismatch)
+	}
+	return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+}
+
+func (s *Storage) DeleteURL(ctx context.Context, alias string, owner string) error {
 	const op = "storage.postgres.DeleteURL"
 
-	result, err := s.db.Exec(`DELETE FROM public.url WHERE alias=$1`, alias)
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM public.url WHERE alias=$1 AND ($2 = '' OR owner_id=$2)`, alias, owner)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -106,3 +292,378 @@ func (s *Storage) DeleteURL(alias string) error {
 	}
 	return nil
 }
+
+// DeleteURLs deletes all aliases in one transaction, if one of them doesn't exist nothing is deleted
+func (s *Storage) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	const op = "storage.postgres.DeleteURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `DELETE FROM public.url WHERE alias=$1 AND ($2 = '' OR owner_id=$2)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for i, alias := range aliases {
+		result, err := stmt.ExecContext(ctx, alias, owner)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: err})
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if rows == 0 {
+			return fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: storage.ErrNoURLDeleted})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteExpiredURLs removes every link that expired before now
+func (s *Storage) DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM public.url WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return rows, nil
+}
+
+// ArchiveExpiredURLs moves every link that expired before now to public.url_archive,
+// and its clicks to public.clicks_archive
+func (s *Storage) ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.postgres.ArchiveExpiredURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	// one statement, so a link can't be archived and then survive the delete.
+	// every part of it sees the clicks as they were before the delete cascaded to them
+	result, err := s.db.ExecContext(ctx, `
+		WITH expired AS (
+			DELETE FROM public.url WHERE expires_at <= $1
+			RETURNING id, alias, url, expires_at
+		), clicks AS (
+			INSERT INTO public.clicks_archive(id, url_id, clicked_at, referrer, user_agent, ip, request_id)
+			SELECT c.id, c.url_id, c.clicked_at, c.referrer, c.user_agent, c.ip, c.request_id
+			FROM public.clicks c JOIN expired e ON e.id = c.url_id
+		)
+		INSERT INTO public.url_archive(id, alias, url, expires_at, archived_at)
+		SELECT id, alias, url, expires_at, $1 FROM expired`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return rows, nil
+}
+
+// SaveClicks writes a batch of clicks in one transaction.
+// clicks of links that were deleted in the meantime are skipped
+func (s *Storage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	const op = "storage.postgres.SaveClicks"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO public.clicks(url_id, clicked_at, referrer, user_agent, ip, request_id)
+		SELECT $1::integer, $2::timestamptz, $3::text, $4::text, $5::text, $6::text
+		WHERE EXISTS (SELECT 1 FROM public.url WHERE id = $1)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		if _, err := stmt.ExecContext(ctx, c.URLID, c.At, c.Referrer, c.UserAgent, c.IP, c.RequestID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// GetURLStats aggregates the clicks of alias, the histogram starts at since
+func (s *Storage) GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	const op = "storage.postgres.GetURLStats"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	stats := storage.URLStats{Alias: alias}
+
+	var urlID int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id FROM public.url WHERE alias=$1 AND ($2 = '' OR owner_id=$2)`, alias, owner,
+	).Scan(&urlID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return storage.URLStats{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+		}
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM public.clicks WHERE url_id=$1`, urlID).Scan(&stats.Total)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*)
+		FROM public.clicks
+		WHERE url_id=$1 AND clicked_at >= $2
+		GROUP BY day
+		ORDER BY day`,
+		urlID, since,
+	)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d storage.DailyClicks
+		if err := rows.Scan(&d.Day, &d.Clicks); err != nil {
+			return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+		}
+		stats.Daily = append(stats.Daily, d)
+	}
+	if err := rows.Err(); err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats.TopReferrers, err = s.topClicks(ctx, urlID, "referrer", top)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats.TopUserAgents, err = s.topClicks(ctx, urlID, "user_agent", top)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// topClicks groups clicks by column, column is never user input
+func (s *Storage) topClicks(ctx context.Context, urlID int64, column string, top int) ([]storage.Counter, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s, count(*) AS total
+		FROM public.clicks
+		WHERE url_id=$1 AND %[1]s <> ''
+		GROUP BY %[1]s
+		ORDER BY total DESC, %[1]s
+		LIMIT $2`, column),
+		urlID, top,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []storage.Counter
+	for rows.Next() {
+		var c storage.Counter
+		if err := rows.Scan(&c.Value, &c.Clicks); err != nil {
+			return nil, err
+		}
+		counters = append(counters, c)
+	}
+	return counters, rows.Err()
+}
+
+// ListURLs returns one page of links, see storage.ListFilter
+func (s *Storage) ListURLs(ctx context.Context, filter storage.ListFilter) ([]storage.URL, error) {
+	const op = "storage.postgres.ListURLs"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	var (
+		where []string
+		args  []any
+	)
+	// arg remembers the value and gives back its placeholder
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Owner != "" {
+		where = append(where, "owner_id = "+arg(filter.Owner))
+	}
+	if filter.AliasPrefix != "" {
+		where = append(where, "starts_with(alias, "+arg(filter.AliasPrefix)+")")
+	}
+	if filter.Host != "" {
+		where = append(where, "strpos(host, "+arg(strings.ToLower(filter.Host))+") > 0")
+	}
+	if filter.CreatedFrom != nil {
+		where = append(where, "created_at >= "+arg(*filter.CreatedFrom))
+	}
+	if filter.CreatedTo != nil {
+		where = append(where, "created_at < "+arg(*filter.CreatedTo))
+	}
+	if filter.State != "" {
+		cond, err := stateCondition(filter.State, arg(filter.Now))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		where = append(where, cond)
+	}
+
+	column, err := sortColumn(filter.Sort)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cmp, order := ">", "ASC"
+	if filter.Desc {
+		cmp, order = "<", "DESC"
+	}
+
+	if after := filter.After; after != nil {
+		switch filter.Sort {
+		case storage.SortByCreatedAt:
+			where = append(where, fmt.Sprintf("(created_at, id) %s (%s, %s)", cmp, arg(after.CreatedAt), arg(after.ID)))
+		case storage.SortByAlias:
+			where = append(where, fmt.Sprintf("(alias, id) %s (%s, %s)", cmp, arg(after.Alias), arg(after.ID)))
+		default:
+			where = append(where, fmt.Sprintf("id %s %s", cmp, arg(after.ID)))
+		}
+	}
+
+	query := `SELECT ` + urlColumns + ` FROM public.url`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT %s`, column, order, order, arg(filter.Limit))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var urls []storage.URL
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return urls, nil
+}
+
+// stateCondition is the WHERE of a ListState at the moment behind the placeholder now.
+// same order of checks as URL.State - an expired link is expired, whatever its not_before
+func stateCondition(state storage.ListState, now string) (string, error) {
+	switch state {
+	case storage.StateExpired:
+		return "expires_at <= " + now, nil
+	case storage.StateScheduled:
+		return fmt.Sprintf("(expires_at IS NULL OR expires_at > %[1]s) AND not_before > %[1]s", now), nil
+	case storage.StateActive:
+		return fmt.Sprintf("(expires_at IS NULL OR expires_at > %[1]s) AND (not_before IS NULL OR not_before <= %[1]s)", now), nil
+	default:
+		return "", fmt.Errorf("unknown state %q", state)
+	}
+}
+
+// sortColumn whitelists the ORDER BY column, it ends up in the query as is
+func sortColumn(sort storage.ListSort) (string, error) {
+	switch sort {
+	case storage.SortByID, "":
+		return "id", nil
+	case storage.SortByCreatedAt:
+		return "created_at", nil
+	case storage.SortByAlias:
+		return "alias", nil
+	default:
+		return "", fmt.Errorf("unknown sort %q", sort)
+	}
+}
+
+// scanner is either *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// insertArgs are the values for insertURL
+func insertArgs(u storage.URL) []any {
+	return []any{u.URL, u.Alias, u.ExpiresAt, u.NotBefore, storage.HostOf(u.URL), u.OwnerID, u.RedirectCode, u.PasswordHash, u.MaxClicks, u.ForwardPath, u.ForwardQuery, u.UTM}
+}
+
+// scanURL reads a row selected with urlColumns
+func scanURL(row scanner) (storage.URL, error) {
+	var u storage.URL
+	var expiresAt, notBefore sql.NullTime
+	if err := row.Scan(&u.ID, &u.Alias, &u.URL, &expiresAt, &notBefore, &u.CreatedAt, &u.Version, &u.OwnerID, &u.RedirectCode, &u.PasswordHash, &u.MaxClicks, &u.ClicksLeft, &u.ForwardPath, &u.ForwardQuery, &u.UTM); err != nil {
+		return storage.URL{}, err
+	}
+	if expiresAt.Valid {
+		u.ExpiresAt = &expiresAt.Time
+	}
+	if notBefore.Valid {
+		u.NotBefore = &notBefore.Time
+	}
+	return u, nil
+}
+
+// NextAliasID hands out ids for generated aliases, never the same one twice
+func (s *Storage) NextAliasID(ctx context.Context) (int64, error) {
+	const op = "storage.postgres.NextAliasID"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	var id int64
+	if err := s.db.QueryRowContext(ctx, `SELECT nextval('public.alias_seq')`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
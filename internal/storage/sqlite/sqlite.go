@@ -1,47 +1,710 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+	"url-shortener/internal/storage"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts storage.Timeouts
 }
 
-func New(storagePath string) (*Storage, error) {
+// urlColumns is what scanURL expects, in this order
+const urlColumns = `id, alias, url, expires_at, not_before, created_at, version, owner_id, redirect_code, password_hash, max_clicks, clicks_left, forward_path, forward_query, utm`
+
+// insertURL is shared by SaveURL and SaveURLs, insertArgs fills it
+const insertURL = `
+	INSERT INTO url(url, alias, expires_at, not_before, created_at, host, owner_id, redirect_code, password_hash, max_clicks, clicks_left, forward_path, forward_query, utm)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// New opens the sqlite file, the schema itself comes from migrations/sqlite
+func New(storagePath string, timeouts storage.Timeouts) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	// foreign keys are off by default in sqlite, clicks rely on ON DELETE CASCADE
+	dsn := storagePath + "?_foreign_keys=on"
+	if strings.Contains(storagePath, "?") {
+		dsn = storagePath + "&_foreign_keys=on"
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	stmt, err := db.Prepare(`
-	CREATE TABLE IF NOT EXISTS url(
-		id INTEGER PRIMARY KEY,
-		alias TEXT NOT NULL UNIQUE,
-		url TEXT NOT NULL
-	);
-	`)
+	// sql.Open is lazy, so make sure the file can actually be opened
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// sqlite has a single writer anyway, more connections just end up in "database is locked"
+	db.SetMaxOpenConns(1)
+
+	return &Storage{db: db, timeouts: timeouts}, nil
+}
+
+// Ping checks that the database still answers
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SchemaVersion is the last migration golang-migrate applied,
+// dirty means it failed halfway and the schema needs fixing by hand
+func (s *Storage) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	const op = "storage.sqlite.SchemaVersion"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	var (
+		version int64
+		dirty   bool
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the table is there but nothing was applied yet
+		return 0, false, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("#{op}: #{err}")
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uint(version), dirty, nil
+}
+
+// DBStats - the connection pool, for /metrics
+func (s *Storage) DBStats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Close closes the connection pool, the storage is unusable after that
+func (s *Storage) Close() error {
+	const op = "storage.sqlite.Close"
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if _, err = stmt.Exec(); err != nil {
+	return nil
+}
+
+func (s *Storage) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
+	const op = "storage.sqlite.SaveURL"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, insertURL, insertArgs(u, time.Now().UTC())...)
+	if err != nil {
+		// same as 23505 in postgres - alias is already taken
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUrlExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: failed to get last insert id: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// SaveURLs saves all links in one transaction - either all of them are stored or none.
+// on failure the error wraps a *storage.BatchError pointing at the offending link
+func (s *Storage) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	const op = "storage.sqlite.SaveURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	// no-op after commit
+	defer func() { _ = tx.Rollback() }()
 
-	stmt, err = db.Prepare(`CREATE INDEX IF NOT EXISTS idx_alias ON url(alias);`)
+	stmt, err := tx.PrepareContext(ctx, insertURL)
 	if err != nil {
-		return nil, fmt.Errorf("#{op}: #{err}")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	ids := make([]int64, 0, len(urls))
+	for i, u := range urls {
+		res, err := stmt.ExecContext(ctx, insertArgs(u, now)...)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				err = storage.ErrUrlExists
+			}
+			return nil, fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: err})
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to get last insert id: %w", op, err)
+		}
+		ids = append(ids, id)
 	}
 
-	if _, err = stmt.Exec(); err != nil {
+	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	return ids, nil
+}
+
+func (s *Storage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.sqlite.GetURL"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx, `SELECT `+urlColumns+` FROM url WHERE alias = ?`, alias))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+		}
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return u, nil
+}
+
+// TakeClick spends a click of a link with MaxClicks and returns the link as it is after that,
+// ErrURLExhausted once there are none left. links without a limit come back untouched.
+// the check and the decrement are one statement, so concurrent redirects can't go over the limit
+func (s *Storage) TakeClick(ctx context.Context, alias string) (storage.URL, error) {
+	const op = "storage.sqlite.TakeClick"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx, `
+		UPDATE url SET clicks_left = clicks_left - 1
+		WHERE alias = ? AND max_clicks > 0 AND clicks_left > 0
+		RETURNING `+urlColumns,
+		alias,
+	))
+	if err == nil {
+		return u, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// nothing was updated - the link is missing, has no limit or used it up
+	u, err = scanURL(s.db.QueryRowContext(ctx, `SELECT `+urlColumns+` FROM url WHERE alias = ?`, alias))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+	}
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if u.MaxClicks > 0 {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLExhausted)
+	}
+	return u, nil
+}
+
+// UpdateURL points alias to a new destination and bumps its version.
+// version 0 updates unconditionally, anything else has to match the stored version
+func (s *Storage) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	const op = "storage.sqlite.UpdateURL"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	u, err := scanURL(s.db.QueryRowContext(ctx, `
+		UPDATE url SET url = ?, host = ?, version = version + 1
+		WHERE alias = ? AND (? = '' OR owner_id = ?) AND (? = 0 OR version = ?)
+		RETURNING `+urlColumns,
+		newURL, storage.HostOf(newURL), alias, owner, owner, version, version,
+	))
+	if err == nil {
+		return u, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// nothing was updated - either there is no such alias or the version is stale
+	var exists bool
+	err = s.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM url WHERE alias = ? AND (? = '' OR owner_id = ?))`, alias, owner, owner,
+	).Scan(&exists)
+	if err != nil {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if exists {
+		return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrVersionMismatch)
+	}
+	return storage.URL{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+}
+
+func (s *Storage) DeleteURL(ctx context.Context, alias string, owner string) error {
+	const op = "storage.sqlite.DeleteURL"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM url WHERE alias = ? AND (? = '' OR owner_id = ?)`, alias, owner, owner)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrNoURLDeleted)
+	}
+	return nil
+}
+
+// DeleteURLs deletes all aliases in one transaction, if one of them doesn't exist nothing is deleted
+func (s *Storage) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	const op = "storage.sqlite.DeleteURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `DELETE FROM url WHERE alias = ? AND (? = '' OR owner_id = ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for i, alias := range aliases {
+		result, err := stmt.ExecContext(ctx, alias, owner, owner)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: err})
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if rows == 0 {
+			return fmt.Errorf("%s: %w", op, &storage.BatchError{Index: i, Err: storage.ErrNoURLDeleted})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteExpiredURLs removes every link that expired before now
+func (s *Storage) DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM url WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return rows, nil
+}
+
+// ArchiveExpiredURLs moves every link that expired before now to url_archive,
+// and its clicks to clicks_archive
+func (s *Storage) ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.ArchiveExpiredURLs"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	now = now.UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO url_archive(id, alias, url, expires_at, archived_at)
+		SELECT id, alias, url, expires_at, ? FROM url WHERE expires_at <= ?`,
+		now, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// before the delete, it cascades to the clicks
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO clicks_archive(id, url_id, clicked_at, referrer, user_agent, ip, request_id)
+		SELECT c.id, c.url_id, c.clicked_at, c.referrer, c.user_agent, c.ip, c.request_id
+		FROM clicks c JOIN url u ON u.id = c.url_id WHERE u.expires_at <= ?`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM url WHERE expires_at <= ?`, now)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return rows, nil
+}
+
+// ListURLs returns one page of links, see storage.ListFilter
+func (s *Storage) ListURLs(ctx context.Context, filter storage.ListFilter) ([]storage.URL, error) {
+	const op = "storage.sqlite.ListURLs"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	var (
+		where []string
+		args  []any
+	)
+
+	if filter.Owner != "" {
+		where = append(where, "owner_id = ?")
+		args = append(args, filter.Owner)
+	}
+	// LIKE is case-insensitive in sqlite, the prefix match must not be
+	if filter.AliasPrefix != "" {
+		where = append(where, "substr(alias, 1, length(?)) = ?")
+		args = append(args, filter.AliasPrefix, filter.AliasPrefix)
+	}
+	if filter.Host != "" {
+		where = append(where, "instr(host, ?) > 0")
+		args = append(args, strings.ToLower(filter.Host))
+	}
+	if filter.CreatedFrom != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.CreatedFrom.UTC())
+	}
+	if filter.CreatedTo != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.CreatedTo.UTC())
+	}
+	if filter.State != "" {
+		cond, err := stateCondition(filter.State)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		where = append(where, cond)
+		// every ? of the condition is now
+		now := filter.Now.UTC()
+		for range strings.Count(cond, "?") {
+			args = append(args, now)
+		}
+	}
+
+	column, err := sortColumn(filter.Sort)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	cmp, order := ">", "ASC"
+	if filter.Desc {
+		cmp, order = "<", "DESC"
+	}
+
+	if after := filter.After; after != nil {
+		switch filter.Sort {
+		case storage.SortByCreatedAt:
+			where = append(where, "(created_at, id) "+cmp+" (?, ?)")
+			args = append(args, after.CreatedAt.UTC(), after.ID)
+		case storage.SortByAlias:
+			where = append(where, "(alias, id) "+cmp+" (?, ?)")
+			args = append(args, after.Alias, after.ID)
+		default:
+			where = append(where, "id "+cmp+" ?")
+			args = append(args, after.ID)
+		}
+	}
+
+	query := `SELECT ` + urlColumns + ` FROM url`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT ?`, column, order, order)
+	args = append(args, filter.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var urls []storage.URL
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		urls = append(urls, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return urls, nil
+}
+
+// stateCondition is the WHERE of a ListState, every placeholder in it stands for now.
+// same order of checks as URL.State - an expired link is expired, whatever its not_before
+func stateCondition(state storage.ListState) (string, error) {
+	switch state {
+	case storage.StateExpired:
+		return "expires_at <= ?", nil
+	case storage.StateScheduled:
+		return "(expires_at IS NULL OR expires_at > ?) AND not_before > ?", nil
+	case storage.StateActive:
+		return "(expires_at IS NULL OR expires_at > ?) AND (not_before IS NULL OR not_before <= ?)", nil
+	default:
+		return "", fmt.Errorf("unknown state %q", state)
+	}
+}
+
+// sortColumn whitelists the ORDER BY column, it ends up in the query as is
+func sortColumn(sort storage.ListSort) (string, error) {
+	switch sort {
+	case storage.SortByID, "":
+		return "id", nil
+	case storage.SortByCreatedAt:
+		return "created_at", nil
+	case storage.SortByAlias:
+		return "alias", nil
+	default:
+		return "", fmt.Errorf("unknown sort %q", sort)
+	}
+}
+
+// scanner is either *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// insertArgs - sqlite has no now() default that compares right with our timestamps, so created_at comes from here
+func insertArgs(u storage.URL, createdAt time.Time) []any {
+	return []any{u.URL, u.Alias, utc(u.ExpiresAt), utc(u.NotBefore), createdAt, storage.HostOf(u.URL), u.OwnerID, u.RedirectCode, u.PasswordHash, u.MaxClicks, u.MaxClicks, u.ForwardPath, u.ForwardQuery, u.UTM}
+}
+
+// scanURL reads a row selected with urlColumns
+func scanURL(row scanner) (storage.URL, error) {
+	var u storage.URL
+	var expiresAt, notBefore sql.NullTime
+	if err := row.Scan(&u.ID, &u.Alias, &u.URL, &expiresAt, &notBefore, &u.CreatedAt, &u.Version, &u.OwnerID, &u.RedirectCode, &u.PasswordHash, &u.MaxClicks, &u.ClicksLeft, &u.ForwardPath, &u.ForwardQuery, &u.UTM); err != nil {
+		return storage.URL{}, err
+	}
+	if expiresAt.Valid {
+		u.ExpiresAt = &expiresAt.Time
+	}
+	if notBefore.Valid {
+		u.NotBefore = &notBefore.Time
+	}
+	return u, nil
+}
+
+// sqlite compares timestamps as text, so everything has to go in as UTC
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// SaveClicks writes a batch of clicks in one transaction.
+// clicks of links that were deleted in the meantime are skipped
+func (s *Storage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	const op = "storage.sqlite.SaveClicks"
+
+	ctx, cancel := s.timeouts.ForBatch(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// no-op after commit
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO clicks(url_id, clicked_at, referrer, user_agent, ip, request_id)
+		SELECT ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM url WHERE id = ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		_, err := stmt.ExecContext(ctx, c.URLID, c.At.UTC(), c.Referrer, c.UserAgent, c.IP, c.RequestID, c.URLID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// GetURLStats aggregates the clicks of alias, the histogram starts at since
+func (s *Storage) GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	const op = "storage.sqlite.GetURLStats"
+
+	ctx, cancel := s.timeouts.ForRead(ctx)
+	defer cancel()
+
+	stats := storage.URLStats{Alias: alias}
+
+	var urlID int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id FROM url WHERE alias = ? AND (? = '' OR owner_id = ?)`, alias, owner, owner,
+	).Scan(&urlID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.URLStats{}, fmt.Errorf("%s: %w", op, storage.ErrURLNotFound)
+		}
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM clicks WHERE url_id = ?`, urlID).Scan(&stats.Total)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// clicked_at is stored as UTC text, so the first 10 chars are the day
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT substr(clicked_at, 1, 10) AS day, count(*)
+		FROM clicks
+		WHERE url_id = ? AND clicked_at >= ?
+		GROUP BY day
+		ORDER BY day`,
+		urlID, since.UTC(),
+	)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d storage.DailyClicks
+		if err := rows.Scan(&d.Day, &d.Clicks); err != nil {
+			return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+		}
+		stats.Daily = append(stats.Daily, d)
+	}
+	if err := rows.Err(); err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats.TopReferrers, err = s.topClicks(ctx, urlID, "referrer", top)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats.TopUserAgents, err = s.topClicks(ctx, urlID, "user_agent", top)
+	if err != nil {
+		return storage.URLStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// topClicks groups clicks by column, column is never user input
+func (s *Storage) topClicks(ctx context.Context, urlID int64, column string, top int) ([]storage.Counter, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s, count(*) AS total
+		FROM clicks
+		WHERE url_id = ? AND %[1]s <> ''
+		GROUP BY %[1]s
+		ORDER BY total DESC, %[1]s
+		LIMIT ?`, column),
+		urlID, top,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []storage.Counter
+	for rows.Next() {
+		var c storage.Counter
+		if err := rows.Scan(&c.Value, &c.Clicks); err != nil {
+			return nil, err
+		}
+		counters = append(counters, c)
+	}
+	return counters, rows.Err()
+}
+
+// NextAliasID hands out ids for generated aliases, never the same one twice
+func (s *Storage) NextAliasID(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.NextAliasID"
+
+	ctx, cancel := s.timeouts.ForWrite(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO alias_seq DEFAULT VALUES`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// only the counter in sqlite_sequence matters, the rows themselves can go
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM alias_seq WHERE id < ?`, id); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
 
-	return &Storage{db: db}, nil
+	return id, nil
 }
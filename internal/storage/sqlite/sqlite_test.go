@@ -0,0 +1,111 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/sqlite"
+	"url-shortener/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	// fresh file for every run, nothing to clean up
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	version := storagetest.Migrate(t, "file://../../../migrations/sqlite", "sqlite3://"+path)
+
+	s, err := sqlite.New(path, storage.Timeouts{})
+	require.NoError(t, err)
+
+	storagetest.Run(t, s)
+
+	current, dirty, err := s.SchemaVersion(t.Context())
+	require.NoError(t, err)
+	require.False(t, dirty)
+	require.Equal(t, version, current)
+
+	require.NoError(t, s.Close())
+	require.Error(t, s.Ping(t.Context()))
+}
+
+func TestStorage_Context(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	storagetest.Migrate(t, "file://../../../migrations/sqlite", "sqlite3://"+path)
+
+	s, err := sqlite.New(path, storage.Timeouts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	_, err = s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "google"})
+	require.NoError(t, err)
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: "cancelled"})
+		require.ErrorIs(t, err, context.Canceled)
+
+		err = s.DeleteURLs(ctx, []string{"google"}, "")
+		require.ErrorIs(t, err, context.Canceled)
+
+		// neither of them got through
+		_, err = s.GetURL(t.Context(), "cancelled")
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+		_, err = s.GetURL(t.Context(), "google")
+		require.NoError(t, err)
+	})
+
+	t.Run("Read timeout", func(t *testing.T) {
+		slow, err := sqlite.New(path, storage.Timeouts{Read: time.Nanosecond})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = slow.Close() })
+
+		_, err = slow.GetURL(t.Context(), "google")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.True(t, storage.IsFailure(err))
+
+		// writes have their own timeout
+		_, err = slow.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "written"})
+		require.NoError(t, err)
+	})
+}
+
+func TestStorage_ArchiveKeepsClicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	storagetest.Migrate(t, "file://../../../migrations/sqlite", "sqlite3://"+path)
+
+	s, err := sqlite.New(path, storage.Timeouts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	expiresAt := time.Now().Add(-time.Minute)
+	id, err := s.SaveURL(t.Context(), storage.URL{URL: "https://google.com", Alias: "expired", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveClicks(t.Context(), []storage.Click{
+		{URLID: id, At: time.Now().Add(-time.Hour), UserAgent: "curl"},
+		{URLID: id, At: time.Now().Add(-2 * time.Hour), UserAgent: "firefox"},
+	}))
+
+	removed, err := s.ArchiveExpiredURLs(t.Context(), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), removed)
+
+	// the archive isn't behind any method, straight to the tables
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	var archived, left int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM clicks_archive WHERE url_id = ?`, id).Scan(&archived))
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM clicks WHERE url_id = ?`, id).Scan(&left))
+	require.Equal(t, 2, archived)
+	require.Equal(t, 0, left)
+}
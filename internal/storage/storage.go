@@ -1,6 +1,13 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
 
 var (
 	ErrURLNotFound   = errors.New("url not found")
@@ -8,4 +15,269 @@ var (
 	ErrNoURLDeleted  = errors.New("no url deleted")
 	ErrInvalidAlias  = errors.New("invalid alias")
 	ErrDatabaseError = errors.New("database error")
+	// the link was changed by someone else since the client last saw it
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	// the link had a click limit and used it up
+	ErrURLExhausted = errors.New("url has no clicks left")
+)
+
+// answers are what a storage says about the data, not problems with the storage itself
+var answers = []error{
+	ErrURLNotFound,
+	ErrUrlExists,
+	ErrNoURLDeleted,
+	ErrInvalidAlias,
+	ErrVersionMismatch,
+	ErrAPIKeyNotFound,
+	ErrURLExhausted,
+	// the caller went away, the storage is fine. a timeout on the other hand is a failure
+	context.Canceled,
+}
+
+// IsFailure reports whether err means the storage failed, not found and conflicts are answers
+func IsFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, answer := range answers {
+		if errors.Is(err, answer) {
+			return false
+		}
+	}
+	return true
+}
+
+// BatchError tells which item made a whole batch roll back,
+// errors.Is still sees the sentinel of the item through it
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// URL is a single short link as it is stored
+type URL struct {
+	ID    int64
+	Alias string
+	URL   string
+	// nil means the link never expires
+	ExpiresAt *time.Time
+	// the link doesn't redirect before this, nil means it's live right away
+	NotBefore *time.Time
+	// set by the storage on save
+	CreatedAt time.Time
+	// starts at 1 and goes up with every update
+	Version int64
+	// whoever created the link, empty for links from before owners existed
+	OwnerID string
+	// 301, 302, 307 or 308, 0 follows the server default
+	RedirectCode int
+	// bcrypt hash, the link asks for the password before redirecting. empty for open links
+	PasswordHash string
+	// how many redirects the link answers before it's gone, 0 for no limit
+	MaxClicks int64
+	// what is left of MaxClicks, set by the storage on save and spent by TakeClick
+	ClicksLeft int64
+	// the redirect appends whatever comes after /{alias} to the path of the destination
+	ForwardPath bool
+	// the redirect adds the query of the request to the destination, keys the destination has win
+	ForwardQuery bool
+	// utm_* params the redirect adds to the destination, as a query string.
+	// links tagged on save have them in URL instead
+	UTM string
+}
+
+// Protected reports whether the link asks for a password
+func (u URL) Protected() bool {
+	return u.PasswordHash != ""
+}
+
+// Expired reports whether the link is past its expiry at the given moment
+func (u URL) Expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// Scheduled reports whether the link isn't live yet at the given moment
+func (u URL) Scheduled(now time.Time) bool {
+	return u.NotBefore != nil && now.Before(*u.NotBefore)
+}
+
+// State is what ListFilter.State matches the link against
+func (u URL) State(now time.Time) ListState {
+	switch {
+	case u.Expired(now):
+		return StateExpired
+	case u.Scheduled(now):
+		return StateScheduled
+	default:
+		return StateActive
+	}
+}
+
+// APIKey is a credential for the management API.
+// the key itself is only shown once when it's minted, we keep just its hash
+type APIKey struct {
+	ID     int64
+	Name   string
+	Hash   string
+	Scopes []string
+	// links created with the key belong to this owner, several keys can share one
+	OwnerID string
+	// set by the storage on save
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	// nil while the key works
+	RevokedAt *time.Time
+}
+
+// Storage is every method a backend has, decorators (metrics, tracing) wrap all of it
+type Storage interface {
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (uint, bool, error)
+	Close() error
+
+	SaveURL(ctx context.Context, u URL) (int64, error)
+	SaveURLs(ctx context.Context, urls []URL) ([]int64, error)
+	GetURL(ctx context.Context, alias string) (URL, error)
+	TakeClick(ctx context.Context, alias string) (URL, error)
+	UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (URL, error)
+	DeleteURL(ctx context.Context, alias string, owner string) error
+	DeleteURLs(ctx context.Context, aliases []string, owner string) error
+	DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error)
+	ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error)
+	ListURLs(ctx context.Context, filter ListFilter) ([]URL, error)
+	NextAliasID(ctx context.Context) (int64, error)
+
+	SaveClicks(ctx context.Context, clicks []Click) error
+	GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (URLStats, error)
+
+	SaveAPIKey(ctx context.Context, k APIKey) (int64, error)
+	GetAPIKey(ctx context.Context, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64, at time.Time) error
+	TouchAPIKey(ctx context.Context, id int64, at time.Time) error
+}
+
+// Timeouts cap a single storage call, the deadline of the caller still applies on top.
+// zero leaves only the caller's deadline
+type Timeouts struct {
+	// lookups and lists
+	Read time.Duration
+	// a single insert, update or delete
+	Write time.Duration
+	// transactions over many rows - batches, clicks, the reaper
+	Batch time.Duration
+}
+
+func (t Timeouts) ForRead(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Read)
+}
+
+func (t Timeouts) ForWrite(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Write)
+}
+
+func (t Timeouts) ForBatch(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Batch)
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// owner arguments of storage methods limit them to links of that owner.
+// an empty owner means any owner - that's for admins, handlers never pass it for anyone else.
+// links of other owners look exactly like missing ones
+
+// ListSort is the column GET /url is sorted by, always with id as a tie-breaker
+type ListSort string
+
+const (
+	SortByID        ListSort = "id"
+	SortByCreatedAt ListSort = "created_at"
+	SortByAlias     ListSort = "alias"
 )
+
+// ListState is where a link is in its activation window
+type ListState string
+
+const (
+	// not_before is still ahead
+	StateScheduled ListState = "scheduled"
+	// past not_before, before expires_at
+	StateActive ListState = "active"
+	// past expires_at, the reaper just hasn't got to it yet
+	StateExpired ListState = "expired"
+)
+
+// ListFilter is what ListURLs should return, zero values mean no filter
+type ListFilter struct {
+	// only links of this owner, see the owner argument of DeleteURL
+	Owner       string
+	AliasPrefix string
+	// substring of the destination host, case-insensitive
+	Host string
+	// CreatedFrom is inclusive, CreatedTo is exclusive
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// only links in this state at Now
+	State ListState
+	Now   time.Time
+	Sort  ListSort
+	Desc  bool
+	// keyset pagination - only rows after this one in the chosen order
+	After *URL
+	Limit int
+}
+
+// HostOf returns the lowercased host of a destination, the column GET /url filters on
+func HostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Click is a single redirect through a short link
+type Click struct {
+	URLID     int64
+	At        time.Time
+	Referrer  string
+	UserAgent string
+	IP        string
+	RequestID string
+}
+
+// URLStats is what GET /url/{alias}/stats shows
+type URLStats struct {
+	Alias string
+	// all time, not only the histogram window
+	Total         int64
+	Daily         []DailyClicks
+	TopReferrers  []Counter
+	TopUserAgents []Counter
+}
+
+// DailyClicks is one bar of the histogram, Day is YYYY-MM-DD in UTC
+type DailyClicks struct {
+	Day    string
+	Clicks int64
+}
+
+// Counter is a value (referrer, user agent) with how many clicks it has
+type Counter struct {
+	Value  string
+	Clicks int64
+}
@@ -0,0 +1,835 @@
+// Package storagetest is the contract every storage backend has to pass,
+// so postgres and sqlite (and whatever comes next) behave the same for the handlers
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/require"
+
+	"url-shortener/internal/lib/random"
+	"url-shortener/internal/storage"
+)
+
+// Run runs the whole contract against s.
+// aliases are random so it's safe to point it at a db that already has data
+func Run(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	t.Run("Ping", func(t *testing.T) {
+		require.NoError(t, s.Ping(ctx))
+
+		_, dirty, err := s.SchemaVersion(ctx)
+		require.NoError(t, err)
+		require.False(t, dirty)
+	})
+
+	t.Run("SaveAndGet", func(t *testing.T) {
+		alias := newAlias()
+
+		id, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+		require.NotZero(t, id)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, id, got.ID)
+		require.Equal(t, alias, got.Alias)
+		require.Equal(t, "https://google.com", got.URL)
+		require.Nil(t, got.ExpiresAt)
+		require.Equal(t, int64(1), got.Version)
+	})
+
+	t.Run("NextAliasID", func(t *testing.T) {
+		first, err := s.NextAliasID(ctx)
+		require.NoError(t, err)
+		require.Positive(t, first)
+
+		second, err := s.NextAliasID(ctx)
+		require.NoError(t, err)
+		require.Greater(t, second, first)
+	})
+
+	t.Run("SaveAssignsDifferentIDs", func(t *testing.T) {
+		first, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: newAlias()})
+		require.NoError(t, err)
+
+		second, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: newAlias()})
+		require.NoError(t, err)
+
+		require.NotEqual(t, first, second)
+	})
+
+	t.Run("DuplicateAlias", func(t *testing.T) {
+		alias := newAlias()
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://yahoo.com", Alias: alias})
+		require.ErrorIs(t, err, storage.ErrUrlExists)
+
+		// the first url must survive the failed insert
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, "https://google.com", got.URL)
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		_, err := s.GetURL(ctx, newAlias())
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		alias := newAlias()
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		require.NoError(t, s.DeleteURL(ctx, alias, ""))
+
+		_, err = s.GetURL(ctx, alias)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		// alias is free again after delete
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://yahoo.com", Alias: alias})
+		require.NoError(t, err)
+	})
+
+	t.Run("DeleteMissing", func(t *testing.T) {
+		err := s.DeleteURL(ctx, newAlias(), "")
+		require.ErrorIs(t, err, storage.ErrNoURLDeleted)
+	})
+
+	t.Run("SaveBatch", func(t *testing.T) {
+		urls := []storage.URL{
+			{URL: "https://google.com", Alias: newAlias()},
+			{URL: "https://yahoo.com", Alias: newAlias()},
+		}
+
+		ids, err := s.SaveURLs(ctx, urls)
+		require.NoError(t, err)
+		require.Len(t, ids, 2)
+		require.NotEqual(t, ids[0], ids[1])
+
+		for i, u := range urls {
+			got, err := s.GetURL(ctx, u.Alias)
+			require.NoError(t, err)
+			require.Equal(t, ids[i], got.ID)
+			require.Equal(t, u.URL, got.URL)
+		}
+	})
+
+	t.Run("SaveBatchRollsBack", func(t *testing.T) {
+		taken := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: taken})
+		require.NoError(t, err)
+
+		fresh := newAlias()
+		_, err = s.SaveURLs(ctx, []storage.URL{
+			{URL: "https://yahoo.com", Alias: fresh},
+			{URL: "https://yahoo.com", Alias: taken},
+		})
+		require.ErrorIs(t, err, storage.ErrUrlExists)
+
+		var batchErr *storage.BatchError
+		require.True(t, errors.As(err, &batchErr))
+		require.Equal(t, 1, batchErr.Index)
+
+		// the first link went away with the transaction
+		_, err = s.GetURL(ctx, fresh)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	})
+
+	t.Run("SaveBatchDuplicateInside", func(t *testing.T) {
+		alias := newAlias()
+
+		_, err := s.SaveURLs(ctx, []storage.URL{
+			{URL: "https://google.com", Alias: alias},
+			{URL: "https://yahoo.com", Alias: alias},
+		})
+		require.ErrorIs(t, err, storage.ErrUrlExists)
+
+		_, err = s.GetURL(ctx, alias)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	})
+
+	t.Run("DeleteBatch", func(t *testing.T) {
+		aliases := []string{newAlias(), newAlias()}
+		for _, alias := range aliases {
+			_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, s.DeleteURLs(ctx, aliases, ""))
+
+		for _, alias := range aliases {
+			_, err := s.GetURL(ctx, alias)
+			require.ErrorIs(t, err, storage.ErrURLNotFound)
+		}
+	})
+
+	t.Run("DeleteBatchRollsBack", func(t *testing.T) {
+		alias := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		err = s.DeleteURLs(ctx, []string{alias, newAlias()}, "")
+		require.ErrorIs(t, err, storage.ErrNoURLDeleted)
+
+		var batchErr *storage.BatchError
+		require.True(t, errors.As(err, &batchErr))
+		require.Equal(t, 1, batchErr.Index)
+
+		// the existing one is still there
+		_, err = s.GetURL(ctx, alias)
+		require.NoError(t, err)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		alias := newAlias()
+
+		id, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		updated, err := s.UpdateURL(ctx, alias, "", "https://yahoo.com", 1)
+		require.NoError(t, err)
+		require.Equal(t, id, updated.ID)
+		require.Equal(t, "https://yahoo.com", updated.URL)
+		require.Equal(t, int64(2), updated.Version)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, updated, got)
+
+		// the row is the same, so the host filter follows the new destination
+		list, err := s.ListURLs(ctx, storage.ListFilter{AliasPrefix: alias, Host: "yahoo", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+
+		// version 0 skips the check
+		updated, err = s.UpdateURL(ctx, alias, "", "https://bing.com", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), updated.Version)
+	})
+
+	t.Run("UpdateStaleVersion", func(t *testing.T) {
+		alias := newAlias()
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		_, err = s.UpdateURL(ctx, alias, "", "https://yahoo.com", 5)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		// nothing changed
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, "https://google.com", got.URL)
+		require.Equal(t, int64(1), got.Version)
+	})
+
+	t.Run("UpdateMissing", func(t *testing.T) {
+		_, err := s.UpdateURL(ctx, newAlias(), "", "https://yahoo.com", 0)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		_, err = s.UpdateURL(ctx, newAlias(), "", "https://yahoo.com", 1)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	})
+
+	t.Run("ExpiresAt", func(t *testing.T) {
+		alias := newAlias()
+		// postgres keeps microseconds, whole seconds are safe everywhere
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.NotNil(t, got.ExpiresAt)
+		require.True(t, expiresAt.Equal(*got.ExpiresAt), "want %s, got %s", expiresAt, *got.ExpiresAt)
+		require.False(t, got.Expired(time.Now()))
+		require.True(t, got.Expired(expiresAt))
+	})
+
+	t.Run("NotBefore", func(t *testing.T) {
+		alias := newAlias()
+		notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, NotBefore: &notBefore})
+		require.NoError(t, err)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.NotNil(t, got.NotBefore)
+		require.True(t, notBefore.Equal(*got.NotBefore), "want %s, got %s", notBefore, *got.NotBefore)
+		require.True(t, got.Scheduled(time.Now()))
+		require.False(t, got.Scheduled(notBefore))
+	})
+
+	t.Run("DeleteExpired", func(t *testing.T) {
+		expired, live, forever := saveExpiring(ctx, t, s)
+
+		removed, err := s.DeleteExpiredURLs(ctx, time.Now())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, removed, int64(1))
+
+		_, err = s.GetURL(ctx, expired)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		_, err = s.GetURL(ctx, live)
+		require.NoError(t, err)
+
+		_, err = s.GetURL(ctx, forever)
+		require.NoError(t, err)
+	})
+
+	t.Run("ArchiveExpired", func(t *testing.T) {
+		expired, live, forever := saveExpiring(ctx, t, s)
+
+		removed, err := s.ArchiveExpiredURLs(ctx, time.Now())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, removed, int64(1))
+
+		_, err = s.GetURL(ctx, expired)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		_, err = s.GetURL(ctx, live)
+		require.NoError(t, err)
+
+		_, err = s.GetURL(ctx, forever)
+		require.NoError(t, err)
+
+		// the alias is free once archived
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: expired})
+		require.NoError(t, err)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		alias := newAlias()
+
+		id, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		now := time.Now().UTC()
+		yesterday := now.AddDate(0, 0, -1)
+		longAgo := now.AddDate(0, 0, -30)
+
+		err = s.SaveClicks(ctx, []storage.Click{
+			{URLID: id, At: now, Referrer: "https://a.com", UserAgent: "curl", IP: "1.1.1.1", RequestID: "1"},
+			{URLID: id, At: now, Referrer: "https://a.com", UserAgent: "firefox"},
+			{URLID: id, At: yesterday, Referrer: "https://b.com", UserAgent: "curl"},
+			// counts for the total but is outside of the histogram window
+			{URLID: id, At: longAgo},
+		})
+		require.NoError(t, err)
+
+		stats, err := s.GetURLStats(ctx, alias, "", now.AddDate(0, 0, -7), 10)
+		require.NoError(t, err)
+
+		require.Equal(t, alias, stats.Alias)
+		require.Equal(t, int64(4), stats.Total)
+		require.Equal(t, []storage.DailyClicks{
+			{Day: yesterday.Format(time.DateOnly), Clicks: 1},
+			{Day: now.Format(time.DateOnly), Clicks: 2},
+		}, stats.Daily)
+		require.Equal(t, []storage.Counter{
+			{Value: "https://a.com", Clicks: 2},
+			{Value: "https://b.com", Clicks: 1},
+		}, stats.TopReferrers)
+		require.Equal(t, []storage.Counter{
+			{Value: "curl", Clicks: 2},
+			{Value: "firefox", Clicks: 1},
+		}, stats.TopUserAgents)
+
+		// top limits the lists
+		stats, err = s.GetURLStats(ctx, alias, "", now.AddDate(0, 0, -7), 1)
+		require.NoError(t, err)
+		require.Len(t, stats.TopReferrers, 1)
+		require.Len(t, stats.TopUserAgents, 1)
+	})
+
+	t.Run("StatsMissing", func(t *testing.T) {
+		_, err := s.GetURLStats(ctx, newAlias(), "", time.Now(), 10)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+	})
+
+	t.Run("ClicksOfDeletedURL", func(t *testing.T) {
+		alias := newAlias()
+
+		id, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteURL(ctx, alias, ""))
+
+		// the batcher may flush after the link is gone, that's not an error
+		require.NoError(t, s.SaveClicks(ctx, []storage.Click{{URLID: id, At: time.Now()}}))
+
+		// and a new link under the same alias starts from zero
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias})
+		require.NoError(t, err)
+
+		stats, err := s.GetURLStats(ctx, alias, "", time.Now().AddDate(0, 0, -1), 10)
+		require.NoError(t, err)
+		require.Zero(t, stats.Total)
+	})
+
+	t.Run("RedirectCode", func(t *testing.T) {
+		permanent := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: permanent, RedirectCode: 301})
+		require.NoError(t, err)
+
+		batched := newAlias()
+		_, err = s.SaveURLs(ctx, []storage.URL{{URL: "https://google.com", Alias: batched, RedirectCode: 308}})
+		require.NoError(t, err)
+
+		plain := newAlias()
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: plain})
+		require.NoError(t, err)
+
+		for alias, code := range map[string]int{permanent: 301, batched: 308, plain: 0} {
+			got, err := s.GetURL(ctx, alias)
+			require.NoError(t, err)
+			require.Equal(t, code, got.RedirectCode)
+		}
+	})
+
+	t.Run("Forwarding", func(t *testing.T) {
+		both := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: both, ForwardPath: true, ForwardQuery: true})
+		require.NoError(t, err)
+
+		query := newAlias()
+		_, err = s.SaveURLs(ctx, []storage.URL{{URL: "https://google.com", Alias: query, ForwardQuery: true}})
+		require.NoError(t, err)
+
+		plain := newAlias()
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: plain})
+		require.NoError(t, err)
+
+		for alias, want := range map[string][2]bool{both: {true, true}, query: {false, true}, plain: {false, false}} {
+			got, err := s.GetURL(ctx, alias)
+			require.NoError(t, err)
+			require.Equal(t, want, [2]bool{got.ForwardPath, got.ForwardQuery}, alias)
+		}
+	})
+
+	t.Run("UTM", func(t *testing.T) {
+		alias := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, UTM: "utm_source=news&utm_campaign=spring+sale"})
+		require.NoError(t, err)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, "utm_source=news&utm_campaign=spring+sale", got.UTM)
+	})
+
+	t.Run("PasswordHash", func(t *testing.T) {
+		protected := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: protected, PasswordHash: "$2a$10$hash"})
+		require.NoError(t, err)
+
+		batched := newAlias()
+		_, err = s.SaveURLs(ctx, []storage.URL{{URL: "https://google.com", Alias: batched, PasswordHash: "$2a$10$other"}})
+		require.NoError(t, err)
+
+		open := newAlias()
+		_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: open})
+		require.NoError(t, err)
+
+		for alias, hash := range map[string]string{protected: "$2a$10$hash", batched: "$2a$10$other", open: ""} {
+			got, err := s.GetURL(ctx, alias)
+			require.NoError(t, err)
+			require.Equal(t, hash, got.PasswordHash)
+		}
+
+		// an update changes the destination, not the password
+		updated, err := s.UpdateURL(ctx, protected, "", "https://yahoo.com", 0)
+		require.NoError(t, err)
+		require.Equal(t, "$2a$10$hash", updated.PasswordHash)
+	})
+
+	t.Run("MaxClicks", func(t *testing.T) {
+		alias := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, MaxClicks: 2})
+		require.NoError(t, err)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), got.MaxClicks)
+		require.Equal(t, int64(2), got.ClicksLeft)
+
+		taken, err := s.TakeClick(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), taken.ClicksLeft)
+		require.Equal(t, "https://google.com", taken.URL)
+
+		_, err = s.TakeClick(ctx, alias)
+		require.NoError(t, err)
+
+		_, err = s.TakeClick(ctx, alias)
+		require.ErrorIs(t, err, storage.ErrURLExhausted)
+
+		// the link is still there, it just doesn't redirect anymore
+		got, err = s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Zero(t, got.ClicksLeft)
+
+		_, err = s.TakeClick(ctx, newAlias())
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+		// no limit, nothing to take
+		unlimited := newAlias()
+		_, err = s.SaveURLs(ctx, []storage.URL{{URL: "https://google.com", Alias: unlimited}})
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			taken, err := s.TakeClick(ctx, unlimited)
+			require.NoError(t, err)
+			require.Zero(t, taken.MaxClicks)
+		}
+	})
+
+	t.Run("MaxClicksConcurrent", func(t *testing.T) {
+		const limit, visitors = 5, 20
+
+		alias := newAlias()
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, MaxClicks: limit})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		results := make(chan error, visitors)
+		for i := 0; i < visitors; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.TakeClick(ctx, alias)
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		var ok, exhausted int
+		for err := range results {
+			switch {
+			case err == nil:
+				ok++
+			case errors.Is(err, storage.ErrURLExhausted):
+				exhausted++
+			default:
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, limit, ok)
+		require.Equal(t, visitors-limit, exhausted)
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		alias := newAlias()
+		alice, bob := "alice-"+newAlias(), "bob-"+newAlias()
+
+		_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: alias, OwnerID: alice})
+		require.NoError(t, err)
+
+		got, err := s.GetURL(ctx, alias)
+		require.NoError(t, err)
+		require.Equal(t, alice, got.OwnerID)
+
+		// somebody else's link looks like a missing one
+		_, err = s.UpdateURL(ctx, alias, bob, "https://yahoo.com", 0)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+		_, err = s.UpdateURL(ctx, alias, bob, "https://yahoo.com", 1)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+		_, err = s.GetURLStats(ctx, alias, bob, time.Now(), 10)
+		require.ErrorIs(t, err, storage.ErrURLNotFound)
+		require.ErrorIs(t, s.DeleteURL(ctx, alias, bob), storage.ErrNoURLDeleted)
+		require.ErrorIs(t, s.DeleteURLs(ctx, []string{alias}, bob), storage.ErrNoURLDeleted)
+
+		list, err := s.ListURLs(ctx, storage.ListFilter{Owner: bob, Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, list)
+
+		list, err = s.ListURLs(ctx, storage.ListFilter{Owner: alice, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, alias, list[0].Alias)
+
+		// the owner can do all of it
+		_, err = s.UpdateURL(ctx, alias, alice, "https://yahoo.com", 1)
+		require.NoError(t, err)
+		_, err = s.GetURLStats(ctx, alias, alice, time.Now(), 10)
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteURL(ctx, alias, alice))
+	})
+
+	t.Run("OwnerBatch", func(t *testing.T) {
+		alice := "alice-" + newAlias()
+		mine, theirs := newAlias(), newAlias()
+
+		_, err := s.SaveURLs(ctx, []storage.URL{
+			{URL: "https://google.com", Alias: mine, OwnerID: alice},
+			{URL: "https://google.com", Alias: theirs, OwnerID: "bob-" + newAlias()},
+		})
+		require.NoError(t, err)
+
+		// one foreign alias and nothing is deleted
+		err = s.DeleteURLs(ctx, []string{mine, theirs}, alice)
+		require.ErrorIs(t, err, storage.ErrNoURLDeleted)
+
+		_, err = s.GetURL(ctx, mine)
+		require.NoError(t, err)
+
+		require.NoError(t, s.DeleteURLs(ctx, []string{mine}, alice))
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		hash := newAlias() + newAlias()
+
+		id, err := s.SaveAPIKey(ctx, storage.APIKey{Name: "ci", Hash: hash, Scopes: []string{"read", "write"}, OwnerID: "team"})
+		require.NoError(t, err)
+		require.NotZero(t, id)
+
+		k, err := s.GetAPIKey(ctx, hash)
+		require.NoError(t, err)
+		require.Equal(t, id, k.ID)
+		require.Equal(t, "ci", k.Name)
+		require.Equal(t, []string{"read", "write"}, k.Scopes)
+		require.Equal(t, "team", k.OwnerID)
+		require.False(t, k.CreatedAt.IsZero())
+		require.Nil(t, k.LastUsedAt)
+		require.Nil(t, k.RevokedAt)
+
+		usedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+		require.NoError(t, s.TouchAPIKey(ctx, id, usedAt))
+
+		k, err = s.GetAPIKey(ctx, hash)
+		require.NoError(t, err)
+		require.NotNil(t, k.LastUsedAt)
+		require.True(t, usedAt.Equal(*k.LastUsedAt))
+
+		keys, err := s.ListAPIKeys(ctx)
+		require.NoError(t, err)
+		require.Contains(t, ids(keys), id)
+	})
+
+	t.Run("RevokeAPIKey", func(t *testing.T) {
+		hash := newAlias() + newAlias()
+
+		id, err := s.SaveAPIKey(ctx, storage.APIKey{Name: "ci", Hash: hash})
+		require.NoError(t, err)
+
+		revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, s.RevokeAPIKey(ctx, id, revokedAt))
+		// a second revoke doesn't move the timestamp
+		require.NoError(t, s.RevokeAPIKey(ctx, id, time.Now()))
+
+		k, err := s.GetAPIKey(ctx, hash)
+		require.NoError(t, err)
+		require.NotNil(t, k.RevokedAt)
+		require.True(t, revokedAt.Equal(*k.RevokedAt))
+		require.Empty(t, k.Scopes)
+	})
+
+	t.Run("APIKeyMissing", func(t *testing.T) {
+		_, err := s.GetAPIKey(ctx, newAlias())
+		require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+		err = s.RevokeAPIKey(ctx, 1<<40, time.Now())
+		require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		// everything is scoped by a random prefix, the db may have other links
+		prefix := strings.ToLower(random.NewRandomString(8))
+		aliases := []string{prefix + "c", prefix + "a", prefix + "e", prefix + "b", prefix + "d"}
+		destinations := []string{
+			"https://google.com/search",
+			"https://Example.com/a",
+			"https://yahoo.com",
+			"https://user@sub.example.com:8080/x?y=z",
+			"https://google.com",
+		}
+		for i, alias := range aliases {
+			_, err := s.SaveURL(ctx, storage.URL{URL: destinations[i], Alias: alias})
+			require.NoError(t, err)
+		}
+
+		t.Run("ByIDAscending", func(t *testing.T) {
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Limit: 2})
+			require.Equal(t, aliases, got)
+		})
+
+		t.Run("ByIDDescending", func(t *testing.T) {
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Desc: true, Limit: 2})
+			require.Equal(t, []string{aliases[4], aliases[3], aliases[2], aliases[1], aliases[0]}, got)
+		})
+
+		t.Run("ByAlias", func(t *testing.T) {
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Sort: storage.SortByAlias, Limit: 2})
+			require.Equal(t, []string{prefix + "a", prefix + "b", prefix + "c", prefix + "d", prefix + "e"}, got)
+
+			got = listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Sort: storage.SortByAlias, Desc: true, Limit: 3})
+			require.Equal(t, []string{prefix + "e", prefix + "d", prefix + "c", prefix + "b", prefix + "a"}, got)
+		})
+
+		t.Run("ByCreatedAt", func(t *testing.T) {
+			// saved one after another, ties are broken by id
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Sort: storage.SortByCreatedAt, Limit: 2})
+			require.Equal(t, aliases, got)
+		})
+
+		t.Run("ByHost", func(t *testing.T) {
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, Host: "EXAMPLE.com", Limit: 10})
+			require.Equal(t, []string{aliases[1], aliases[3]}, got)
+		})
+
+		t.Run("PrefixIsCaseSensitive", func(t *testing.T) {
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: strings.ToUpper(prefix), Limit: 10})
+			require.Empty(t, got)
+		})
+
+		t.Run("ByCreatedRange", func(t *testing.T) {
+			hourAgo := time.Now().Add(-time.Hour)
+			inHour := time.Now().Add(time.Hour)
+
+			got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, CreatedFrom: &hourAgo, CreatedTo: &inHour, Limit: 10})
+			require.Equal(t, aliases, got)
+
+			got = listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, CreatedFrom: &inHour, Limit: 10})
+			require.Empty(t, got)
+
+			got = listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, CreatedTo: &hourAgo, Limit: 10})
+			require.Empty(t, got)
+		})
+
+		t.Run("Fields", func(t *testing.T) {
+			got, err := s.ListURLs(ctx, storage.ListFilter{AliasPrefix: aliases[0], Limit: 10})
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+			require.Equal(t, destinations[0], got[0].URL)
+			require.WithinDuration(t, time.Now(), got[0].CreatedAt, time.Minute)
+		})
+
+		t.Run("UnknownSort", func(t *testing.T) {
+			_, err := s.ListURLs(ctx, storage.ListFilter{Sort: "url; DROP TABLE url", Limit: 10})
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("ListState", func(t *testing.T) {
+		prefix := strings.ToLower(random.NewRandomString(8))
+		now := time.Now().Truncate(time.Second)
+		hourAgo, inHour := now.Add(-time.Hour), now.Add(time.Hour)
+
+		links := []storage.URL{
+			{Alias: prefix + "live"},
+			{Alias: prefix + "started", NotBefore: &hourAgo, ExpiresAt: &inHour},
+			{Alias: prefix + "soon", NotBefore: &inHour},
+			{Alias: prefix + "gone", ExpiresAt: &hourAgo},
+			// never went live, but it's over all the same
+			{Alias: prefix + "missed", NotBefore: &inHour, ExpiresAt: &hourAgo},
+		}
+		for _, u := range links {
+			u.URL = "https://google.com"
+			_, err := s.SaveURL(ctx, u)
+			require.NoError(t, err)
+		}
+
+		list := func(state storage.ListState) []string {
+			return listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix, State: state, Now: now, Limit: 10})
+		}
+
+		require.Equal(t, []string{prefix + "live", prefix + "started"}, list(storage.StateActive))
+		require.Equal(t, []string{prefix + "soon"}, list(storage.StateScheduled))
+		require.Equal(t, []string{prefix + "gone", prefix + "missed"}, list(storage.StateExpired))
+		require.Len(t, list(""), len(links))
+
+		// the same link is active once its time has come
+		got := listAll(ctx, t, s, storage.ListFilter{AliasPrefix: prefix + "soon", State: storage.StateActive, Now: inHour, Limit: 10})
+		require.Equal(t, []string{prefix + "soon"}, got)
+
+		_, err := s.ListURLs(ctx, storage.ListFilter{State: "paused", Limit: 10})
+		require.Error(t, err)
+	})
+}
+
+// Migrate applies the migrations from sourceURL (file://...) to databaseURL,
+// the database driver has to be imported by the caller. returns the version it ended up at
+func Migrate(t *testing.T, sourceURL, databaseURL string) uint {
+	t.Helper()
+
+	m, err := migrate.New(sourceURL, databaseURL)
+	require.NoError(t, err)
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		require.NoError(t, err)
+	}
+
+	version, _, err := m.Version()
+	require.NoError(t, err)
+
+	return version
+}
+
+// listAll walks every page of filter and returns the aliases in order
+func listAll(ctx context.Context, t *testing.T, s storage.Storage, filter storage.ListFilter) []string {
+	t.Helper()
+
+	var aliases []string
+	for {
+		page, err := s.ListURLs(ctx, filter)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), filter.Limit)
+
+		for _, u := range page {
+			aliases = append(aliases, u.Alias)
+		}
+		if len(page) < filter.Limit {
+			return aliases
+		}
+
+		last := page[len(page)-1]
+		filter.After = &last
+	}
+}
+
+// saveExpiring saves one link that is already expired, one that expires later and one that never does
+func saveExpiring(ctx context.Context, t *testing.T, s storage.Storage) (expired, live, forever string) {
+	t.Helper()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	expired, live, forever = newAlias(), newAlias(), newAlias()
+
+	_, err := s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: expired, ExpiresAt: &past})
+	require.NoError(t, err)
+
+	_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: live, ExpiresAt: &future})
+	require.NoError(t, err)
+
+	_, err = s.SaveURL(ctx, storage.URL{URL: "https://google.com", Alias: forever})
+	require.NoError(t, err)
+
+	return expired, live, forever
+}
+
+func newAlias() string {
+	return random.NewRandomString(12)
+}
+
+func ids(keys []storage.APIKey) []int64 {
+	ids := make([]int64, 0, len(keys))
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"context"
+	"time"
+	"url-shortener/internal/storage"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapStorage gives every storage call a span under the span of ctx,
+// so queries show up inside the request that made them
+func WrapStorage(s storage.Storage) storage.Storage {
+	return &tracedStorage{next: s}
+}
+
+type tracedStorage struct {
+	next storage.Storage
+}
+
+func (s *tracedStorage) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return Start(ctx, "storage."+method, trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// end marks failures, not found and conflicts are answers and leave the span ok
+func end(span trace.Span, err error) {
+	if storage.IsFailure(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStorage) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.next.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	ctx, span := s.start(ctx, "SchemaVersion")
+	version, dirty, err := s.next.SchemaVersion(ctx)
+	end(span, err)
+	return version, dirty, err
+}
+
+// Close isn't traced, it happens once on shutdown
+func (s *tracedStorage) Close() error {
+	return s.next.Close()
+}
+
+func (s *tracedStorage) SaveURL(ctx context.Context, u storage.URL) (int64, error) {
+	ctx, span := s.start(ctx, "SaveURL")
+	id, err := s.next.SaveURL(ctx, u)
+	end(span, err)
+	return id, err
+}
+
+func (s *tracedStorage) SaveURLs(ctx context.Context, urls []storage.URL) ([]int64, error) {
+	ctx, span := s.start(ctx, "SaveURLs")
+	ids, err := s.next.SaveURLs(ctx, urls)
+	end(span, err)
+	return ids, err
+}
+
+func (s *tracedStorage) GetURL(ctx context.Context, alias string) (storage.URL, error) {
+	ctx, span := s.start(ctx, "GetURL")
+	u, err := s.next.GetURL(ctx, alias)
+	end(span, err)
+	return u, err
+}
+
+func (s *tracedStorage) TakeClick(ctx context.Context, alias string) (storage.URL, error) {
+	ctx, span := s.start(ctx, "TakeClick")
+	u, err := s.next.TakeClick(ctx, alias)
+	end(span, err)
+	return u, err
+}
+
+func (s *tracedStorage) UpdateURL(ctx context.Context, alias string, owner string, newURL string, version int64) (storage.URL, error) {
+	ctx, span := s.start(ctx, "UpdateURL")
+	u, err := s.next.UpdateURL(ctx, alias, owner, newURL, version)
+	end(span, err)
+	return u, err
+}
+
+func (s *tracedStorage) DeleteURL(ctx context.Context, alias string, owner string) error {
+	ctx, span := s.start(ctx, "DeleteURL")
+	err := s.next.DeleteURL(ctx, alias, owner)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteURLs(ctx context.Context, aliases []string, owner string) error {
+	ctx, span := s.start(ctx, "DeleteURLs")
+	err := s.next.DeleteURLs(ctx, aliases, owner)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "DeleteExpiredURLs")
+	n, err := s.next.DeleteExpiredURLs(ctx, now)
+	end(span, err)
+	return n, err
+}
+
+func (s *tracedStorage) ArchiveExpiredURLs(ctx context.Context, now time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "ArchiveExpiredURLs")
+	n, err := s.next.ArchiveExpiredURLs(ctx, now)
+	end(span, err)
+	return n, err
+}
+
+func (s *tracedStorage) ListURLs(ctx context.Context, filter storage.ListFilter) ([]storage.URL, error) {
+	ctx, span := s.start(ctx, "ListURLs")
+	urls, err := s.next.ListURLs(ctx, filter)
+	end(span, err)
+	return urls, err
+}
+
+func (s *tracedStorage) NextAliasID(ctx context.Context) (int64, error) {
+	ctx, span := s.start(ctx, "NextAliasID")
+	id, err := s.next.NextAliasID(ctx)
+	end(span, err)
+	return id, err
+}
+
+func (s *tracedStorage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	ctx, span := s.start(ctx, "SaveClicks")
+	err := s.next.SaveClicks(ctx, clicks)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetURLStats(ctx context.Context, alias string, owner string, since time.Time, top int) (storage.URLStats, error) {
+	ctx, span := s.start(ctx, "GetURLStats")
+	stats, err := s.next.GetURLStats(ctx, alias, owner, since, top)
+	end(span, err)
+	return stats, err
+}
+
+func (s *tracedStorage) SaveAPIKey(ctx context.Context, k storage.APIKey) (int64, error) {
+	ctx, span := s.start(ctx, "SaveAPIKey")
+	id, err := s.next.SaveAPIKey(ctx, k)
+	end(span, err)
+	return id, err
+}
+
+func (s *tracedStorage) GetAPIKey(ctx context.Context, hash string) (storage.APIKey, error) {
+	ctx, span := s.start(ctx, "GetAPIKey")
+	k, err := s.next.GetAPIKey(ctx, hash)
+	end(span, err)
+	return k, err
+}
+
+func (s *tracedStorage) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	ctx, span := s.start(ctx, "ListAPIKeys")
+	keys, err := s.next.ListAPIKeys(ctx)
+	end(span, err)
+	return keys, err
+}
+
+func (s *tracedStorage) RevokeAPIKey(ctx context.Context, id int64, at time.Time) error {
+	ctx, span := s.start(ctx, "RevokeAPIKey")
+	err := s.next.RevokeAPIKey(ctx, id, at)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) TouchAPIKey(ctx context.Context, id int64, at time.Time) error {
+	ctx, span := s.start(ctx, "TouchAPIKey")
+	err := s.next.TouchAPIKey(ctx, id, at)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,122 @@
+// Package tracing sets up OpenTelemetry and traces requests, handlers and storage calls
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone - spans aren't recorded, incoming traceparent still ends up in the logs
+	ExporterNone = "none"
+	// ExporterStdout - pretty printed JSON on stdout, for local runs
+	ExporterStdout = "stdout"
+	// ExporterFile - one JSON span per line appended to a file
+	ExporterFile = "file"
+	// ExporterOTLP - OTLP over HTTP to a collector, Jaeger, Tempo and so on
+	ExporterOTLP = "otlp"
+)
+
+// instrumentation is the name every span of the service is created with
+const instrumentation = "url-shortener"
+
+type Options struct {
+	Exporter string
+	// where the file exporter writes
+	Path string
+	// host:port of the OTLP/HTTP receiver, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318 when empty
+	Endpoint string
+	// plain http to the collector
+	Insecure bool
+	// share of new traces that are recorded, traces started by the caller follow its decision
+	SampleRatio float64
+	// url-shortener when empty
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and the W3C traceparent propagator.
+// shutdown flushes the buffered spans, call it after the last request is done
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	const op = "tracing.Setup"
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("%s: sample ratio must be between 0 and 1", op)
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+	)
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		f, openErr := os.OpenFile(opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if openErr != nil {
+			return nil, fmt.Errorf("%s: %w", op, openErr)
+		}
+		closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		var otlpOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			otlpOpts = append(otlpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			otlpOpts = append(otlpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, otlpOpts...)
+	default:
+		return nil, fmt.Errorf("%s: unknown exporter %q", op, opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = instrumentation
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}, nil
+}
+
+// Start opens a span under whatever span ctx has, handlers name it after their op
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
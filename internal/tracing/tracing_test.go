@@ -0,0 +1,161 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"url-shortener/internal/lib/logger/sl"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/memory"
+	"url-shortener/internal/tracing"
+)
+
+// the tracer provider is global, so none of these tests run in parallel
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+
+	// Setup installs the traceparent propagator whatever the exporter
+	_, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterNone})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/url/{alias}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "handler")
+		span.End()
+
+		log.Info("inside", sl.Trace(r.Context()))
+	})
+	router.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodGet, "/url/google", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	handler, server := spans[0], spans[1]
+	require.Equal(t, "GET /url/{alias}", server.Name())
+	require.Equal(t, traceID, server.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	require.Equal(t, server.SpanContext().SpanID(), handler.Parent().SpanID())
+	require.Equal(t, codes.Unset, server.Status().Code)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	require.Equal(t, traceID, line["trace_id"])
+	require.Equal(t, server.SpanContext().SpanID().String(), line["span_id"])
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+	spans = recorder.Ended()
+	require.Len(t, spans, 3)
+	require.Equal(t, codes.Error, spans[2].Status().Code)
+	require.NotEqual(t, traceID, spans[2].SpanContext().TraceID().String())
+}
+
+func TestTrace_NoSpan(t *testing.T) {
+	var logs bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("outside", sl.Trace(context.Background()))
+
+	require.NotContains(t, logs.String(), "trace_id")
+}
+
+// broken fails every GetURL
+type broken struct {
+	*memory.Storage
+}
+
+func (broken) GetURL(context.Context, string) (storage.URL, error) {
+	return storage.URL{}, storage.ErrDatabaseError
+}
+
+func TestWrapStorage(t *testing.T) {
+	recorder := record(t)
+
+	ctx, parent := tracing.Start(t.Context(), "request")
+
+	s := tracing.WrapStorage(memory.New())
+	_, err := s.GetURL(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	_, err = tracing.WrapStorage(broken{memory.New()}).GetURL(ctx, "google")
+	require.ErrorIs(t, err, storage.ErrDatabaseError)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	require.Equal(t, "storage.GetURL", spans[0].Name())
+	// the query hangs under the request
+	require.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(t, parent.SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	// missing is an answer, not a failure
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Len(t, spans[1].Events(), 1)
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"})
+	require.Error(t, err)
+
+	_, err = tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterStdout, SampleRatio: 2})
+	require.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    tracing.ExporterFile,
+		Path:        path,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	_, span := tracing.Start(context.Background(), "written")
+	span.End()
+
+	// spans are batched, shutdown writes them out
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Name":"written"`)
+	require.Contains(t, string(data), "url-shortener")
+}
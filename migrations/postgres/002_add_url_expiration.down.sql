@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.url_archive;
+
+DROP INDEX IF EXISTS idx_url_expires_at;
+
+ALTER TABLE public.url DROP COLUMN IF EXISTS expires_at;
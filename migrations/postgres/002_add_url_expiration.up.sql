@@ -0,0 +1,12 @@
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- the reaper only ever looks at links that can expire
+CREATE INDEX IF NOT EXISTS idx_url_expires_at ON public.url(expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS public.url_archive(
+    id          INTEGER     NOT NULL,
+    alias       TEXT        NOT NULL,
+    url         TEXT        NOT NULL,
+    expires_at  TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
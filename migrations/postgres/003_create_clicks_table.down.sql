@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.clicks;
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_url_created_at;
+
+ALTER TABLE public.url DROP COLUMN IF EXISTS host;
+
+ALTER TABLE public.url DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,10 @@
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- lowercased host of the destination, so GET /url can filter by it
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS host TEXT NOT NULL DEFAULT '';
+
+UPDATE public.url
+SET host = lower(coalesce(substring(url from '^[^:]+://(?:[^@/]*@)?([^/?#:]+)'), ''))
+WHERE host = '';
+
+CREATE INDEX IF NOT EXISTS idx_url_created_at ON public.url(created_at, id);
@@ -0,0 +1 @@
+ALTER TABLE public.url DROP COLUMN IF EXISTS version;
\ No newline at end of file
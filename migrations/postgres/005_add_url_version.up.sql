@@ -0,0 +1,2 @@
+-- bumped on every update, exposed as ETag for optimistic concurrency
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.api_keys(
+    id           BIGSERIAL PRIMARY KEY,
+    name         TEXT        NOT NULL,
+    key_hash     TEXT        NOT NULL UNIQUE,
+    -- space separated, like oauth scopes
+    scopes       TEXT        NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
@@ -0,0 +1,4 @@
+ALTER TABLE public.api_keys DROP COLUMN IF EXISTS owner_id;
+
+DROP INDEX IF EXISTS idx_url_owner_id;
+ALTER TABLE public.url DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,7 @@
+-- links from before owners existed stay unowned, only admins see them
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_url_owner_id ON public.url(owner_id, id);
+
+-- every existing key owns its own links
+ALTER TABLE public.api_keys ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '';
+UPDATE public.api_keys SET owner_id = name WHERE owner_id = '';
@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS public.alias_seq;
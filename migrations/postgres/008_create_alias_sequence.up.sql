@@ -0,0 +1,3 @@
+-- ids for the sequence and obfuscated alias strategies, separate from url.id
+-- because the alias has to be known before the row is inserted
+CREATE SEQUENCE IF NOT EXISTS public.alias_seq;
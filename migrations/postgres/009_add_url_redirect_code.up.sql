@@ -0,0 +1,2 @@
+-- 0 follows the server default, so existing links keep redirecting with 302
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS redirect_code SMALLINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE public.url DROP COLUMN IF EXISTS password_hash;
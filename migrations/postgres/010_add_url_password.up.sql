@@ -0,0 +1,2 @@
+-- bcrypt hash of the password the link asks for before redirecting, empty for open links
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
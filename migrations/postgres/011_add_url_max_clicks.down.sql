@@ -0,0 +1,2 @@
+ALTER TABLE public.url DROP COLUMN IF EXISTS clicks_left;
+ALTER TABLE public.url DROP COLUMN IF EXISTS max_clicks;
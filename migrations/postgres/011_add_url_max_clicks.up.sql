@@ -0,0 +1,3 @@
+-- 0 is no limit, clicks_left counts down to 0 for links that have one
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS max_clicks BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS clicks_left BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE public.url DROP COLUMN IF EXISTS not_before;
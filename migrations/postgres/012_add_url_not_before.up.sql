@@ -0,0 +1,2 @@
+-- the link only redirects from this moment on, NULL means right away
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
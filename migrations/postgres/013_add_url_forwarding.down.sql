@@ -0,0 +1,2 @@
+ALTER TABLE public.url DROP COLUMN IF EXISTS forward_query;
+ALTER TABLE public.url DROP COLUMN IF EXISTS forward_path;
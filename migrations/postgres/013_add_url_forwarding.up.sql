@@ -0,0 +1,3 @@
+-- the redirect appends the rest of the request path and merges in its query
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS forward_path BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE public.url ADD COLUMN IF NOT EXISTS forward_query BOOLEAN NOT NULL DEFAULT false;
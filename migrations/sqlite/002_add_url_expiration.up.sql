@@ -0,0 +1,11 @@
+ALTER TABLE url ADD COLUMN expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_url_expires_at ON url(expires_at);
+
+CREATE TABLE IF NOT EXISTS url_archive(
+    id          INTEGER   NOT NULL,
+    alias       TEXT      NOT NULL,
+    url         TEXT      NOT NULL,
+    expires_at  TIMESTAMP,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_url_created_at;
+
+ALTER TABLE url DROP COLUMN host;
+
+ALTER TABLE url DROP COLUMN created_at;
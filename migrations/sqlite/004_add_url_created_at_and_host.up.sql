@@ -0,0 +1,17 @@
+-- sqlite can't add a column with a non-constant default, the storage always sets it on insert
+ALTER TABLE url ADD COLUMN created_at TIMESTAMP;
+
+UPDATE url SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+
+-- lowercased host of the destination, so GET /url can filter by it
+ALTER TABLE url ADD COLUMN host TEXT NOT NULL DEFAULT '';
+
+-- no regex in sqlite, so cut the host out step by step: scheme, path, query, fragment, userinfo, port
+UPDATE url SET host = lower(substr(url, instr(url, '://') + 3)) WHERE host = '' AND instr(url, '://') > 0;
+UPDATE url SET host = substr(host, 1, instr(host, '/') - 1) WHERE instr(host, '/') > 0;
+UPDATE url SET host = substr(host, 1, instr(host, '?') - 1) WHERE instr(host, '?') > 0;
+UPDATE url SET host = substr(host, 1, instr(host, '#') - 1) WHERE instr(host, '#') > 0;
+UPDATE url SET host = substr(host, instr(host, '@') + 1) WHERE instr(host, '@') > 0;
+UPDATE url SET host = substr(host, 1, instr(host, ':') - 1) WHERE instr(host, ':') > 0;
+
+CREATE INDEX IF NOT EXISTS idx_url_created_at ON url(created_at, id);
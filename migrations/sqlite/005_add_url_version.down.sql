@@ -0,0 +1 @@
+ALTER TABLE url DROP COLUMN version;
\ No newline at end of file
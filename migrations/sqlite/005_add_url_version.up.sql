@@ -0,0 +1,2 @@
+-- bumped on every update, exposed as ETag for optimistic concurrency
+ALTER TABLE url ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP COLUMN owner_id;
+
+DROP INDEX IF EXISTS idx_url_owner_id;
+ALTER TABLE url DROP COLUMN owner_id;
@@ -0,0 +1,4 @@
+-- sqlite has no sequences, AUTOINCREMENT never hands out an id twice even after rows are deleted
+CREATE TABLE IF NOT EXISTS alias_seq(
+    id INTEGER PRIMARY KEY AUTOINCREMENT
+);
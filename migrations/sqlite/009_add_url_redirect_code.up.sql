@@ -0,0 +1,2 @@
+-- 0 follows the server default, so existing links keep redirecting with 302
+ALTER TABLE url ADD COLUMN redirect_code INTEGER NOT NULL DEFAULT 0;
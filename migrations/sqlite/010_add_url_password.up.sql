@@ -0,0 +1,2 @@
+-- bcrypt hash of the password the link asks for before redirecting, empty for open links
+ALTER TABLE url ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- 0 is no limit, clicks_left counts down to 0 for links that have one
+ALTER TABLE url ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE url ADD COLUMN clicks_left INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- the link only redirects from this moment on, NULL means right away
+ALTER TABLE url ADD COLUMN not_before TIMESTAMP;
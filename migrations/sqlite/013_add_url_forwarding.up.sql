@@ -0,0 +1,3 @@
+-- the redirect appends the rest of the request path and merges in its query
+ALTER TABLE url ADD COLUMN forward_path BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE url ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT 0;
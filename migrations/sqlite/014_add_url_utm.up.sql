@@ -0,0 +1,2 @@
+-- utm_* params the redirect adds to the destination, as a query string. empty for everything else
+ALTER TABLE url ADD COLUMN utm TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+-- clicks of archived links, deleting the link would cascade to them otherwise
+CREATE TABLE IF NOT EXISTS clicks_archive(
+    id         INTEGER   NOT NULL,
+    url_id     INTEGER   NOT NULL,
+    clicked_at TIMESTAMP NOT NULL,
+    referrer   TEXT      NOT NULL DEFAULT '',
+    user_agent TEXT      NOT NULL DEFAULT '',
+    ip         TEXT      NOT NULL DEFAULT '',
+    request_id TEXT      NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_clicks_archive_url_id ON clicks_archive(url_id);
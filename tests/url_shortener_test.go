@@ -1,23 +1,85 @@
 package tests
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/gavv/httpexpect/v2"
 	"github.com/stretchr/testify/require"
 
+	"url-shortener/internal/clicks"
+	"url-shortener/internal/config"
 	"url-shortener/internal/http-server/handlers/url/save"
+	"url-shortener/internal/http-server/router"
+	"url-shortener/internal/lib/alias"
 	"url-shortener/internal/lib/api"
+	"url-shortener/internal/lib/logger/handlers/slogdiscard"
+	"url-shortener/internal/lib/password"
 	"url-shortener/internal/lib/random"
+	"url-shortener/internal/lib/urlpolicy"
+	"url-shortener/internal/lib/utm"
+	"url-shortener/internal/metrics"
+	"url-shortener/internal/storage"
+	"url-shortener/internal/storage/cache"
+	"url-shortener/internal/storage/memory"
 )
 
-const (
-	host = "localhost:8082"
-)
+// host is replaced by the in-process server address in TestMain
+var host = "localhost:8082"
+
+// by default the suite spins up the real router with memory storage,
+// set E2E_HOST=localhost:8082 to run it against an already running server instead
+func TestMain(m *testing.M) {
+	if h := os.Getenv("E2E_HOST"); h != "" {
+		host = h
+		os.Exit(m.Run())
+	}
+
+	configuration := &config.Config{
+		HTTPServer: config.HTTPServer{
+			User:     "myuser",
+			Password: "mypass",
+		},
+		Alias:    config.Alias{Attempts: 5},
+		Redirect: config.Redirect{Code: http.StatusFound},
+	}
+
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clickRecorder := clicks.New(log, storage, 1024, 100, 10*time.Millisecond)
+	go clickRecorder.Run(ctx)
+
+	// a long negative ttl, so a save that forgets to invalidate shows up as a 404
+	urlCache, err := cache.New(storage, cache.Options{Size: 1000, TTL: time.Hour, NegativeTTL: time.Hour})
+	if err != nil {
+		panic(err)
+	}
+
+	urlPolicy, err := urlpolicy.New(urlpolicy.Options{})
+	if err != nil {
+		panic(err)
+	}
+
+	srv := httptest.NewServer(router.New(log, configuration, storage, urlCache, clickRecorder, alias.Random{Length: 6}, urlPolicy, nil, nil, metrics.New(), 0))
+	host = strings.TrimPrefix(srv.URL, "http://")
+
+	code := m.Run()
+
+	srv.Close()
+	cancel()
+	os.Exit(code)
+}
 
 func TestURLShortener_HappyPath(t *testing.T) {
 	u := url.URL{
@@ -187,6 +249,831 @@ func TestURLShortener_DuplicateAlias(t *testing.T) {
 		Status(http.StatusOK)
 }
 
+// clicks are written in the background, so the stats catch up eventually
+func TestURLShortener_Stats(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	urlToSave := gofakeit.URL()
+
+	e.POST("/url").
+		WithJSON(save.Request{
+			URL:   urlToSave,
+			Alias: alias,
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	testRedirect(t, alias, urlToSave)
+	testRedirect(t, alias, urlToSave)
+
+	require.Eventually(t, func() bool {
+		total := e.GET("/url/"+alias+"/stats").
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().
+			Value("total").Number().Raw()
+		return total == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// stats are behind auth like the rest of /url
+	e.GET("/url/" + alias + "/stats").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	e.DELETE("/url/"+alias).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+}
+
+func TestURLShortener_List(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// a random prefix keeps other tests' links out of the way
+	prefix := strings.ToLower(random.NewRandomString(8))
+	aliases := []string{prefix + "1", prefix + "2", prefix + "3"}
+
+	for _, alias := range aliases {
+		e.POST("/url").
+			WithJSON(save.Request{
+				URL:   gofakeit.URL(),
+				Alias: alias,
+			}).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusCreated)
+	}
+
+	// walk all pages, two links at a time
+	var got []string
+	cursor := ""
+	for {
+		req := e.GET("/url").
+			WithQuery("alias_prefix", prefix).
+			WithQuery("sort", "alias").
+			WithQuery("order", "asc").
+			WithQuery("limit", 2).
+			WithBasicAuth("myuser", "mypass")
+		if cursor != "" {
+			req = req.WithQuery("cursor", cursor)
+		}
+
+		page := req.Expect().Status(http.StatusOK).JSON().Object()
+		for _, item := range page.Value("urls").Array().Iter() {
+			got = append(got, item.Object().Value("alias").String().Raw())
+		}
+
+		next, ok := page.Raw()["next_cursor"].(string)
+		if !ok {
+			break
+		}
+		cursor = next
+	}
+
+	require.Equal(t, aliases, got)
+
+	for _, alias := range aliases {
+		e.DELETE("/url/"+alias).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusOK)
+	}
+}
+
+func TestURLShortener_Update(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	newURL := gofakeit.URL()
+
+	e.POST("/url").
+		WithJSON(save.Request{
+			URL:   gofakeit.URL(),
+			Alias: alias,
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	e.PATCH("/url/"+alias).
+		WithJSON(map[string]string{"url": newURL}).
+		WithHeader("If-Match", `"1"`).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		Header("ETag").IsEqual(`"2"`)
+
+	testRedirect(t, alias, newURL)
+
+	// somebody else already moved it past version 1
+	e.PATCH("/url/"+alias).
+		WithJSON(map[string]string{"url": gofakeit.URL()}).
+		WithHeader("If-Match", `"1"`).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusPreconditionFailed)
+
+	testRedirect(t, alias, newURL)
+
+	e.PATCH("/url/"+random.NewRandomString(10)).
+		WithJSON(map[string]string{"url": newURL}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+func TestURLShortener_Batch(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	aliases := []string{random.NewRandomString(10), random.NewRandomString(10)}
+	urls := []string{gofakeit.URL(), gofakeit.URL()}
+
+	results := e.POST("/url/batch").
+		WithJSON([]save.Request{
+			{URL: urls[0], Alias: aliases[0]},
+			{URL: urls[1], Alias: aliases[1]},
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().Value("results").Array()
+	results.Length().IsEqual(2)
+
+	testRedirect(t, aliases[0], urls[0])
+	testRedirect(t, aliases[1], urls[1])
+
+	// one taken alias rolls the whole batch back
+	fresh := random.NewRandomString(10)
+	e.POST("/url/batch").
+		WithJSON([]save.Request{
+			{URL: gofakeit.URL(), Alias: fresh},
+			{URL: gofakeit.URL(), Alias: aliases[0]},
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusConflict)
+
+	testRedirectNotFound(t, fresh)
+
+	e.DELETE("/url/batch").
+		WithJSON(aliases).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+
+	testRedirectNotFound(t, aliases[0])
+	testRedirectNotFound(t, aliases[1])
+}
+
+func TestURLShortener_APIKeys(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// the bootstrap admin mints a key
+	minted := e.POST("/admin/keys").
+		WithJSON(map[string]any{"name": "e2e", "scopes": []string{"read", "write"}}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object()
+	key := minted.Value("key").String().Raw()
+	id := int64(minted.Value("id").Number().Raw())
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: gofakeit.URL(), Alias: alias}).
+		WithHeader("Authorization", "Bearer "+key).
+		Expect().
+		Status(http.StatusCreated)
+
+	// not an admin
+	e.GET("/admin/keys").
+		WithHeader("Authorization", "Bearer "+key).
+		Expect().
+		Status(http.StatusForbidden)
+
+	e.DELETE("/admin/keys/{id}", id).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+
+	e.DELETE("/url/"+alias).
+		WithHeader("Authorization", "Bearer "+key).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	e.GET("/url").
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func TestURLShortener_Owners(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	mint := func(owner string) string {
+		return e.POST("/admin/keys").
+			WithJSON(map[string]any{"name": owner, "owner": owner}).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusCreated).
+			JSON().Object().Value("key").String().Raw()
+	}
+	alice := "Bearer " + mint("alice-"+random.NewRandomString(6))
+	bob := "Bearer " + mint("bob-"+random.NewRandomString(6))
+
+	prefix := random.NewRandomString(8)
+	alias := prefix + "a"
+	e.POST("/url").
+		WithJSON(save.Request{URL: gofakeit.URL(), Alias: alias}).
+		WithHeader("Authorization", alice).
+		Expect().
+		Status(http.StatusCreated)
+
+	// bob can't tell the link exists
+	e.GET("/url").
+		WithQuery("alias_prefix", prefix).
+		WithHeader("Authorization", bob).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("urls").Array().IsEmpty()
+	e.GET("/url/{alias}/stats", alias).
+		WithHeader("Authorization", bob).
+		Expect().
+		Status(http.StatusNotFound)
+	e.PATCH("/url/{alias}", alias).
+		WithJSON(map[string]string{"url": gofakeit.URL()}).
+		WithHeader("Authorization", bob).
+		Expect().
+		Status(http.StatusNotFound)
+	e.DELETE("/url/{alias}", alias).
+		WithHeader("Authorization", bob).
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET("/url").
+		WithQuery("alias_prefix", prefix).
+		WithHeader("Authorization", alice).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("urls").Array().Length().IsEqual(1)
+
+	// the admin sees everyone's links
+	e.GET("/url").
+		WithQuery("alias_prefix", prefix).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("urls").Array().Length().IsEqual(1)
+
+	e.DELETE("/url/{alias}", alias).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+}
+
+func TestURLShortener_Health(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// no auth, load balancers and kubelets don't have keys
+	e.GET("/healthz").
+		Expect().
+		Status(http.StatusOK)
+	e.GET("/readyz").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status").IsEqual("OK")
+}
+
+// every write has to reach redirects right away, cached or not
+func TestURLShortener_Cache(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	firstURL := gofakeit.URL()
+	newURL := gofakeit.URL()
+
+	// remembered as missing
+	testRedirectNotFound(t, alias)
+
+	e.POST("/url").
+		WithJSON(save.Request{URL: firstURL, Alias: alias}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	testRedirect(t, alias, firstURL)
+	testRedirect(t, alias, firstURL)
+
+	e.PATCH("/url/"+alias).
+		WithJSON(map[string]string{"url": newURL}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+
+	testRedirect(t, alias, newURL)
+
+	e.DELETE("/url/"+alias).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK)
+
+	testRedirectNotFound(t, alias)
+
+	stats := e.GET("/admin/cache").
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	stats.Value("hits").Number().Gt(0)
+	stats.Value("misses").Number().Gt(0)
+}
+
+func TestURLShortener_Metrics(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	testRedirectNotFound(t, random.NewRandomString(10))
+
+	// no auth, same as the probes
+	body := e.GET("/metrics").
+		Expect().
+		Status(http.StatusOK).
+		Body()
+	body.Contains(`url_shortener_http_requests_total{method="GET",route="/{alias}",status="404"}`)
+	body.Contains("url_shortener_redirects_total")
+}
+
+func TestURLShortener_RedirectCode(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/seo", Alias: alias, RedirectCode: http.StatusMovedPermanently}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusMovedPermanently).
+		Header("Location").IsEqual("https://example.com/seo")
+
+	// not a redirect code
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com", RedirectCode: http.StatusOK}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestURLShortener_Password(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/private", Alias: alias, Password: "open sesame"}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().
+		Value("protected").IsEqual(true)
+
+	// a page asking for the password, not a redirect
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusOK).
+		ContentType("text/html").
+		Body().Contains("Password required").NotContains("example.com")
+
+	e.POST("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithFormField("password", "wrong").
+		Expect().
+		Status(http.StatusForbidden)
+
+	unlocked := e.POST("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithFormField("password", "open sesame").
+		Expect().
+		Status(http.StatusSeeOther)
+	unlocked.Header("Location").IsEqual("https://example.com/private")
+	cookie := unlocked.Cookie("us_unlock")
+
+	// with the cookie there is no prompt anymore
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		WithCookie("us_unlock", cookie.Value().Raw()).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").IsEqual("https://example.com/private")
+
+	// the hash never leaves the server
+	e.GET("/url").
+		WithQuery("alias_prefix", alias).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		Body().Contains(`"protected":true`).NotContains("$2a$")
+}
+
+func TestURLShortener_MaxClicks(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/secret", Alias: alias, MaxClicks: 1}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	testRedirect(t, alias, "https://example.com/secret")
+
+	// burned after the first visit
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusGone).
+		Body().Contains("url has no clicks left")
+
+	link := e.GET("/url").
+		WithQuery("alias_prefix", alias).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("urls").Array().Value(0).Object()
+	link.Value("max_clicks").IsEqual(1)
+	link.Value("clicks_left").IsEqual(0)
+}
+
+func TestURLShortener_NotBefore(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/launch", Alias: alias, NotBefore: &notBefore}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().
+		Value("not_before").String().AsDateTime(time.RFC3339).IsEqual(notBefore)
+
+	// not live yet, and without REDIRECT_INACTIVE_URL it looks like no link at all
+	e.GET("/{alias}", alias).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusNotFound)
+
+	list := func(state string) *httpexpect.Array {
+		return e.GET("/url").
+			WithQuery("alias_prefix", alias).
+			WithQuery("state", state).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().
+			Value("urls").Array()
+	}
+	list("scheduled").Length().IsEqual(1)
+	list("active").IsEmpty()
+	list("expired").IsEmpty()
+
+	e.GET("/url").
+		WithQuery("state", "paused").
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestURLShortener_Forward(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	forwarding := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/docs?ref=short", Alias: forwarding, ForwardPath: true, ForwardQuery: true}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	e.GET("/{alias}/guide/intro", forwarding).
+		WithQuery("ref", "visitor").
+		WithQuery("q", "a b").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").IsEqual("https://example.com/docs/guide/intro?ref=short&q=a+b")
+
+	// links that don't opt in have nothing below their alias
+	plain := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com/docs", Alias: plain}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	e.GET("/{alias}/guide", plain).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusNotFound)
+
+	// URLFormat routes /{alias}.json as /{alias}, the suffix is not a path to forward
+	for _, alias := range []string{forwarding, plain} {
+		e.GET("/{alias}.json", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().
+			Status(http.StatusFound).
+			Header("Location").HasPrefix("https://example.com/docs").NotContains(".json")
+	}
+	e.GET("/{alias}/guide/intro.json", forwarding).
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").IsEqual("https://example.com/docs/guide/intro.json?ref=short")
+}
+
+func TestURLShortener_UTM(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// tagged on save, the stored destination has the params
+	onSave := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{
+			URL:   "https://example.com/sale?utm_source=partner",
+			Alias: onSave,
+			UTM:   &utm.Params{Source: "newsletter", Medium: "email", Campaign: "spring sale"},
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().
+		Value("url").IsEqual("https://example.com/sale?utm_source=partner&utm_medium=email&utm_campaign=spring+sale")
+
+	testRedirect(t, onSave, "https://example.com/sale?utm_source=partner&utm_medium=email&utm_campaign=spring+sale")
+
+	// tagged on redirect, the destination stays as it was sent
+	onRedirect := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{
+			URL:   "https://example.com/sale",
+			Alias: onRedirect,
+			UTM:   &utm.Params{Campaign: "spring"},
+			UTMAt: "redirect",
+		}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().
+		Value("url").IsEqual("https://example.com/sale")
+
+	testRedirect(t, onRedirect, "https://example.com/sale?utm_campaign=spring")
+
+	e.GET("/url").
+		WithQuery("alias_prefix", onRedirect).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().
+		Value("urls").Array().Value(0).Object().
+		Value("utm").Object().IsEqual(map[string]string{"campaign": "spring"})
+}
+
+func TestURLShortener_URLPolicy(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// only what the default policy refuses, so it works against E2E_HOST too
+	for _, dest := range []string{
+		"javascript:alert(document.cookie)",
+		"http://169.254.169.254/latest/meta-data",
+		// a short link pointing back at the shortener
+		"http://" + host + "/abc",
+	} {
+		e.POST("/url").
+			WithJSON(save.Request{URL: dest}).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusBadRequest).
+			JSON().Object().
+			Value("error").String().HasPrefix("field URL is not allowed")
+	}
+
+	alias := random.NewRandomString(10)
+	e.POST("/url").
+		WithJSON(save.Request{URL: "https://example.com", Alias: alias}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusCreated)
+
+	// update goes through the same checks
+	e.PATCH("/url/{alias}", alias).
+		WithJSON(map[string]string{"url": "javascript:alert(1)"}).
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestURLShortener_ReservedAlias(t *testing.T) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+	}
+	e := httpexpect.Default(t, u.String())
+
+	// all of them are routes, a link there would never be reached
+	for _, reserved := range []string{"healthz", "readyz", "admin", "URL"} {
+		e.POST("/url").
+			WithJSON(save.Request{URL: "https://example.com", Alias: reserved}).
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusBadRequest).
+			JSON().Object().
+			Value("error").IsEqual("field Alias is not allowed: alias is reserved")
+	}
+}
+
+// a server of its own, the shared one runs without limits so the other tests don't trip over them
+func TestURLShortener_RateLimit(t *testing.T) {
+	configuration := &config.Config{
+		HTTPServer: config.HTTPServer{User: "myuser", Password: "mypass"},
+		Alias:      config.Alias{Attempts: 5},
+		Redirect:   config.Redirect{Code: http.StatusFound},
+		RateLimit: config.RateLimit{
+			APIRate: 0.01, APIBurst: 2,
+			RedirectRate: 0.01, RedirectBurst: 3,
+			AuthFailureRate: 0.01, AuthFailureBurst: 3,
+		},
+	}
+
+	urlPolicy, err := urlpolicy.New(urlpolicy.Options{})
+	require.NoError(t, err)
+
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New()
+	srv := httptest.NewServer(router.New(log, configuration, storage, nil, clicks.New(log, storage, 16, 16, time.Hour), alias.Random{Length: 6}, urlPolicy, nil, nil, nil, 0))
+	defer srv.Close()
+
+	e := httpexpect.Default(t, srv.URL)
+
+	for i := 0; i < 2; i++ {
+		e.GET("/url").
+			WithBasicAuth("myuser", "mypass").
+			Expect().
+			Status(http.StatusOK).
+			Header("RateLimit-Limit").IsEqual("2")
+	}
+	limited := e.GET("/url").
+		WithBasicAuth("myuser", "mypass").
+		Expect().
+		Status(http.StatusTooManyRequests)
+	limited.Header("Retry-After").NotEmpty()
+	limited.JSON().Object().Value("error").IsEqual("rate limit exceeded")
+
+	// redirects have their own limit, per ip
+	for i := 0; i < 3; i++ {
+		e.GET("/missing").
+			Expect().
+			Status(http.StatusNotFound).
+			Header("RateLimit-Remaining").IsEqual(strconv.Itoa(2 - i))
+	}
+	e.GET("/missing").
+		Expect().
+		Status(http.StatusTooManyRequests)
+
+	// another client behind the same proxy isn't affected
+	e.GET("/missing").
+		WithHeader("X-Real-IP", "203.0.113.7").
+		Expect().
+		Status(http.StatusNotFound)
+
+	// password guessing runs into the failed login limit, the api limit only sees requests that got in
+	guesser := "198.51.100.9"
+	for i := 0; i < 3; i++ {
+		e.GET("/url").
+			WithHeader("X-Real-IP", guesser).
+			WithBasicAuth("myuser", "guess"+strconv.Itoa(i)).
+			Expect().
+			Status(http.StatusUnauthorized)
+	}
+	e.GET("/admin/keys").
+		WithHeader("X-Real-IP", guesser).
+		WithBasicAuth("myuser", "guess").
+		Expect().
+		Status(http.StatusTooManyRequests).
+		Header("Retry-After").NotEmpty()
+}
+
+func TestURLShortener_PasswordGuessing(t *testing.T) {
+	configuration := &config.Config{
+		Alias:     config.Alias{Attempts: 5},
+		Redirect:  config.Redirect{Code: http.StatusFound},
+		RateLimit: config.RateLimit{AuthFailureRate: 0.01, AuthFailureBurst: 3},
+	}
+
+	urlPolicy, err := urlpolicy.New(urlpolicy.Options{})
+	require.NoError(t, err)
+
+	log := slogdiscard.NewDiscardLogger()
+	links := memory.New()
+	for _, alias := range []string{"secret", "other"} {
+		hash, err := password.Hash("open sesame")
+		require.NoError(t, err)
+		_, err = links.SaveURL(t.Context(), storage.URL{URL: "https://example.com/" + alias, Alias: alias, PasswordHash: hash})
+		require.NoError(t, err)
+	}
+
+	srv := httptest.NewServer(router.New(log, configuration, links, nil, clicks.New(log, links, 16, 16, time.Hour), alias.Random{Length: 6}, urlPolicy, nil, nil, nil, 0))
+	defer srv.Close()
+
+	e := httpexpect.Default(t, srv.URL)
+	guess := func(alias string, ip string, pass string) *httpexpect.Response {
+		return e.POST("/{alias}", alias).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithHeader("X-Real-IP", ip).
+			WithFormField("password", pass).
+			Expect()
+	}
+
+	guesser := "198.51.100.9"
+	for i := 0; i < 3; i++ {
+		guess("secret", guesser, "guess"+strconv.Itoa(i)).Status(http.StatusForbidden)
+	}
+	locked := guess("secret", guesser, "open sesame").Status(http.StatusTooManyRequests)
+	locked.Header("Retry-After").NotEmpty()
+	locked.JSON().Object().Value("error").IsEqual("too many failed attempts")
+
+	// the guesser can still unlock other links, and other visitors this one
+	guess("other", guesser, "open sesame").Status(http.StatusSeeOther)
+	guess("secret", "203.0.113.7", "open sesame").Status(http.StatusSeeOther)
+}
+
 func testRedirect(t *testing.T, alias string, urlToRedirect string) {
 	u := url.URL{
 		Scheme: "http",